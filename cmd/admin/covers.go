@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/covers"
+	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/storage"
+)
+
+// fetchCovers downloads the covers of books saved while covers were still
+// hotlinked, storing them the way the dashboard stores new ones. Covers that
+// can't be fetched are listed and left as they are.
+func fetchCovers(args []string) error {
+	var dsn, mediaDir string
+
+	fs := newFlagSet("fetch-covers", &dsn)
+	fs.StringVar(&mediaDir, "media-dir", "./media", "Directory for uploaded cover images")
+	fs.Parse(args)
+
+	db, err := openDB(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	books := data.NewModels(db).Books
+	remote, err := books.RemoteCovers()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int, 0, len(remote))
+	for id := range remote {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	c := covers.New(storage.FileStore{Dir: mediaDir})
+	fetched, failed := 0, 0
+	for _, id := range ids {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		stored, err := c.Fetch(ctx, remote[id])
+		cancel()
+
+		var invalid *covers.InvalidError
+		if errors.As(err, &invalid) {
+			failed++
+			fmt.Printf("book %d: %s left as is: %s\n", id, remote[id], invalid.Reason)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		ev := auditEvent(data.AuditBookCover, data.AuditTargetBook)
+		err = books.SetCoverImage(id, stored, ev)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			fmt.Printf("book %d: moved to the trash, skipped\n", id)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fetched++
+		fmt.Printf("book %d: %s -> %s\n", id, remote[id], stored)
+	}
+
+	fmt.Printf("%d covers fetched, %d need attention\n", fetched, failed)
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/marc"
+)
+
+// importBooks runs a CSV or MARC import the same way as the dashboard, recording it
+// as a job so it is listed there too. Invalid rows are printed, and the full
+// report can be written to a file.
+func importBooks(args []string) error {
+	var dsn, file, mapping, reportPath string
+	var dryRun bool
+	var batchSize int
+
+	fs := newFlagSet("import", &dsn)
+	fs.StringVar(&file, "file", "", "CSV file, or MARC file (.mrc, .marc or .xml), to import")
+	fs.StringVar(&mapping, "map", "", `Column mapping, e.g. "title=Book Title,isbn=ISBN13"`)
+	fs.BoolVar(&dryRun, "dry-run", false, "Check every row without saving")
+	fs.IntVar(&batchSize, "batch-size", data.DefaultImportBatchSize, "Rows saved per transaction")
+	fs.StringVar(&reportPath, "report", "", "Write the report of every row to this CSV file")
+	fs.Parse(args)
+
+	if file == "" {
+		return errors.New("import: -file is required")
+	}
+
+	m, err := data.ParseImportMapping(mapping)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	db, err := openDB(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	models := data.NewModels(db)
+
+	job, err := models.Imports.Start(nil, filepath.Base(file), dryRun)
+	if err != nil {
+		return err
+	}
+
+	opts := data.ImportOptions{
+		Mapping:   m,
+		DryRun:    dryRun,
+		BatchSize: batchSize,
+		Audit:     auditEvent(data.AuditBookImport, data.AuditTargetBook),
+	}
+
+	var report *data.ImportReport
+	var importErr error
+	if marc.HasMARCExtension(file) {
+		report, importErr = models.Books.ImportFrom(marc.NewImportSource(f), opts)
+	} else {
+		report, importErr = models.Books.Import(f, opts)
+	}
+
+	err = models.Imports.Finish(job, report, importErr)
+	if err != nil {
+		return err
+	}
+
+	if report != nil {
+		for _, row := range report.Rows {
+			if row.Action == data.ImportInvalid {
+				fmt.Printf("line %d (%s): %v\n", row.Line, row.ISBN, row.Errors)
+			}
+		}
+
+		if reportPath != "" {
+			err = writeReport(reportPath, report)
+			if err != nil {
+				return err
+			}
+		}
+
+		verb := ""
+		if dryRun {
+			verb = " would be"
+		}
+		fmt.Printf("%d%s created, %d%s updated, %d invalid\n",
+			report.Created, verb, report.Updated, verb, report.Invalid)
+	}
+
+	return importErr
+}
+
+func writeReport(path string, report *data.ImportReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = report.WriteCSV(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
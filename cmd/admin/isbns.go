@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+)
+
+// normalizeISBNs is the one-off clean-up for ISBNs saved before they were
+// validated: it converts them to ISBN-13 and lists the ones that need fixing
+// by hand.
+func normalizeISBNs(args []string) error {
+	var dsn string
+	var dryRun bool
+
+	fs := newFlagSet("normalize-isbns", &dsn)
+	fs.BoolVar(&dryRun, "dry-run", false, "Report the changes without saving them")
+	fs.Parse(args)
+
+	db, err := openDB(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	changes, err := data.NewModels(db).Books.NormalizeISBNs(dryRun)
+	if err != nil {
+		return err
+	}
+
+	updated, problems := 0, 0
+	for _, c := range changes {
+		if c.Problem != "" {
+			problems++
+			fmt.Printf("book %d: %q left as is: %s\n", c.BookID, c.From, c.Problem)
+			continue
+		}
+		updated++
+		fmt.Printf("book %d: %s -> %s\n", c.BookID, c.From, c.To)
+	}
+
+	verb := "updated"
+	if dryRun {
+		verb = "would be updated"
+	}
+	fmt.Printf("%d books %s, %d need attention\n", updated, verb, problems)
+	return nil
+}
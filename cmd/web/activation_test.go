@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/logger"
+	"github.com/0xrinful/LibraryMS/internal/mailer"
+)
+
+// accountStore keeps users and tokens in memory for the signup and
+// activation handlers.
+type accountStore struct {
+	mu     sync.Mutex
+	users  map[int64]*data.User
+	tokens map[[32]byte]*data.Token
+}
+
+// memoryUsers and memoryTokens implement the model methods the signup and
+// activation handlers call. The embedded models have no database, so any
+// other method panics.
+type memoryUsers struct {
+	data.UserModel
+	store *accountStore
+}
+
+type memoryTokens struct {
+	data.TokenModel
+	store *accountStore
+}
+
+func (m memoryUsers) Insert(user *data.User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, u := range m.store.users {
+		if u.Email == user.Email {
+			return data.ErrDuplicateEmail
+		}
+	}
+
+	user.ID = int64(len(m.store.users) + 1)
+	user.CreatedAt = time.Now()
+	user.Role = "member"
+	user.Version = 1
+	stored := *user
+	m.store.users[user.ID] = &stored
+	return nil
+}
+
+func (m memoryUsers) Get(id int64) (*data.User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	u, ok := m.store.users[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	user := *u
+	return &user, nil
+}
+
+func (m memoryUsers) GetForToken(purpose, tokenPlaintext string) (*data.User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	t, ok := m.store.tokens[sha256.Sum256([]byte(tokenPlaintext))]
+	if !ok || t.Purpose != purpose || (t.Expiry != nil && time.Now().After(*t.Expiry)) {
+		return nil, data.ErrRecordNotFound
+	}
+	user := *m.store.users[t.UserID]
+	return &user, nil
+}
+
+func (m memoryUsers) Update(user *data.User, ev *data.AuditEvent) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return data.ErrRecordNotFound
+	}
+	user.Version++
+	*stored = *user
+	return nil
+}
+
+func (m memoryTokens) New(userID int64, ttl time.Duration, purpose string) (*data.Token, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	expiry := time.Now().Add(ttl)
+	token := &data.Token{
+		Plaintext: rand.Text(),
+		UserID:    userID,
+		Purpose:   purpose,
+		Expiry:    &expiry,
+	}
+	m.store.tokens[sha256.Sum256([]byte(token.Plaintext))] = token
+	return token, nil
+}
+
+func (m memoryTokens) DeleteAllForUser(purpose string, userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, t := range m.store.tokens {
+		if t.UserID == userID && t.Purpose == purpose {
+			delete(m.store.tokens, hash)
+		}
+	}
+	return nil
+}
+
+func newTestApplication(t *testing.T, sender mailer.Sender) (*application, *accountStore) {
+	t.Helper()
+
+	cache, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &accountStore{
+		users:  map[int64]*data.User{},
+		tokens: map[[32]byte]*data.Token{},
+	}
+
+	app := &application{
+		logger: logger.New(io.Discard, logger.LevelOff),
+		models: data.Models{
+			Users:  memoryUsers{store: store},
+			Tokens: memoryTokens{store: store},
+		},
+		templateCache: cache,
+		session:       scs.New(),
+		mailer:        mailer.New(sender, "LibraryMS <no-reply@libraryms.local>"),
+		done:          make(chan struct{}),
+	}
+	app.config.baseURL = "http://libraryms.test"
+
+	return app, store
+}
+
+func postForm(t *testing.T, h http.Handler, path string, form url.Values) *http.Response {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return rr.Result()
+}
+
+func TestSignupActivation(t *testing.T) {
+	sender := &mailer.MemorySender{}
+	app, store := newTestApplication(t, sender)
+	routes := app.routes()
+
+	res := postForm(t, routes, "/signup", url.Values{
+		"name":             {"Ursula"},
+		"email":            {"ursula@example.com"},
+		"password":         {"pa55word-earthsea"},
+		"confirm_password": {"pa55word-earthsea"},
+	})
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("signup: status = %d, want %d", res.StatusCode, http.StatusSeeOther)
+	}
+
+	// The email is sent in the background.
+	app.wg.Wait()
+
+	messages := sender.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(messages))
+	}
+	if messages[0].To != "ursula@example.com" {
+		t.Errorf("email sent to %q", messages[0].To)
+	}
+
+	link := regexp.MustCompile(`http://libraryms\.test/activate\?token=\S+`).FindString(messages[0].PlainBody)
+	if link == "" {
+		t.Fatalf("no activation link in email:\n%s", messages[0].PlainBody)
+	}
+	activationURL, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := activationURL.Query().Get("token")
+
+	// Following the link shows the form with the token filled in.
+	r := httptest.NewRequest(http.MethodGet, activationURL.RequestURI(), nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /activate: status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), token) {
+		t.Errorf("activation form does not contain the token")
+	}
+
+	res = postForm(t, routes, "/activate", url.Values{"token": {token}})
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/login" {
+		t.Fatalf("activate: status = %d, location %q; want %d, /login",
+			res.StatusCode, res.Header.Get("Location"), http.StatusSeeOther)
+	}
+
+	user, err := app.models.Users.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Activated {
+		t.Errorf("user is not activated")
+	}
+	if len(store.tokens) != 0 {
+		t.Errorf("%d tokens left after activation, want 0", len(store.tokens))
+	}
+
+	res = postForm(t, routes, "/activate", url.Values{"token": {token}})
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("second activation: status = %d, want %d",
+			res.StatusCode, http.StatusUnprocessableEntity)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "invalid or expired activation token") {
+		t.Errorf("second activation did not report an invalid token")
+	}
+}
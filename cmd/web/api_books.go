@@ -0,0 +1,554 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+func (app *application) apiListBooks(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	p := app.readPagination(r.URL.Query(), 20, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	books, metadata, err := app.models.Books.GetBooks(p)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			app.failedValidationResponse(w, map[string]string{"cursor": "invalid cursor"})
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"books": books, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiSearchBooks(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readSearchFilters(r.URL.Query(), v)
+	p := app.readPagination(r.URL.Query(), 20, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	result, err := app.models.Books.Search(filters, p)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			app.failedValidationResponse(w, map[string]string{"cursor": "invalid cursor"})
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"books":    result.Books,
+		"facets":   result.Facets,
+		"metadata": result.Metadata,
+		"fuzzy":    result.Fuzzy,
+	}
+	if result.Suggestion != "" {
+		env["suggestion"] = result.Suggestion
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiShowBook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	book, err := app.models.Books.GetBookByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+type contributorInput struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// bookInput is the body of a create or update request. Contributors can be
+// given in full, or as an author string in the same format as the dashboard
+// form, e.g. "Ursula K. Le Guin; Jane Doe (translator)". EditionOf is the ISBN
+// of another edition of the same work.
+type bookInput struct {
+	Title        *string            `json:"title"`
+	Author       *string            `json:"author"`
+	Contributors []contributorInput `json:"contributors"`
+	ISBN         *string            `json:"isbn"`
+	Description  *string            `json:"description"`
+	CoverImage   *string            `json:"cover_image"`
+	Genres       []string           `json:"genres"`
+	Pages        *int               `json:"pages"`
+	Language     *string            `json:"language"`
+	Publisher    *string            `json:"publisher"`
+	ItemType     *string            `json:"item_type"`
+	PublishDate  *string            `json:"publish_date"`
+	CopiesTotal  *int               `json:"copies_total"`
+	EditionOf    *string            `json:"edition_of"`
+}
+
+// apply copies every field present in the input onto book, trimming strings
+// the same way the dashboard forms do.
+func (input *bookInput) apply(book *data.Book, v *validator.Validator) {
+	if input.Title != nil {
+		book.Title = strings.TrimSpace(*input.Title)
+	}
+	if input.Contributors != nil {
+		book.Contributors = []data.BookContributor{}
+		for _, c := range input.Contributors {
+			role := strings.ToLower(strings.TrimSpace(c.Role))
+			if role == "" {
+				role = data.ContributorAuthor
+			}
+			book.Contributors = append(book.Contributors, data.BookContributor{
+				Name: strings.TrimSpace(c.Name),
+				Role: role,
+			})
+		}
+	} else if input.Author != nil {
+		book.Contributors = data.ParseContributors(*input.Author)
+	}
+	if input.ISBN != nil {
+		book.ISBN = strings.TrimSpace(*input.ISBN)
+	}
+	if input.Description != nil {
+		book.Description = strings.TrimSpace(*input.Description)
+	}
+	if input.CoverImage != nil {
+		book.CoverImage = strings.TrimSpace(*input.CoverImage)
+	}
+	if input.Genres != nil {
+		book.Genres = splitAndTrim(strings.Join(input.Genres, ","))
+	}
+	if input.Pages != nil {
+		book.Pages = *input.Pages
+	}
+	if input.Language != nil {
+		book.Language = strings.TrimSpace(*input.Language)
+	}
+	if input.Publisher != nil {
+		book.Publisher = strings.TrimSpace(*input.Publisher)
+	}
+	if input.ItemType != nil {
+		book.ItemType = strings.TrimSpace(*input.ItemType)
+	}
+	if input.PublishDate != nil {
+		publishDate, err := time.Parse("2006-01-02", *input.PublishDate)
+		if err != nil {
+			v.AddError("publish_date", "Publish date must be in YYYY-MM-DD format")
+		} else {
+			book.PublishDate = publishDate
+		}
+	}
+	if input.CopiesTotal != nil {
+		if book.ID != 0 {
+			v.AddError("copies_total", "Copies of an existing book are managed individually")
+		} else {
+			book.CopiesTotal = *input.CopiesTotal
+		}
+	}
+}
+
+func (app *application) apiCreateBook(w http.ResponseWriter, r *http.Request) {
+	var input bookInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, err)
+		return
+	}
+
+	book := &data.Book{
+		Language:    "English",
+		PublishDate: time.Now(),
+		Genres:      []string{},
+		CopiesTotal: 1,
+	}
+	v := validator.New()
+	input.apply(book, v)
+
+	data.ValidateBook(v, book)
+
+	if input.EditionOf != nil {
+		err = app.setEditionOf(book, *input.EditionOf, v)
+		if err != nil {
+			app.serverErrorResponse(w, err)
+			return
+		}
+	}
+
+	if book.ISBN != "" {
+		exists, err := app.models.Books.ISBNExists(book.ISBN)
+		if err != nil {
+			app.serverErrorResponse(w, err)
+			return
+		}
+		if exists {
+			v.AddError("isbn", "A book with this ISBN already exists")
+		}
+	}
+
+	if v.Valid() {
+		book.CoverImage, err = app.saveCover(r.Context(), nil, book.CoverImage, "", v)
+		if err != nil {
+			app.serverErrorResponse(w, err)
+			return
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditBookCreate, data.AuditTargetBook, int64(book.ID), nil, book)
+	err = app.models.Books.Insert(book, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateISBN):
+			v.AddError("isbn", "A book with this ISBN already exists")
+			app.failedValidationResponse(w, v.Errors)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", "/v1/books/"+strconv.Itoa(book.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"book": book}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiUpdateBook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	book, err := app.models.Books.GetBookByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	var input bookInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, err)
+		return
+	}
+
+	before := *book
+	currentCover := book.CoverImage
+	v := validator.New()
+	input.apply(book, v)
+	data.ValidateBook(v, book)
+
+	if input.EditionOf != nil {
+		err = app.setEditionOf(book, *input.EditionOf, v)
+		if err != nil {
+			app.serverErrorResponse(w, err)
+			return
+		}
+	}
+
+	if book.ISBN != "" {
+		exists, err := app.models.Books.ISBNExistsExcluding(book.ISBN, id)
+		if err != nil {
+			app.serverErrorResponse(w, err)
+			return
+		}
+		if exists {
+			v.AddError("isbn", "A book with this ISBN already exists")
+		}
+	}
+
+	if v.Valid() {
+		book.CoverImage, err = app.saveCover(r.Context(), nil, book.CoverImage, currentCover, v)
+		if err != nil {
+			app.serverErrorResponse(w, err)
+			return
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditBookUpdate, data.AuditTargetBook, int64(book.ID), &before, book)
+	err = app.models.Books.Update(book, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		case errors.Is(err, data.ErrDuplicateISBN):
+			v.AddError("isbn", "A book with this ISBN already exists")
+			app.failedValidationResponse(w, v.Errors)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiDeleteBook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	book, err := app.models.Books.GetBookByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditBookDelete, data.AuditTargetBook, int64(id), book, nil)
+	err = app.models.Books.Delete(id, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book moved to the trash"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiListCopies(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	_, err = app.models.Books.GetBookByID(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	copies, err := app.models.Copies.GetAllForBook(id)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copies": copies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+type copyInput struct {
+	Barcode    *string `json:"barcode"`
+	AcquiredAt *string `json:"acquired_at"`
+	Condition  *string `json:"condition"`
+	Status     *string `json:"status"`
+	Notes      *string `json:"notes"`
+}
+
+func (input *copyInput) apply(c *data.Copy, v *validator.Validator) {
+	if input.Barcode != nil {
+		c.Barcode = strings.TrimSpace(*input.Barcode)
+	}
+	if input.AcquiredAt != nil {
+		acquiredAt, err := time.Parse("2006-01-02", *input.AcquiredAt)
+		if err != nil {
+			v.AddError("acquired_at", "Acquisition date must be in YYYY-MM-DD format")
+		} else {
+			c.AcquiredAt = acquiredAt
+		}
+	}
+	if input.Condition != nil {
+		c.Condition = *input.Condition
+	}
+	if input.Status != nil {
+		c.Status = *input.Status
+	}
+	if input.Notes != nil {
+		c.Notes = strings.TrimSpace(*input.Notes)
+	}
+}
+
+func (app *application) apiCreateCopy(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || bookID < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	var input copyInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, err)
+		return
+	}
+
+	c := &data.Copy{
+		BookID:     bookID,
+		AcquiredAt: time.Now(),
+		Condition:  "new",
+		Status:     data.CopyAvailable,
+	}
+	v := validator.New()
+	input.apply(c, v)
+
+	if data.ValidateCopy(v, c, ""); !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditCopyCreate, data.AuditTargetCopy, 0, nil, c)
+	err = app.models.Copies.Insert(c, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			v.AddError("barcode", "A copy with this barcode already exists")
+			app.failedValidationResponse(w, v.Errors)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/copies/%d", c.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"copy": c}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiUpdateCopy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	c, err := app.models.Copies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	var input copyInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, err)
+		return
+	}
+
+	before := *c
+	previous := c.Status
+	v := validator.New()
+	input.apply(c, v)
+
+	if data.ValidateCopy(v, c, previous); !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditCopyUpdate, data.AuditTargetCopy, c.ID, &before, c)
+	err = app.models.Copies.Update(c, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			v.AddError("barcode", "A copy with this barcode already exists")
+			app.failedValidationResponse(w, v.Errors)
+		case errors.Is(err, data.ErrCopyStatusChanged):
+			app.conflictResponse(w, "the copy's status changed while you were editing it, please try again")
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copy": c}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+func (app *application) apiBorrowBook(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || bookID < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	required, err := app.activationRequired(user, data.ActivationRequiredBorrowing)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+	if required {
+		app.inactiveAccountResponse(w)
+		return
+	}
+
+	err = app.models.Books.BorrowBook(user.ID, bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAlreadyBorrowed):
+			app.conflictResponse(w, "you already borrowed this book")
+		case errors.Is(err, data.ErrLoanLimitReached):
+			app.conflictResponse(w, "you have reached your loan limit")
+		case errors.Is(err, data.ErrFinesOutstanding):
+			app.finesOutstandingResponse(w)
+		case errors.Is(err, data.ErrNoAvailableCopies):
+			app.conflictResponse(w, "no available copies right now")
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"message": "book borrowed successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiReturnBook(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || bookID < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Books.ReturnBook(user.ID, bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book returned successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiRenewBook(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || bookID < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	required, err := app.activationRequired(user, data.ActivationRequiredBorrowing)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+	if required {
+		app.inactiveAccountResponse(w)
+		return
+	}
+
+	renewal, err := app.models.Renewals.Renew(user.ID, bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRenewalLimitReached):
+			app.conflictResponse(w, "this loan has reached the maximum number of renewals")
+		case errors.Is(err, data.ErrBookOnHold):
+			app.conflictResponse(w, "other members are waiting for this book")
+		case errors.Is(err, data.ErrFinesOutstanding):
+			app.finesOutstandingResponse(w)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"renewal": renewal}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiListRenewals(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	limit := app.readInt(qs, "limit", 50, v)
+	v.Check(limit >= 1 && limit <= 500, "limit", "must be between 1 and 500")
+	if !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	renewals, err := app.models.Renewals.GetRecent(limit)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"renewals": renewals}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiListFines(w http.ResponseWriter, r *http.Request) {
+	userID := app.contextGetUser(r).ID
+
+	balance, err := app.models.Fines.Balance(userID)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	ledger, err := app.models.Fines.GetLedger(userID)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"balance_cents": balance, "transactions": ledger}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiCurrentBorrows(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	borrows, err := app.models.BorrowRecord.GetCurrentBorrows(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"borrows": borrows}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiBorrowHistory(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	history, err := app.models.BorrowRecord.GetBorrowHistory(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"borrows": history}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
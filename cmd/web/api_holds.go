@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+)
+
+func (app *application) apiPlaceHold(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || bookID < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	required, err := app.activationRequired(user, data.ActivationRequiredBorrowing)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+	if required {
+		app.inactiveAccountResponse(w)
+		return
+	}
+
+	_, err = app.models.Holds.Place(user.ID, bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateHold):
+			app.conflictResponse(w, "you already have a hold on this book or another edition of it")
+		case errors.Is(err, data.ErrAlreadyBorrowed):
+			app.conflictResponse(w, "you already borrowed this book")
+		case errors.Is(err, data.ErrHoldNotNeeded):
+			app.conflictResponse(w, "copies of this or another edition are available, borrow the book instead")
+		case errors.Is(err, data.ErrHoldLimitReached):
+			app.conflictResponse(w, "you have reached your hold limit")
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	// Re-read the hold so the response includes the queue position.
+	hold, err := app.models.Holds.GetActive(user.ID, bookID)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/holds/%d", hold.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"hold": hold}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiListHolds(w http.ResponseWriter, r *http.Request) {
+	holds, err := app.models.Holds.GetActiveForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"holds": holds}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiCancelHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || holdID < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	err = app.models.Holds.Cancel(app.contextGetUser(r).ID, holdID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "hold cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
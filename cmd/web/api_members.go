@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+func (app *application) apiShowCurrentMember(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"member": app.contextGetUser(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiListMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := app.models.Users.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiShowMember(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	member, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiUpdateMember(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	member, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		Email     *string `json:"email"`
+		Role      *string `json:"role"`
+		Tier      *string `json:"tier"`
+		Activated *bool   `json:"activated"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, err)
+		return
+	}
+
+	before := *member
+	if input.Name != nil {
+		member.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.Email != nil {
+		member.Email = strings.TrimSpace(*input.Email)
+	}
+	if input.Role != nil {
+		member.Role = strings.TrimSpace(*input.Role)
+	}
+	// An empty tier takes the member out of their tier.
+	if input.Tier != nil {
+		member.Tier = nil
+		if tier := strings.TrimSpace(*input.Tier); tier != "" {
+			member.Tier = &tier
+		}
+	}
+	if input.Activated != nil {
+		member.Activated = *input.Activated
+	}
+
+	v := validator.New()
+	if data.ValidateMember(v, member); !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditMemberUpdate, data.AuditTargetMember, member.ID, &before, member)
+	err = app.models.Users.Update(member, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "Email address already in use")
+			app.failedValidationResponse(w, v.Errors)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiDeleteMember(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	if app.contextGetUser(r).ID == id {
+		app.conflictResponse(w, "you cannot delete your own account")
+		return
+	}
+
+	member, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditMemberDelete, data.AuditTargetMember, id, member, nil)
+	err = app.models.Users.Delete(id, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		case errors.Is(err, data.ErrMemberHasLoans):
+			app.conflictResponse(w, "this member still has books on loan")
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member moved to the trash"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiShowMemberFines(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	balance, err := app.models.Fines.Balance(id)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	ledger, err := app.models.Fines.GetLedger(id)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"balance_cents": balance, "transactions": ledger}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiRecordFineTransaction(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w)
+		return
+	}
+
+	_, err = app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	var input struct {
+		Kind        string `json:"kind"`
+		AmountCents int    `json:"amount_cents"`
+		Note        string `json:"note"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, err)
+		return
+	}
+
+	balance, err := app.models.Fines.Balance(id)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	adminID := app.contextGetUser(r).ID
+	t := &data.FineTransaction{
+		UserID:      id,
+		Kind:        input.Kind,
+		AmountCents: input.AmountCents,
+		Note:        strings.TrimSpace(input.Note),
+		CreatedBy:   &adminID,
+	}
+
+	v := validator.New()
+	if data.ValidateFineTransaction(v, t, balance); !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	ev := app.auditEvent(r, fineAuditAction(t.Kind), data.AuditTargetMember, t.UserID, nil, t)
+	err = app.models.Fines.Record(t, ev)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"transaction": t}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) apiActivateUser(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.Token); !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	err = app.activateUser(input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, v.Errors)
+		default:
+			app.serverErrorResponse(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account activated"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
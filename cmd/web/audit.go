@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+// auditEvent describes a change the current user is about to make, for the
+// model to record in the same transaction as the change. Before and after
+// are the record's state either side of it (nil for a record created or
+// deleted).
+func (app *application) auditEvent(
+	r *http.Request,
+	action, targetType string,
+	targetID int64,
+	before, after any,
+) *data.AuditEvent {
+	user := app.contextGetUser(r)
+	return &data.AuditEvent{
+		ActorID:    user.ID,
+		ActorEmail: user.Email,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         clientIP(r),
+		Before:     before,
+		After:      after,
+	}
+}
+
+// fineAuditAction is the audit action for a fine transaction of the kind.
+func fineAuditAction(kind string) string {
+	if kind == data.FineWaiver {
+		return data.AuditFineWaiver
+	}
+	return data.AuditFinePayment
+}
+
+// clientIP returns the address the request came from, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// readAuditFilters reads the audit log filters from the query string.
+func (app *application) readAuditFilters(qs url.Values, v *validator.Validator) data.AuditFilters {
+	f := data.AuditFilters{
+		Actor:      strings.TrimSpace(qs.Get("actor")),
+		Action:     qs.Get("action"),
+		TargetType: qs.Get("target_type"),
+	}
+
+	if s := strings.TrimSpace(qs.Get("target_id")); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			v.AddError("target_id", "Target ID must be a number")
+		}
+		f.TargetID = id
+	}
+
+	for key, dst := range map[string]**time.Time{"from": &f.Period.From, "to": &f.Period.To} {
+		if s := qs.Get(key); s != "" {
+			t, err := time.Parse("2006-01-02", s)
+			if err != nil {
+				v.AddError(key, "Dates must be in YYYY-MM-DD format")
+				continue
+			}
+			*dst = &t
+		}
+	}
+
+	data.ValidateAuditFilters(v, f)
+	return f
+}
+
+func (app *application) auditLog(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+	filters := app.readAuditFilters(qs, v)
+	p := app.readPagination(qs, 50, v)
+
+	if !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/dashboard/audit", http.StatusSeeOther)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(filters, p)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			app.badRequest(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	export := url.Values{}
+	for _, key := range []string{"actor", "action", "target_type", "target_id", "from", "to"} {
+		if value := qs.Get(key); value != "" {
+			export.Set(key, value)
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.AuditEvents = events
+	data.AuditFilters = filters
+	data.AuditExportURL = "/dashboard/audit/export?" + export.Encode()
+	data.Metadata = metadata
+	data.NextPageURL = pageURL(r, metadata.NextCursor)
+	data.PrevPageURL = pageURL(r, metadata.PrevCursor)
+	app.render(w, http.StatusOK, "audit.html", data)
+}
+
+// exportAudit downloads the events matching the audit log filters as CSV,
+// with each event's changes as a JSON object.
+func (app *application) exportAudit(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readAuditFilters(r.URL.Query(), v)
+
+	if !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/dashboard/audit", http.StatusSeeOther)
+		return
+	}
+
+	app.extendWriteDeadline(w)
+
+	filename := fmt.Sprintf("audit-%s.csv", time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", exportContentTypes["csv"])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	rw, err := newRecordWriter(w, "csv", []string{
+		"id", "created_at", "actor_id", "actor_email", "action", "target_type", "target_id", "ip",
+		"changes",
+	})
+	if err == nil {
+		err = app.models.Audit.Export(filters, func(e *data.AuditEvent) error {
+			changes, err := json.Marshal(e.Changes)
+			if err != nil {
+				return err
+			}
+			return rw.write(e, []string{
+				strconv.FormatInt(e.ID, 10), formatTime(&e.CreatedAt), strconv.FormatInt(e.ActorID, 10),
+				e.ActorEmail, e.Action, e.TargetType, strconv.FormatInt(e.TargetID, 10), e.IP,
+				string(changes),
+			})
+		})
+	}
+	if err == nil {
+		err = rw.close()
+	}
+	if err != nil {
+		app.logger.PrintError(fmt.Errorf("export audit log: %w", err))
+	}
+}
@@ -1,8 +1,33 @@
 package main
 
+import (
+	"net/http"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+)
+
 type contextKey string
 
 const (
 	isAuthenticatedContextKey contextKey = "isAuthenticated"
 	userContextKey            contextKey = "user"
+	tokenContextKey           contextKey = "token"
 )
+
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// contextGetToken returns the API token the request was authenticated with,
+// or nil when the request was authenticated through the session cookie.
+func (app *application) contextGetToken(r *http.Request) *data.Token {
+	token, ok := r.Context().Value(tokenContextKey).(*data.Token)
+	if !ok {
+		return nil
+	}
+	return token
+}
@@ -24,9 +24,88 @@ func (app *application) serverError(w http.ResponseWriter, err error) {
 }
 
 func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		app.notFoundResponse(w)
+		return
+	}
 	app.render(w, 404, "404.html", &templateData{DisplayNav: false})
 }
 
 func (app *application) badRequest(w http.ResponseWriter, r *http.Request) {
 	app.render(w, 400, "400.html", &templateData{DisplayNav: false})
 }
+
+type apiError struct {
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (app *application) errorResponse(
+	w http.ResponseWriter,
+	status int,
+	message string,
+	fields map[string]string,
+) {
+	env := envelope{"error": apiError{Message: message, Fields: fields}}
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.logger.PrintError(err)
+		w.WriteHeader(500)
+	}
+}
+
+func (app *application) serverErrorResponse(w http.ResponseWriter, err error) {
+	app.logger.PrintError(err)
+	message := "the server encountered a problem and could not process your request"
+	app.errorResponse(w, http.StatusInternalServerError, message, nil)
+}
+
+func (app *application) notFoundResponse(w http.ResponseWriter) {
+	message := "the requested resource could not be found"
+	app.errorResponse(w, http.StatusNotFound, message, nil)
+}
+
+func (app *application) badRequestResponse(w http.ResponseWriter, err error) {
+	app.errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+}
+
+func (app *application) failedValidationResponse(w http.ResponseWriter, errors map[string]string) {
+	message := "the submitted data failed validation"
+	app.errorResponse(w, http.StatusUnprocessableEntity, message, errors)
+}
+
+func (app *application) metadataUnavailableResponse(w http.ResponseWriter, err error) {
+	app.logger.PrintError(err)
+	message := "the book metadata service could not be reached"
+	app.errorResponse(w, http.StatusBadGateway, message, nil)
+}
+
+func (app *application) conflictResponse(w http.ResponseWriter, message string) {
+	app.errorResponse(w, http.StatusConflict, message, nil)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, http.StatusUnauthorized, message, nil)
+}
+
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, http.StatusUnauthorized, message, nil)
+}
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, http.StatusForbidden, message, nil)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, http.StatusForbidden, message, nil)
+}
+
+func (app *application) finesOutstandingResponse(w http.ResponseWriter) {
+	message := "your outstanding fines must be paid before you can borrow books"
+	app.errorResponse(w, http.StatusForbidden, message, nil)
+}
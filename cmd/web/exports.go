@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/marc"
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"json":   "application/json",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportFlushEvery is how many records are written between flushes, so a
+// large export reaches the client while it is still being read.
+const exportFlushEvery = 500
+
+// recordWriter writes records to the response in one of the export formats
+// as they arrive. CSV takes each record's row of fields; JSON and NDJSON
+// encode the record itself, JSON as one array.
+type recordWriter struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	format string
+	csv    *csv.Writer
+	count  int
+}
+
+func newRecordWriter(w http.ResponseWriter, format string, header []string) (*recordWriter, error) {
+	rw := &recordWriter{w: w, rc: http.NewResponseController(w), format: format}
+
+	switch format {
+	case "csv":
+		rw.csv = csv.NewWriter(w)
+		return rw, rw.csv.Write(header)
+	case "json":
+		_, err := w.Write([]byte("[\n"))
+		return rw, err
+	}
+	return rw, nil
+}
+
+func (rw *recordWriter) write(record any, row []string) error {
+	var err error
+
+	switch rw.format {
+	case "csv":
+		err = rw.csv.Write(row)
+	default:
+		var js []byte
+		js, err = json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		if rw.format == "json" && rw.count > 0 {
+			js = append([]byte(",\n"), js...)
+		}
+		if rw.format == "ndjson" {
+			js = append(js, '\n')
+		}
+		_, err = rw.w.Write(js)
+	}
+	if err != nil {
+		return err
+	}
+
+	rw.count++
+	if rw.count%exportFlushEvery == 0 {
+		return rw.flush()
+	}
+	return nil
+}
+
+func (rw *recordWriter) flush() error {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	// The session middleware's writer only flushes through the controller.
+	err := rw.rc.Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+func (rw *recordWriter) close() error {
+	if rw.format == "json" {
+		if _, err := rw.w.Write([]byte("\n]\n")); err != nil {
+			return err
+		}
+	}
+	return rw.flush()
+}
+
+// extendWriteDeadline lets a streamed export take as long as the query
+// behind it may, instead of being cut off by the server's write timeout.
+func (app *application) extendWriteDeadline(w http.ResponseWriter) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(data.ExportTimeout))
+	if err != nil {
+		app.logger.PrintError(fmt.Errorf("extend write deadline: %w", err))
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// exportData streams one of the books, members or borrows datasets. The
+// books CSV uses the column names the importer reads, so it can be edited
+// and imported again. Once the first row has been sent an error can only be
+// logged, leaving the download cut short.
+func (app *application) exportData(w http.ResponseWriter, r *http.Request) {
+	dataset := r.PathValue("dataset")
+	if dataset != "books" && dataset != "members" && dataset != "borrows" {
+		app.notFound(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	format := qs.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	_, ok := exportContentTypes[format]
+	v.Check(ok, "format", "Format must be csv, json or ndjson")
+
+	var period data.DateRange
+	for key, dst := range map[string]**time.Time{"from": &period.From, "to": &period.To} {
+		if s := qs.Get(key); s != "" {
+			t, err := time.Parse("2006-01-02", s)
+			if err != nil {
+				v.AddError(key, "Dates must be in YYYY-MM-DD format")
+				continue
+			}
+			*dst = &t
+		}
+	}
+	data.ValidateDateRange(v, period)
+
+	if !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	app.extendWriteDeadline(w)
+
+	filename := fmt.Sprintf("%s-%s.%s", dataset, time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	var rw *recordWriter
+	var err error
+
+	switch dataset {
+	case "books":
+		rw, err = newRecordWriter(w, format, []string{
+			"id", "work_id", "title", "contributors", "isbn", "description", "cover_image", "genres",
+			"pages", "language", "publisher", "item_type", "publish_date", "copies_total",
+			"copies_available",
+		})
+		if err == nil {
+			err = app.models.Books.Export(func(b *data.Book) error {
+				return rw.write(b, []string{
+					strconv.Itoa(b.ID), strconv.FormatInt(b.WorkID, 10), b.Title,
+					data.FormatContributors(b.Contributors), b.ISBN, b.Description, b.CoverImage,
+					strings.Join(b.Genres, ", "), strconv.Itoa(b.Pages), b.Language, b.Publisher,
+					b.ItemType, b.PublishDate.Format("2006-01-02"), strconv.Itoa(b.CopiesTotal),
+					strconv.Itoa(b.CopiesAvailable),
+				})
+			})
+		}
+	case "members":
+		rw, err = newRecordWriter(w, format, []string{"id", "name", "email", "role", "tier", "activated", "created_at"})
+		if err == nil {
+			err = app.models.Users.Export(func(u *data.User) error {
+				tier := ""
+				if u.Tier != nil {
+					tier = *u.Tier
+				}
+				return rw.write(u, []string{
+					strconv.FormatInt(u.ID, 10), u.Name, u.Email, u.Role, tier,
+					strconv.FormatBool(u.Activated), formatTime(&u.CreatedAt),
+				})
+			})
+		}
+	case "borrows":
+		rw, err = newRecordWriter(w, format, []string{
+			"id", "user_id", "user_name", "user_email", "book_id", "title", "isbn", "barcode",
+			"borrowed_at", "due_at", "returned_at", "renewals",
+		})
+		if err == nil {
+			err = app.models.BorrowRecord.Export(period, func(br *data.BorrowRecord) error {
+				return rw.write(br, []string{
+					strconv.FormatInt(br.ID, 10), strconv.FormatInt(br.UserID, 10), br.UserName,
+					br.UserEmail, strconv.FormatInt(br.BookID, 10), br.Title, br.ISBN, br.Barcode,
+					formatTime(&br.BorrowedAt), formatTime(&br.DueAt), formatTime(br.ReturnedAt),
+					strconv.Itoa(br.Renewals),
+				})
+			})
+		}
+	}
+
+	if err == nil {
+		err = rw.close()
+	}
+	if err != nil {
+		app.logger.PrintError(fmt.Errorf("export %s: %w", dataset, err))
+	}
+}
+
+var marcContentTypes = map[string]string{
+	"mrc": "application/marc",
+	"xml": "application/marcxml+xml",
+}
+
+// marcExportURL links to the MARC export of the search on the current page.
+func marcExportURL(r *http.Request, format string) string {
+	qs := r.URL.Query()
+	qs.Del("cursor")
+	qs.Set("format", format)
+	return "/search/marc?" + qs.Encode()
+}
+
+// exportSearch downloads every edition matching a catalogue search as MARC
+// records, in ISO 2709 (format=mrc) or MARCXML (format=xml). A book too long
+// for an ISO 2709 record is left out and logged.
+func (app *application) exportSearch(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+	filters := app.readSearchFilters(qs, v)
+
+	format := qs.Get("format")
+	if format == "" {
+		format = "mrc"
+	}
+	_, ok := marcContentTypes[format]
+	v.Check(ok, "format", "must be mrc or xml")
+
+	if !v.Valid() {
+		app.badRequest(w, r)
+		return
+	}
+
+	app.extendWriteDeadline(w)
+
+	filename := fmt.Sprintf("search-%s.%s", time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Type", marcContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	write := marc.NewWriter(w).Write
+	finish := func() error { return nil }
+	if format == "xml" {
+		xw := marc.NewXMLWriter(w)
+		write, finish = xw.Write, xw.Close
+	}
+
+	err := app.models.Books.ExportSearch(filters, func(b *data.Book) error {
+		err := write(marc.FromBook(b))
+		if errors.Is(err, marc.ErrTooLong) {
+			app.logger.PrintError(fmt.Errorf("export book %d: %w", b.ID, err))
+			return nil
+		}
+		return err
+	})
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		app.logger.PrintError(fmt.Errorf("export search: %w", err))
+	}
+}
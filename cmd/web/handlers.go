@@ -1,23 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/marc"
+	"github.com/0xrinful/LibraryMS/internal/metadata"
 	"github.com/0xrinful/LibraryMS/internal/validator"
+	"github.com/0xrinful/LibraryMS/internal/validator/isbn"
 )
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	page := 1
-	limit := 4
-	offset := (page - 1) * limit
-
-	books, err := app.models.Books.GetBooks(limit, offset)
+	books, metadata, err := app.models.Books.GetBooks(data.Pagination{Limit: 4})
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -25,33 +28,141 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
 	data := app.newTemplateData(r)
 	data.Books = books
+	data.Metadata = metadata
 	app.render(w, 200, "home.html", data)
 }
 
 func (app *application) profile(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	userID := data.User.ID
+	user := app.contextGetUser(r)
 
-	current, err := app.models.BorrowRecord.GetCurrentBorrows(userID)
+	current, err := app.models.BorrowRecord.GetCurrentBorrows(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	history, err := app.models.BorrowRecord.GetBorrowHistory(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(user.ID, data.PurposeAPI)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	holds, err := app.models.Holds.GetActiveForUser(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	fineBalance, err := app.models.Fines.Balance(user.ID)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
-	data.CurrentBorrows = current
-	data.ActiveBorrows = len(current)
 
-	history, err := app.models.BorrowRecord.GetBorrowHistory(userID)
+	fineLedger, err := app.models.Fines.GetLedger(user.ID)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
+
+	finePolicy, err := app.models.Fines.GetPolicy()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	scopes := data.ScopesForRole(user.Role)
+
+	data := app.newTemplateData(r)
+	data.CurrentBorrows = current
+	data.ActiveBorrows = len(current)
 	data.BorrowHistory = history
 	data.TotalBorrowed = len(current) + len(history)
+	data.Holds = holds
+	data.FineBalance = fineBalance
+	data.FineLedger = fineLedger
+	data.FinePolicy = finePolicy
+	data.Tokens = tokens
+	data.TokenScopes = scopes
+	data.NewToken = app.session.PopString(r.Context(), "new_api_token")
 
 	app.render(w, 200, "profile.html", data)
 }
 
 func (app *application) dashboard(w http.ResponseWriter, r *http.Request) {
+	activationRequired, err := app.models.Settings.Get(data.SettingActivationRequired)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverError(w, err)
+		return
+	}
+
+	holdPickupDays, err := app.models.Settings.GetInt(data.SettingHoldPickupDays, 3)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	trashRetentionDays, err := app.models.Settings.GetInt(data.SettingTrashRetentionDays, 30)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	policies, err := app.models.Policies.GetAll()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	renewals, err := app.models.Renewals.GetRecent(50)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	finePolicy, err := app.models.Fines.GetPolicy()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	fineBalances, err := app.models.Fines.GetOutstanding()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	imports, err := app.models.Imports.GetRecent(20)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	importFields := data.ImportFields
+
+	auditEvents, _, err := app.models.Audit.GetAll(data.AuditFilters{}, data.Pagination{Limit: 20})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	deletedBooks, err := app.models.Books.GetDeleted()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	deletedMembers, err := app.models.Users.GetDeleted()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
 
 	totalBooks, err := app.models.Books.Count()
@@ -96,6 +207,19 @@ func (app *application) dashboard(w http.ResponseWriter, r *http.Request) {
 	}
 	data.Members = members
 
+	data.ActivationRequired = activationRequired
+	data.HoldPickupDays = holdPickupDays
+	data.Policies = policies
+	data.Renewals = renewals
+	data.FinePolicy = finePolicy
+	data.FineBalances = fineBalances
+	data.Imports = imports
+	data.ImportFields = importFields
+	data.AuditEvents = auditEvents
+	data.DeletedBooks = deletedBooks
+	data.DeletedMembers = deletedMembers
+	data.TrashRetentionDays = trashRetentionDays
+
 	app.render(w, 200, "dashboard.html", data)
 }
 
@@ -168,13 +292,20 @@ func (app *application) signupPost(w http.ResponseWriter, r *http.Request) {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			form.AddError("email", "this email address already exists")
 			data := &templateData{DisplayNav: false, Form: form}
-			app.render(w, http.StatusUnprocessableEntity, "signup. html", data)
+			app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
 		default:
 			app.serverError(w, err)
 		}
 		return
 	}
 
+	err = app.sendActivationEmail(user)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.flashInfo(r, "Account created. Check your email for the activation link.")
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
@@ -247,6 +378,21 @@ func (app *application) loginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	required, err := app.activationRequired(user, data.ActivationRequiredLogin)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if required {
+		form.AddError("email", "Please activate your account before logging in")
+		data := app.newTemplateData(r)
+		data.DisplayNav = false
+		data.Form = form
+		app.render(w, http.StatusForbidden, "login.html", data)
+		return
+	}
+
 	if form.RememberMe == "1" {
 		app.session.Cookie.Persist = true
 	} else {
@@ -260,31 +406,96 @@ func (app *application) loginPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	app.session.Put(r.Context(), "authenticatedUserID", user.ID)
+	app.session.Put(r.Context(), "sessionGeneration", user.SessionGeneration)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (app *application) search(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query().Get("q")
-	category := r.URL.Query().Get("category")
-	availability := r.URL.Query().Get("availability")
-	sort := r.URL.Query().Get("sort")
+	v := validator.New()
+	filters := app.readSearchFilters(r.URL.Query(), v)
+	p := app.readPagination(r.URL.Query(), 12, v)
+	if !v.Valid() {
+		// A query that doesn't parse is shown back with the reason rather
+		// than rejected outright.
+		if msg, ok := v.Errors["q"]; ok {
+			data := app.newTemplateData(r)
+			data.Search = filters
+			data.QueryError = msg
+			app.render(w, http.StatusUnprocessableEntity, "search.html", data)
+			return
+		}
+		app.badRequest(w, r)
+		return
+	}
 
-	books, err := app.models.Books.Search(q, category, availability, sort)
+	result, err := app.models.Books.Search(filters, p)
 	if err != nil {
-		app.serverError(w, err)
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			app.badRequest(w, r)
+		default:
+			app.serverError(w, err)
+		}
 		return
 	}
 
+	suggestionURL := ""
+	if result.Suggestion != "" {
+		qs := r.URL.Query()
+		qs.Set("q", result.Suggestion)
+		qs.Del("cursor")
+		suggestionURL = "/search?" + qs.Encode()
+	}
+
 	data := app.newTemplateData(r)
-	data.Books = books
-	data.SearchQuery = q
-	data.SearchCategory = category
-	data.SearchAvailability = availability
-	data.SearchSort = sort
+	data.Books = result.Books
+	data.Search = filters
+	data.Facets = result.Facets
+	data.Metadata = result.Metadata
+	data.FuzzySearch = result.Fuzzy
+	data.Suggestion = result.Suggestion
+	data.SuggestionURL = suggestionURL
+	data.NextPageURL = pageURL(r, result.Metadata.NextCursor)
+	data.PrevPageURL = pageURL(r, result.Metadata.PrevCursor)
+	if data.IsAuthenticated && data.User.Role == "admin" {
+		data.MARCExportURL = marcExportURL(r, "mrc")
+		data.MARCXMLExportURL = marcExportURL(r, "xml")
+	}
 
 	app.render(w, 200, "search.html", data)
 }
 
+// searchSuggest feeds the search box's autocomplete with titles, authors and
+// ISBNs matching what has been typed so far.
+func (app *application) searchSuggest(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	q := strings.TrimSpace(qs.Get("q"))
+
+	v := validator.New()
+	limit := app.readInt(qs, "limit", 8, v)
+	v.Check(limit >= 1 && limit <= 10, "limit", "must be between 1 and 10")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 characters long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	suggestions := []*data.Suggestion{}
+	if utf8.RuneCountInString(q) >= 2 {
+		var err error
+		suggestions, err = app.models.Books.Suggest(q, limit)
+		if err != nil {
+			app.serverErrorResponse(w, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
 func (app *application) displayBook(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
@@ -304,12 +515,78 @@ func (app *application) displayBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var hold *data.Hold
+	var policy *data.CirculationPolicy
+	queueLength := 0
+	if app.isAuthenticated(r) {
+		userID := app.contextGetUser(r).ID
+
+		policy, err = app.models.Policies.Resolve(userID, int64(id))
+		if err != nil && !errors.Is(err, data.ErrNoMatchingPolicy) {
+			app.serverError(w, err)
+			return
+		}
+
+		hold, err = app.models.Holds.GetActive(userID, int64(id))
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverError(w, err)
+			return
+		}
+
+		queueLength, err = app.models.Holds.CountWaiting(int64(id))
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	editions, err := app.models.Books.GetEditions(book.WorkID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
 	data.Book = book
+	data.Editions = editions
+	data.Hold = hold
+	data.HoldQueueLength = queueLength
+	data.Policy = policy
 
 	app.render(w, http.StatusOK, "book.html", data)
 }
 
+func (app *application) displayAuthor(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	contributor, err := app.models.Contributors.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	books, err := app.models.Contributors.GetBooks(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Contributor = contributor
+	data.ContributedBooks = books
+
+	app.render(w, http.StatusOK, "author.html", data)
+}
+
 func (app *application) borrowBook(w http.ResponseWriter, r *http.Request) {
 	bookID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil || bookID < 1 {
@@ -317,20 +594,28 @@ func (app *application) borrowBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := app.session.GetInt64(r.Context(), "authenticatedUserID")
+	user := app.contextGetUser(r)
 
-	days, err := strconv.Atoi(r.FormValue("days"))
-	if err != nil || days < 1 || days > 60 {
-		app.flashError(r, "Invalid borrow duration.")
+	required, err := app.activationRequired(user, data.ActivationRequiredBorrowing)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if required {
+		app.flashError(r, "Please activate your account before borrowing books.")
 		http.Redirect(w, r, fmt.Sprintf("/books/%d", bookID), http.StatusSeeOther)
 		return
 	}
 
-	err = app.models.Books.BorrowBook(userID, bookID, days)
+	err = app.models.Books.BorrowBook(user.ID, bookID)
 	if err != nil {
 		switch err {
 		case data.ErrAlreadyBorrowed:
 			app.flashError(r, "You already borrowed this book.")
+		case data.ErrLoanLimitReached:
+			app.flashError(r, "You have reached your loan limit. Return a book before borrowing another.")
+		case data.ErrFinesOutstanding:
+			app.flashError(r, "Please pay your outstanding fines before borrowing more books.")
 		case data.ErrNoAvailableCopies:
 			app.flashError(r, "No available copies right now.")
 		case data.ErrRecordNotFound:
@@ -356,7 +641,7 @@ func (app *application) returnBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := app.session.GetInt64(r.Context(), "authenticatedUserID")
+	userID := app.contextGetUser(r).ID
 
 	err = app.models.Books.ReturnBook(userID, bookID)
 	if err != nil {
@@ -373,155 +658,306 @@ func (app *application) returnBook(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/profile", http.StatusSeeOther)
 }
 
-func (app *application) logout(w http.ResponseWriter, r *http.Request) {
-	err := app.session.RenewToken(r.Context())
-	if err != nil {
-		app.serverError(w, err)
+func (app *application) renewBook(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || bookID < 1 {
+		app.notFound(w, r)
 		return
 	}
 
-	app.session.Remove(r.Context(), "authenticatedUserID")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
-
-func (app *application) booksFragment(w http.ResponseWriter, r *http.Request) {
-	page := 1
-	limit := 4
-
-	if p := r.URL.Query().Get("page"); p != "" {
-		page, _ = strconv.Atoi(p)
-		if page < 1 {
-			page = 1
-		}
-	}
-	offset := (page - 1) * limit
+	user := app.contextGetUser(r)
 
-	books, err := app.models.Books.GetBooks(limit, offset)
+	required, err := app.activationRequired(user, data.ActivationRequiredBorrowing)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
-
-	data := app.newTemplateData(r)
-	data.Books = books
-	app.renderPartial(w, "book_cards.html", data)
-}
-
-type bookForm struct {
-	Title       string
-	Author      string
-	ISBN        string
-	Description string
-	CoverImage  string
-	Genres      string
-	Pages       int
-	Language    string
-	Publisher   string
-	PublishDate string
-	CopiesTotal int
-	validator.Validator
-}
-
-func (app *application) createBook(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-	if err != nil {
-		app.badRequest(w, r)
+	if required {
+		app.flashError(r, "Please activate your account before renewing loans.")
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
 		return
 	}
 
-	pages, _ := strconv.Atoi(r.FormValue("pages"))
-	copiesTotal, _ := strconv.Atoi(r.FormValue("copies_total"))
+	renewal, err := app.models.Renewals.Renew(user.ID, bookID)
+	if err != nil {
+		switch err {
+		case data.ErrRenewalLimitReached:
+			app.flashError(r, "This loan has already been renewed the maximum number of times.")
+		case data.ErrBookOnHold:
+			app.flashError(r, "This book can't be renewed because other members are waiting for it.")
+		case data.ErrFinesOutstanding:
+			app.flashError(r, "Please pay your outstanding fines before renewing loans.")
+		case data.ErrRecordNotFound:
+			app.notFound(w, r)
+			return
+		default:
+			app.serverError(w, err)
+			return
+		}
 
-	form := bookForm{
-		Title:       strings.TrimSpace(r.FormValue("title")),
-		Author:      strings.TrimSpace(r.FormValue("author")),
-		ISBN:        strings.TrimSpace(r.FormValue("isbn")),
-		Description: strings.TrimSpace(r.FormValue("description")),
-		CoverImage:  strings.TrimSpace(r.FormValue("cover_image")),
-		Genres:      strings.TrimSpace(r.FormValue("genres")),
-		Pages:       pages,
-		Language:    strings.TrimSpace(r.FormValue("language")),
-		Publisher:   strings.TrimSpace(r.FormValue("publisher")),
-		PublishDate: r.FormValue("publish_date"),
-		CopiesTotal: copiesTotal,
-		Validator:   *validator.New(),
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		return
 	}
 
-	form.Check(validator.NotBlank(form.Title), "title", "Title is required")
-	form.Check(len(form.Title) <= 500, "title", "Title must not exceed 500 characters")
-
-	form.Check(validator.NotBlank(form.Author), "author", "Author is required")
-	form.Check(len(form.Author) <= 500, "author", "Author must not exceed 500 characters")
-
-	form.Check(validator.NotBlank(form.ISBN), "isbn", "ISBN is required")
-	form.Check(len(form.ISBN) >= 10, "isbn", "ISBN must be at least 10 characters")
-	form.Check(len(form.ISBN) <= 17, "isbn", "ISBN must not exceed 17 characters")
-
-	form.Check(form.CopiesTotal >= 1, "copies_total", "Total copies must be at least 1")
-	form.Check(form.CopiesTotal <= 10000, "copies_total", "Total copies must not exceed 10000")
+	app.flashInfo(r, "Loan renewed. New due date: "+renewal.NewDueAt.Format("02 Jan 2006")+".")
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
 
-	if form.Pages < 0 {
-		form.AddError("pages", "Pages cannot be negative")
-	}
-	if form.Pages > 50000 {
-		form.AddError("pages", "Pages must not exceed 50000")
+func (app *application) placeHold(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || bookID < 1 {
+		app.notFound(w, r)
+		return
 	}
 
-	if len(form.Description) > 5000 {
-		form.AddError("description", "Description must not exceed 5000 characters")
-	}
+	user := app.contextGetUser(r)
 
-	if form.ISBN != "" {
-		exists, err := app.models.Books.ISBNExists(form.ISBN)
-		if err != nil {
-			app.serverError(w, err)
-			return
-		}
-		if exists {
-			form.AddError("isbn", "A book with this ISBN already exists")
-		}
+	required, err := app.activationRequired(user, data.ActivationRequiredBorrowing)
+	if err != nil {
+		app.serverError(w, err)
+		return
 	}
-
-	if !form.Valid() {
-		var errorMessages []string
-		for field, msg := range form.Errors {
-			errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", field, msg))
-		}
-		app.flashError(r, strings.Join(errorMessages, "; "))
-		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	if required {
+		app.flashError(r, "Please activate your account before placing holds.")
+		http.Redirect(w, r, fmt.Sprintf("/books/%d", bookID), http.StatusSeeOther)
 		return
 	}
 
-	publishDate, err := time.Parse("2006-01-02", form.PublishDate)
+	_, err = app.models.Holds.Place(user.ID, bookID)
 	if err != nil {
-		publishDate = time.Now()
+		switch err {
+		case data.ErrDuplicateHold:
+			app.flashError(r, "You already have a hold on this book or another edition of it.")
+		case data.ErrAlreadyBorrowed:
+			app.flashError(r, "You already borrowed this book.")
+		case data.ErrHoldNotNeeded:
+			app.flashError(r, "Copies are available, you can borrow this book or another edition of it now.")
+		case data.ErrHoldLimitReached:
+			app.flashError(r, "You have reached your hold limit.")
+		case data.ErrRecordNotFound:
+			app.notFound(w, r)
+			return
+		default:
+			app.serverError(w, err)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/books/%d", bookID), http.StatusSeeOther)
+		return
+	}
+
+	app.flashInfo(r, "Hold placed. We'll set a copy aside for you when one is returned.")
+	http.Redirect(w, r, fmt.Sprintf("/books/%d", bookID), http.StatusSeeOther)
+}
+
+func (app *application) cancelHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || holdID < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	err = app.models.Holds.Cancel(app.contextGetUser(r).ID, holdID)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "Hold cancelled.")
+
+	redirect := "/profile"
+	if back := r.FormValue("redirect"); strings.HasPrefix(back, "/books/") {
+		redirect = back
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	err := app.session.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Remove(r.Context(), "authenticatedUserID")
+	app.session.Remove(r.Context(), "sessionGeneration")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// booksFragment renders the next page of book cards for the home page's
+// infinite scroll. The cursor for the page after it is sent in the
+// X-Next-Cursor header.
+func (app *application) booksFragment(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	p := app.readPagination(r.URL.Query(), 4, v)
+	if !v.Valid() {
+		app.badRequest(w, r)
+		return
+	}
+
+	books, metadata, err := app.models.Books.GetBooks(p)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			app.badRequest(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("X-Next-Cursor", metadata.NextCursor)
+
+	data := app.newTemplateData(r)
+	data.Books = books
+	app.renderPartial(w, "book_cards.html", data)
+}
+
+type bookForm struct {
+	Title        string
+	Contributors string
+	ISBN         string
+	Description  string
+	CoverImage   string
+	Genres       string
+	Pages        int
+	Language     string
+	Publisher    string
+	ItemType     string
+	PublishDate  string
+	CopiesTotal  int
+	validator.Validator
+}
+
+// lookupMetadata fetches the details of an edition by ISBN for the add book
+// form, which shows them for review before anything is saved.
+func (app *application) lookupMetadata(w http.ResponseWriter, r *http.Request) {
+	number := strings.TrimSpace(r.URL.Query().Get("isbn"))
+
+	v := validator.New()
+	v.Check(isbn.Valid(number), "isbn", "must be a valid ISBN-10 or ISBN-13")
+	if !v.Valid() {
+		app.failedValidationResponse(w, v.Errors)
+		return
 	}
+	canonical, _ := isbn.To13(number)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
 
-	genres := []string{}
-	if form.Genres != "" {
-		for _, g := range splitAndTrim(form.Genres) {
-			if g != "" {
-				genres = append(genres, g)
-			}
+	record, err := app.metadata.Lookup(ctx, canonical)
+	if err != nil {
+		switch {
+		case errors.Is(err, metadata.ErrNotFound):
+			app.notFoundResponse(w)
+		default:
+			app.metadataUnavailableResponse(w, err)
 		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": record}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, err)
+	}
+}
+
+func (app *application) createBook(w http.ResponseWriter, r *http.Request) {
+	err := parseBookForm(w, r)
+	if err != nil {
+		app.flashError(r, "The book could not be saved. Cover images must not exceed 5 MB.")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	pages, _ := strconv.Atoi(r.FormValue("pages"))
+	copiesTotal, _ := strconv.Atoi(r.FormValue("copies_total"))
+
+	form := bookForm{
+		Title:        strings.TrimSpace(r.FormValue("title")),
+		Contributors: r.FormValue("contributors"),
+		ISBN:         strings.TrimSpace(r.FormValue("isbn")),
+		Description:  strings.TrimSpace(r.FormValue("description")),
+		CoverImage:   strings.TrimSpace(r.FormValue("cover_image")),
+		Genres:       strings.TrimSpace(r.FormValue("genres")),
+		Pages:        pages,
+		Language:     strings.TrimSpace(r.FormValue("language")),
+		Publisher:    strings.TrimSpace(r.FormValue("publisher")),
+		ItemType:     strings.TrimSpace(r.FormValue("item_type")),
+		PublishDate:  r.FormValue("publish_date"),
+		CopiesTotal:  copiesTotal,
+		Validator:    *validator.New(),
+	}
+
+	publishDate, err := time.Parse("2006-01-02", form.PublishDate)
+	if err != nil {
+		publishDate = time.Now()
 	}
 
 	book := &data.Book{
-		Title:           form.Title,
-		Author:          form.Author,
-		ISBN:            form.ISBN,
-		Description:     form.Description,
-		CoverImage:      form.CoverImage,
-		Genres:          genres,
-		Pages:           form.Pages,
-		Language:        form.Language,
-		Publisher:       form.Publisher,
-		PublishDate:     publishDate,
-		CopiesTotal:     form.CopiesTotal,
-		CopiesAvailable: form.CopiesTotal,
-	}
-
-	err = app.models.Books.Insert(book)
+		Title:        form.Title,
+		Contributors: data.ParseContributors(form.Contributors),
+		ISBN:         form.ISBN,
+		Description:  form.Description,
+		CoverImage:   form.CoverImage,
+		Genres:       splitAndTrim(form.Genres),
+		Pages:        form.Pages,
+		Language:     form.Language,
+		Publisher:    form.Publisher,
+		ItemType:     form.ItemType,
+		PublishDate:  publishDate,
+		CopiesTotal:  form.CopiesTotal,
+	}
+
+	data.ValidateBook(&form.Validator, book)
+
+	err = app.setEditionOf(book, r.FormValue("edition_of"), &form.Validator)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if form.ISBN != "" {
+		exists, err := app.models.Books.ISBNExists(form.ISBN)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if exists {
+			form.AddError("isbn", "A book with this ISBN already exists")
+		}
+	}
+
+	if form.Valid() {
+		file, err := uploadedCover(r)
+		if err != nil {
+			app.badRequest(w, r)
+			return
+		}
+		if file != nil {
+			defer file.Close()
+		}
+
+		book.CoverImage, err = app.saveCover(r.Context(), file, book.CoverImage, "", &form.Validator)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		app.flashError(r, joinErrors(form.Errors))
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditBookCreate, data.AuditTargetBook, int64(book.ID), nil, book)
+	err = app.models.Books.Insert(book, ev)
 	if err != nil {
 		if errors.Is(err, data.ErrDuplicateISBN) {
 			app.flashError(r, "A book with this ISBN already exists.")
@@ -544,9 +980,10 @@ func (app *application) updateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = r.ParseForm()
+	err = parseBookForm(w, r)
 	if err != nil {
-		app.badRequest(w, r)
+		app.flashError(r, "The book could not be saved. Cover images must not exceed 5 MB.")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 		return
 	}
 
@@ -562,49 +999,37 @@ func (app *application) updateBook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	pages, _ := strconv.Atoi(r.FormValue("pages"))
-	copiesTotal, _ := strconv.Atoi(r.FormValue("copies_total"))
-	copiesAvailable, _ := strconv.Atoi(r.FormValue("copies_available"))
-
-	v := validator.New()
-
-	title := strings.TrimSpace(r.FormValue("title"))
-	author := strings.TrimSpace(r.FormValue("author"))
-	isbn := strings.TrimSpace(r.FormValue("isbn"))
-	description := strings.TrimSpace(r.FormValue("description"))
-
-	v.Check(validator.NotBlank(title), "title", "Title is required")
-	v.Check(len(title) <= 500, "title", "Title must not exceed 500 characters")
-
-	v.Check(validator.NotBlank(author), "author", "Author is required")
-	v.Check(len(author) <= 500, "author", "Author must not exceed 500 characters")
 
-	v.Check(validator.NotBlank(isbn), "isbn", "ISBN is required")
-	v.Check(len(isbn) >= 10, "isbn", "ISBN must be at least 10 characters")
-	v.Check(len(isbn) <= 17, "isbn", "ISBN must not exceed 17 characters")
-
-	v.Check(copiesTotal >= 1, "copies_total", "Total copies must be at least 1")
-	v.Check(copiesTotal <= 10000, "copies_total", "Total copies must not exceed 10000")
+	publishDate, err := time.Parse("2006-01-02", r.FormValue("publish_date"))
+	if err != nil {
+		publishDate = book.PublishDate
+	}
 
-	v.Check(copiesAvailable >= 0, "copies_available", "Available copies cannot be negative")
-	v.Check(
-		copiesAvailable <= copiesTotal,
-		"copies_available",
-		"Available copies cannot exceed total copies",
-	)
+	before := *book
+	currentCover := book.CoverImage
+	book.Title = strings.TrimSpace(r.FormValue("title"))
+	book.Contributors = data.ParseContributors(r.FormValue("contributors"))
+	book.ISBN = strings.TrimSpace(r.FormValue("isbn"))
+	book.Description = strings.TrimSpace(r.FormValue("description"))
+	book.CoverImage = strings.TrimSpace(r.FormValue("cover_image"))
+	book.Genres = splitAndTrim(r.FormValue("genres"))
+	book.Pages = pages
+	book.Language = strings.TrimSpace(r.FormValue("language"))
+	book.Publisher = strings.TrimSpace(r.FormValue("publisher"))
+	book.ItemType = strings.TrimSpace(r.FormValue("item_type"))
+	book.PublishDate = publishDate
 
-	if pages < 0 {
-		v.AddError("pages", "Pages cannot be negative")
-	}
-	if pages > 50000 {
-		v.AddError("pages", "Pages must not exceed 50000")
-	}
+	v := validator.New()
+	data.ValidateBook(v, book)
 
-	if len(description) > 5000 {
-		v.AddError("description", "Description must not exceed 5000 characters")
+	err = app.setEditionOf(book, r.FormValue("edition_of"), v)
+	if err != nil {
+		app.serverError(w, err)
+		return
 	}
 
-	if isbn != "" && isbn != book.ISBN {
-		exists, err := app.models.Books.ISBNExistsExcluding(isbn, id)
+	if book.ISBN != "" {
+		exists, err := app.models.Books.ISBNExistsExcluding(book.ISBN, id)
 		if err != nil {
 			app.serverError(w, err)
 			return
@@ -614,45 +1039,31 @@ func (app *application) updateBook(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if !v.Valid() {
-		var errorMessages []string
-		for field, msg := range v.Errors {
-			errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", field, msg))
+	if v.Valid() {
+		file, err := uploadedCover(r)
+		if err != nil {
+			app.badRequest(w, r)
+			return
+		}
+		if file != nil {
+			defer file.Close()
 		}
-		app.flashError(r, strings.Join(errorMessages, "; "))
-		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
-		return
-	}
-
-	publishDate, err := time.Parse("2006-01-02", r.FormValue("publish_date"))
-	if err != nil {
-		publishDate = book.PublishDate
-	}
 
-	genres := []string{}
-	genresStr := r.FormValue("genres")
-	if genresStr != "" {
-		for _, g := range splitAndTrim(genresStr) {
-			if g != "" {
-				genres = append(genres, g)
-			}
+		book.CoverImage, err = app.saveCover(r.Context(), file, book.CoverImage, currentCover, v)
+		if err != nil {
+			app.serverError(w, err)
+			return
 		}
 	}
 
-	book.Title = title
-	book.Author = author
-	book.ISBN = isbn
-	book.Description = description
-	book.CoverImage = strings.TrimSpace(r.FormValue("cover_image"))
-	book.Genres = genres
-	book.Pages = pages
-	book.Language = strings.TrimSpace(r.FormValue("language"))
-	book.Publisher = strings.TrimSpace(r.FormValue("publisher"))
-	book.PublishDate = publishDate
-	book.CopiesTotal = copiesTotal
-	book.CopiesAvailable = copiesAvailable
+	if !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
 
-	err = app.models.Books.Update(book)
+	ev := app.auditEvent(r, data.AuditBookUpdate, data.AuditTargetBook, int64(book.ID), &before, book)
+	err = app.models.Books.Update(book, ev)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -678,7 +1089,19 @@ func (app *application) deleteBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.models.Books.Delete(id)
+	book, err := app.models.Books.GetBookByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditBookDelete, data.AuditTargetBook, int64(id), book, nil)
+	err = app.models.Books.Delete(id, ev)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -689,25 +1112,18 @@ func (app *application) deleteBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.flashInfo(r, "Book deleted successfully.")
+	app.flashInfo(r, "Book moved to the trash.")
 	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 }
 
-func (app *application) updateMember(w http.ResponseWriter, r *http.Request) {
-	idStr := r.PathValue("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+func (app *application) bookCopies(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil || id < 1 {
 		app.notFound(w, r)
 		return
 	}
 
-	err = r.ParseForm()
-	if err != nil {
-		app.badRequest(w, r)
-		return
-	}
-
-	user, err := app.models.Users.Get(id)
+	book, err := app.models.Books.GetBookByID(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -718,69 +1134,93 @@ func (app *application) updateMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	name := strings.TrimSpace(r.FormValue("name"))
-	email := strings.TrimSpace(r.FormValue("email"))
-	role := strings.TrimSpace(r.FormValue("role"))
+	copies, err := app.models.Copies.GetAllForBook(int64(id))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
-	v := validator.New()
+	data := app.newTemplateData(r)
+	data.Book = book
+	data.Copies = copies
+	app.render(w, http.StatusOK, "copies.html", data)
+}
 
-	v.Check(validator.NotBlank(name), "name", "Name is required")
-	v.Check(len(name) >= 3, "name", "Name must be at least 3 characters")
-	v.Check(len(name) <= 500, "name", "Name must not exceed 500 characters")
+// readCopyForm copies the fields shared by the add and edit copy forms onto c.
+func readCopyForm(r *http.Request, c *data.Copy, v *validator.Validator) {
+	c.Barcode = strings.TrimSpace(r.PostForm.Get("barcode"))
+	c.Condition = r.PostForm.Get("condition")
+	c.Status = r.PostForm.Get("status")
+	c.Notes = strings.TrimSpace(r.PostForm.Get("notes"))
 
-	v.Check(validator.NotBlank(email), "email", "Email is required")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "Invalid email format")
+	if acquired := r.PostForm.Get("acquired_at"); acquired != "" {
+		acquiredAt, err := time.Parse("2006-01-02", acquired)
+		if err != nil {
+			v.AddError("acquired_at", "Acquisition date must be in YYYY-MM-DD format")
+		} else {
+			c.AcquiredAt = acquiredAt
+		}
+	}
+}
 
-	v.Check(role == "user" || role == "admin", "role", "Role must be 'user' or 'admin'")
+func (app *application) createCopy(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || bookID < 1 {
+		app.notFound(w, r)
+		return
+	}
 
-	if !v.Valid() {
-		var errorMessages []string
-		for field, msg := range v.Errors {
-			errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", field, msg))
-		}
-		app.flashError(r, strings.Join(errorMessages, "; "))
-		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	err = r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
 		return
 	}
 
-	user.Name = name
-	user.Email = email
-	user.Role = role
+	redirect := fmt.Sprintf("/dashboard/books/%d/copies", bookID)
+
+	c := &data.Copy{BookID: bookID, AcquiredAt: time.Now()}
+	v := validator.New()
+	readCopyForm(r, c, v)
+
+	if data.ValidateCopy(v, c, ""); !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
 
-	err = app.models.Users.Update(user)
+	ev := app.auditEvent(r, data.AuditCopyCreate, data.AuditTargetCopy, 0, nil, c)
+	err = app.models.Copies.Insert(c, ev)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFound(w, r)
-		case errors.Is(err, data.ErrDuplicateEmail):
-			app.flashError(r, "Email address already in use.")
-			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			app.flashError(r, "A copy with this barcode already exists.")
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
 		default:
 			app.serverError(w, err)
 		}
 		return
 	}
 
-	app.flashInfo(r, "Member updated successfully.")
-	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	app.flashInfo(r, "Copy "+c.Barcode+" added successfully.")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
 }
 
-func (app *application) deleteMember(w http.ResponseWriter, r *http.Request) {
-	idStr := r.PathValue("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+func (app *application) updateCopy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil || id < 1 {
 		app.notFound(w, r)
 		return
 	}
 
-	currentUserID := app.session.GetInt64(r.Context(), "authenticatedUserID")
-	if currentUserID == id {
-		app.flashError(r, "You cannot delete your own account.")
-		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	err = r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
 		return
 	}
 
-	err = app.models.Users.Delete(id)
+	c, err := app.models.Copies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -791,17 +1231,867 @@ func (app *application) deleteMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.flashInfo(r, "Member deleted successfully.")
-	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
-}
+	redirect := fmt.Sprintf("/dashboard/books/%d/copies", c.BookID)
 
-func splitAndTrim(s string) []string {
-	var result []string
-	for _, part := range strings.Split(s, ",") {
-		trimmed := strings.TrimSpace(part)
-		if trimmed != "" {
-			result = append(result, trimmed)
+	before := *c
+	previous := c.Status
+	v := validator.New()
+	readCopyForm(r, c, v)
+
+	if data.ValidateCopy(v, c, previous); !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditCopyUpdate, data.AuditTargetCopy, c.ID, &before, c)
+	err = app.models.Copies.Update(c, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			app.flashError(r, "A copy with this barcode already exists.")
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+		case errors.Is(err, data.ErrCopyStatusChanged):
+			app.flashError(r, "This copy's status changed while you were editing it. Please try again.")
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "Copy "+c.Barcode+" updated successfully.")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+func (app *application) updateMember(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	before := *user
+	user.Name = strings.TrimSpace(r.FormValue("name"))
+	user.Email = strings.TrimSpace(r.FormValue("email"))
+	user.Role = strings.TrimSpace(r.FormValue("role"))
+	user.Tier = nil
+	if tier := strings.TrimSpace(r.FormValue("tier")); tier != "" {
+		user.Tier = &tier
+	}
+	user.Activated = r.FormValue("activated") == "1"
+
+	v := validator.New()
+	data.ValidateMember(v, user)
+
+	if !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditMemberUpdate, data.AuditTargetMember, user.ID, &before, user)
+	err = app.models.Users.Update(user, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.flashError(r, "Email address already in use.")
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "Member updated successfully.")
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (app *application) deleteMember(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	if app.contextGetUser(r).ID == id {
+		app.flashError(r, "You cannot delete your own account.")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	member, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditMemberDelete, data.AuditTargetMember, id, member, nil)
+	err = app.models.Users.Delete(id, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		case errors.Is(err, data.ErrMemberHasLoans):
+			app.flashError(r, "This member still has books on loan.")
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "Member moved to the trash.")
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (app *application) recordFineTransaction(w http.ResponseWriter, r *http.Request) {
+	memberID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || memberID < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	balance, err := app.models.Fines.Balance(memberID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	adminID := app.contextGetUser(r).ID
+	t := &data.FineTransaction{
+		UserID:    memberID,
+		Kind:      r.PostForm.Get("kind"),
+		Note:      strings.TrimSpace(r.PostForm.Get("note")),
+		CreatedBy: &adminID,
+	}
+
+	v := validator.New()
+	t.AmountCents, err = parseCents(r.PostForm.Get("amount"))
+	if err != nil {
+		v.AddError("amount", "Amount must be a valid amount")
+	}
+
+	if data.ValidateFineTransaction(v, t, balance); !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	ev := app.auditEvent(r, fineAuditAction(t.Kind), data.AuditTargetMember, t.UserID, nil, t)
+	err = app.models.Fines.Record(t, ev)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if t.Kind == data.FineWaiver {
+		app.flashInfo(r, "Fine waived successfully.")
+	} else {
+		app.flashInfo(r, "Payment recorded successfully.")
+	}
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// readPolicyForm copies the fields shared by the add and edit policy forms
+// onto p. A blank role, tier, genre or item type means the policy applies
+// to any.
+func readPolicyForm(r *http.Request, p *data.CirculationPolicy, v *validator.Validator) {
+	p.Name = strings.TrimSpace(r.PostForm.Get("name"))
+
+	p.Role = nil
+	if role := r.PostForm.Get("role"); role != "" {
+		p.Role = &role
+	}
+	p.Tier = nil
+	if tier := strings.TrimSpace(r.PostForm.Get("tier")); tier != "" {
+		p.Tier = &tier
+	}
+	p.Genre = nil
+	if genre := strings.TrimSpace(r.PostForm.Get("genre")); genre != "" {
+		p.Genre = &genre
+	}
+	p.ItemType = nil
+	if itemType := strings.TrimSpace(r.PostForm.Get("item_type")); itemType != "" {
+		p.ItemType = &itemType
+	}
+
+	fields := map[string]*int{
+		"loan_days":    &p.LoanDays,
+		"max_loans":    &p.MaxLoans,
+		"max_renewals": &p.MaxRenewals,
+		"renewal_days": &p.RenewalDays,
+		"max_holds":    &p.MaxHolds,
+	}
+	for key, dst := range fields {
+		n, err := strconv.Atoi(r.PostForm.Get(key))
+		if err != nil {
+			v.AddError(key, "Policy limits must be whole numbers")
+			continue
+		}
+		*dst = n
+	}
+}
+
+func (app *application) createPolicy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	p := &data.CirculationPolicy{}
+	v := validator.New()
+	readPolicyForm(r, p, v)
+
+	if data.ValidatePolicy(v, p); !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditPolicyCreate, data.AuditTargetPolicy, 0, nil, p)
+	err = app.models.Policies.Insert(p, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePolicy):
+			app.flashError(r, "A policy for this scope already exists.")
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "Policy added successfully.")
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (app *application) updatePolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	p, err := app.models.Policies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	before := *p
+	isDefault := p.IsDefault()
+	v := validator.New()
+	readPolicyForm(r, p, v)
+
+	if !isDefault && p.IsDefault() {
+		v.AddError("role", "Only the default policy may apply to everyone and every book")
+	}
+
+	if data.ValidatePolicy(v, p); !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditPolicyUpdate, data.AuditTargetPolicy, p.ID, &before, p)
+	err = app.models.Policies.Update(p, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		case errors.Is(err, data.ErrDuplicatePolicy):
+			app.flashError(r, "A policy for this scope already exists.")
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "Policy updated successfully.")
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (app *application) deletePolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	p, err := app.models.Policies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditPolicyDelete, data.AuditTargetPolicy, id, p, nil)
+	err = app.models.Policies.Delete(id, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		case errors.Is(err, data.ErrDefaultPolicy):
+			app.flashError(r, "The default policy cannot be deleted.")
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "Policy deleted successfully.")
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (app *application) updateSettings(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	activationRequired := r.FormValue("activation_required")
+
+	v := validator.New()
+	v.Check(
+		activationRequired == data.ActivationRequiredNone ||
+			activationRequired == data.ActivationRequiredBorrowing ||
+			activationRequired == data.ActivationRequiredLogin,
+		"activation_required",
+		"Invalid activation requirement",
+	)
+
+	pickupDays, err := strconv.Atoi(r.FormValue("hold_pickup_days"))
+	v.Check(
+		err == nil && pickupDays >= 1 && pickupDays <= 30,
+		"hold_pickup_days",
+		"Hold pickup window must be between 1 and 30 days",
+	)
+
+	retentionDays, err := strconv.Atoi(r.FormValue("trash_retention_days"))
+	v.Check(
+		err == nil && retentionDays >= 1 && retentionDays <= 365,
+		"trash_retention_days",
+		"Trash retention period must be between 1 and 365 days",
+	)
+
+	fineDaily, err := parseCents(r.FormValue("fine_daily"))
+	v.Check(err == nil, "fine_daily", "Daily fine must be a valid amount")
+
+	fineGraceDays, err := strconv.Atoi(r.FormValue("fine_grace_days"))
+	v.Check(
+		err == nil && fineGraceDays >= 0 && fineGraceDays <= 30,
+		"fine_grace_days",
+		"Fine grace period must be between 0 and 30 days",
+	)
+
+	fineMax, err := parseCents(r.FormValue("fine_max"))
+	v.Check(err == nil, "fine_max", "Maximum fine per item must be a valid amount")
+
+	fineBlock, err := parseCents(r.FormValue("fine_block"))
+	v.Check(err == nil, "fine_block", "Borrowing block threshold must be a valid amount")
+
+	if !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	settings := map[string]string{
+		data.SettingActivationRequired: activationRequired,
+		data.SettingHoldPickupDays:     strconv.Itoa(pickupDays),
+		data.SettingTrashRetentionDays: strconv.Itoa(retentionDays),
+		data.SettingFineDailyCents:     strconv.Itoa(fineDaily),
+		data.SettingFineGraceDays:      strconv.Itoa(fineGraceDays),
+		data.SettingFineMaxCents:       strconv.Itoa(fineMax),
+		data.SettingFineBlockCents:     strconv.Itoa(fineBlock),
+	}
+
+	ev := app.auditEvent(r, data.AuditSettingsUpdate, data.AuditTargetSettings, 0, nil, nil)
+	err = app.models.Settings.SetAll(settings, ev)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.flashInfo(r, "Settings updated successfully.")
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+const maxImportBytes = 20 << 20
+
+// importBooks starts an import in the background and returns straight
+// away. Files named .mrc, .marc or .xml are read as MARC records, anything
+// else as CSV. The job shows up on the dashboard, with its report once it finishes.
+func (app *application) importBooks(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	err := r.ParseMultipartForm(maxImportBytes)
+	if err != nil {
+		app.flashError(r, "The import must be a CSV or MARC file of at most 20 MB.")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		app.flashError(r, "Choose a CSV or MARC file to import.")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	mapping, err := data.ParseImportMapping(r.FormValue("mapping"))
+	if err != nil {
+		app.flashError(r, fmt.Sprintf("Column mapping: %s.", err))
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	dryRun := r.FormValue("dry_run") != ""
+	userID := app.contextGetUser(r).ID
+
+	job, err := app.models.Imports.Start(&userID, header.Filename, dryRun)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditBookImport, data.AuditTargetBook, 0, nil, nil)
+
+	app.background(func() {
+		opts := data.ImportOptions{Mapping: mapping, DryRun: dryRun, Audit: ev}
+
+		var report *data.ImportReport
+		var err error
+		if marc.HasMARCExtension(header.Filename) {
+			report, err = app.models.Books.ImportFrom(marc.NewImportSource(bytes.NewReader(content)), opts)
+		} else {
+			report, err = app.models.Books.Import(bytes.NewReader(content), opts)
+		}
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("import %d: %w", job.ID, err))
+		}
+
+		err = app.models.Imports.Finish(job, report, err)
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("import %d: %w", job.ID, err))
+		}
+	})
+
+	if dryRun {
+		app.flashInfo(r, "Dry run started. Its report will be listed under Imports.")
+	} else {
+		app.flashInfo(r, "Import started. Its report will be listed under Imports.")
+	}
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (app *application) importReport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	job, report, err := app.models.Imports.GetReport(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf(`attachment; filename="import-%d-report.csv"`, job.ID),
+	)
+
+	err = report.WriteCSV(w)
+	if err != nil {
+		app.logger.PrintError(err)
+	}
+}
+
+type activationForm struct {
+	Token string
+	Email string
+	validator.Validator
+}
+
+func (app *application) activate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.DisplayNav = false
+	data.Form = activationForm{Token: r.URL.Query().Get("token")}
+	app.render(w, 200, "activate.html", data)
+}
+
+func (app *application) activatePost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	form := activationForm{
+		Token:     strings.TrimSpace(r.FormValue("token")),
+		Validator: *validator.New(),
+	}
+
+	data.ValidateTokenPlaintext(&form.Validator, form.Token)
+
+	if form.Valid() {
+		err = app.activateUser(form.Token)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			form.AddError("token", "invalid or expired activation token")
+		case err != nil:
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.DisplayNav = false
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "activate.html", data)
+		return
+	}
+
+	app.flashInfo(r, "Your account has been activated.")
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// activateUser marks the owner of an activation token as activated and
+// deletes all of their outstanding activation tokens.
+func (app *application) activateUser(tokenPlaintext string) error {
+	user, err := app.models.Users.GetForToken(data.PurposeActivation, tokenPlaintext)
+	if err != nil {
+		return err
+	}
+
+	user.Activated = true
+
+	err = app.models.Users.Update(user, nil)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Tokens.DeleteAllForUser(data.PurposeActivation, user.ID)
+}
+
+func (app *application) resendActivation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	form := activationForm{
+		Email:     strings.TrimSpace(r.FormValue("email")),
+		Validator: *validator.New(),
+	}
+
+	if data.ValidateEmail(&form.Validator, form.Email); !form.Valid() {
+		data := app.newTemplateData(r)
+		data.DisplayNav = false
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "activate.html", data)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(form.Email)
+	switch {
+	case err == nil && !user.Activated:
+		err = app.sendActivationEmail(user)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverError(w, err)
+		return
+	}
+
+	// The same message is shown whether or not the address exists so the
+	// form can't be used to enumerate accounts.
+	app.flashInfo(r, "If that account needs activating, a new activation email is on its way.")
+	http.Redirect(w, r, "/activate", http.StatusSeeOther)
+}
+
+type passwordResetForm struct {
+	Email           string
+	Token           string
+	Password        string
+	ConfirmPassword string
+	validator.Validator
+}
+
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.DisplayNav = false
+	data.Form = passwordResetForm{}
+	app.render(w, 200, "forgot_password.html", data)
+}
+
+func (app *application) forgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	form := passwordResetForm{
+		Email:     strings.TrimSpace(r.FormValue("email")),
+		Validator: *validator.New(),
+	}
+
+	if data.ValidateEmail(&form.Validator, form.Email); !form.Valid() {
+		data := app.newTemplateData(r)
+		data.DisplayNav = false
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "forgot_password.html", data)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(form.Email)
+	switch {
+	case err == nil:
+		err = app.sendPasswordResetEmail(user)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverError(w, err)
+		return
+	}
+
+	app.flashInfo(r, "If an account exists for that email, a password reset link is on its way.")
+	http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.DisplayNav = false
+	data.Form = passwordResetForm{Token: r.URL.Query().Get("token")}
+	app.render(w, 200, "reset_password.html", data)
+}
+
+func (app *application) resetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	form := passwordResetForm{
+		Token:           strings.TrimSpace(r.FormValue("token")),
+		Password:        r.FormValue("password"),
+		ConfirmPassword: r.FormValue("confirm_password"),
+		Validator:       *validator.New(),
+	}
+
+	data.ValidateTokenPlaintext(&form.Validator, form.Token)
+	data.ValidatePasswordPlaintext(&form.Validator, form.Password)
+	if _, ok := form.Errors["password"]; !ok {
+		form.Check(
+			form.ConfirmPassword == form.Password,
+			"confirm_password",
+			"passwords do not match",
+		)
+	}
+
+	if form.Valid() {
+		err = app.models.Users.ResetPassword(form.Token, form.Password)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			form.AddError("token", "invalid or expired password reset token")
+		case err != nil:
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		form.Password, form.ConfirmPassword = "", ""
+		data := app.newTemplateData(r)
+		data.DisplayNav = false
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "reset_password.html", data)
+		return
+	}
+
+	app.flashInfo(r, "Your password has been reset. Please log in with your new password.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (app *application) createAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequest(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	expiresIn, err := strconv.Atoi(r.FormValue("expires_in"))
+	if err != nil || expiresIn < 0 || expiresIn > 365 {
+		app.flashError(r, "Invalid token expiry.")
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		return
+	}
+
+	token := &data.Token{
+		Name:   strings.TrimSpace(r.FormValue("name")),
+		Scopes: r.Form["scopes"],
+	}
+
+	v := validator.New()
+	if data.ValidateAPIToken(v, token, data.ScopesForRole(user.Role)); !v.Valid() {
+		app.flashError(r, joinErrors(v.Errors))
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		return
+	}
+
+	ttl := time.Duration(expiresIn) * 24 * time.Hour
+	token, err = app.models.Tokens.NewAPIToken(user.ID, token.Name, token.Scopes, ttl)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r.Context(), "new_api_token", token.Plaintext)
+	app.flashInfo(r, "API token created. Copy it now, it won't be shown again.")
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
+
+func (app *application) revokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	err = app.models.Tokens.Delete(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "API token revoked.")
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
+
+func splitAndTrim(s string) []string {
+	result := []string{}
+	for _, part := range strings.Split(s, ",") {
+		trimmed := strings.TrimSpace(part)
+		if trimmed != "" {
+			result = append(result, trimmed)
 		}
 	}
 	return result
 }
+
+// setEditionOf adds the book to the work of the edition with the given ISBN.
+// A blank ISBN leaves the book's work as it is, which for a new book means it
+// starts a work of its own.
+func (app *application) setEditionOf(book *data.Book, isbn string, v *validator.Validator) error {
+	isbn = strings.TrimSpace(isbn)
+	if isbn == "" {
+		return nil
+	}
+
+	workID, err := app.models.Books.WorkIDByISBN(isbn)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("edition_of", "No book with this ISBN exists")
+			return nil
+		}
+		return err
+	}
+
+	book.WorkID = workID
+	return nil
+}
+
+func joinErrors(errs map[string]string) string {
+	var messages []string
+	for field, msg := range errs {
+		messages = append(messages, fmt.Sprintf("%s: %s", field, msg))
+	}
+	return strings.Join(messages, "; ")
+}
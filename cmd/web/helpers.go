@@ -1,11 +1,19 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/validator"
 )
 
 func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
@@ -72,3 +80,259 @@ func (app *application) flashInfo(r *http.Request, msg string) {
 func (app *application) flashError(r *http.Request, msg string) {
 	app.session.Put(r.Context(), "flash_error", msg)
 }
+
+type envelope map[string]any
+
+func (app *application) writeJSON(
+	w http.ResponseWriter,
+	status int,
+	data envelope,
+	headers http.Header,
+) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+	return nil
+}
+
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			if unmarshalTypeError.Field != "" {
+				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+			}
+			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("body contains unknown key %s", fieldName)
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	err = dec.Decode(&struct{}{})
+	if !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+func (app *application) readInt(
+	qs url.Values,
+	key string,
+	defaultValue int,
+	v *validator.Validator,
+) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	return i
+}
+
+func (app *application) readPagination(qs url.Values, defaultLimit int, v *validator.Validator) data.Pagination {
+	p := data.Pagination{
+		Cursor: qs.Get("cursor"),
+		Limit:  app.readInt(qs, "limit", defaultLimit, v),
+	}
+	data.ValidatePagination(v, p)
+	return p
+}
+
+// pageURL is the current URL with the cursor swapped for another page's.
+func pageURL(r *http.Request, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	qs := r.URL.Query()
+	qs.Set("cursor", cursor)
+	return r.URL.Path + "?" + qs.Encode()
+}
+
+// readSearchFilters reads the search query and facet filters from the query
+// string. Facet parameters may repeat, e.g. ?genre=Fiction&genre=Horror.
+func (app *application) readSearchFilters(qs url.Values, v *validator.Validator) data.SearchFilters {
+	f := data.SearchFilters{
+		Query:        strings.TrimSpace(qs.Get("q")),
+		Genres:       qs["genre"],
+		Languages:    qs["language"],
+		Publishers:   qs["publisher"],
+		Availability: strings.ToLower(qs.Get("availability")),
+		Sort:         qs.Get("sort"),
+	}
+
+	// category and availability=borrowed are what the search form sent before
+	// facets were added.
+	if category := qs.Get("category"); category != "" && category != "All Categories" {
+		f.Genres = append(f.Genres, category)
+	}
+	if f.Availability == "borrowed" {
+		f.Availability = data.AvailabilityUnavailable
+	}
+
+	for _, s := range qs["decade"] {
+		decade, err := strconv.Atoi(s)
+		if err != nil {
+			v.AddError("decade", "must be an integer value")
+			continue
+		}
+		f.Decades = append(f.Decades, decade)
+	}
+
+	data.ValidateSearchFilters(v, &f)
+	return f
+}
+
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/v1/")
+}
+
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// activationRequired reports whether the admin-configured activation policy
+// stops an unactivated account from performing action, which is either
+// data.ActivationRequiredLogin or data.ActivationRequiredBorrowing.
+func (app *application) activationRequired(user *data.User, action string) (bool, error) {
+	if user.Activated {
+		return false, nil
+	}
+
+	policy, err := app.models.Settings.Get(data.SettingActivationRequired)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	switch policy {
+	case data.ActivationRequiredLogin:
+		return true, nil
+	case data.ActivationRequiredBorrowing:
+		return action == data.ActivationRequiredBorrowing, nil
+	default:
+		return false, nil
+	}
+}
+
+// parseCents parses a decimal amount such as "2.50" into cents.
+func parseCents(s string) (int, error) {
+	whole, frac, found := strings.Cut(strings.TrimSpace(s), ".")
+	if whole == "" {
+		whole = "0"
+	}
+
+	units, err := strconv.Atoi(whole)
+	if err != nil || units < 0 {
+		return 0, errors.New("invalid amount")
+	}
+
+	cents := 0
+	if found {
+		if len(frac) == 0 || len(frac) > 2 {
+			return 0, errors.New("invalid amount")
+		}
+		if len(frac) == 1 {
+			frac += "0"
+		}
+		cents, err = strconv.Atoi(frac)
+		if err != nil || cents < 0 {
+			return 0, errors.New("invalid amount")
+		}
+	}
+
+	return units*100 + cents, nil
+}
+
+func (app *application) sendActivationEmail(user *data.User) error {
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.PurposeActivation)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		emailData := map[string]any{
+			"name":          user.Name,
+			"activationURL": app.config.baseURL + "/activate?token=" + url.QueryEscape(token.Plaintext),
+		}
+
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", emailData)
+		if err != nil {
+			app.logger.PrintError(err)
+		}
+	})
+
+	return nil
+}
+
+func (app *application) sendPasswordResetEmail(user *data.User) error {
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.PurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		emailData := map[string]any{
+			"name":     user.Name,
+			"resetURL": app.config.baseURL + "/password/reset?token=" + url.QueryEscape(token.Plaintext),
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", emailData)
+		if err != nil {
+			app.logger.PrintError(err)
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// runPeriodically calls fn every interval until the application shuts down.
+// Like background, the goroutine is tracked by app.wg so shutdown waits for a
+// run that is already in progress.
+func (app *application) runPeriodically(name string, interval time.Duration, fn func() error) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.done:
+				return
+			case <-ticker.C:
+				func() {
+					defer func() {
+						if err := recover(); err != nil {
+							app.logger.PrintError(fmt.Errorf("%s: %v", name, err))
+						}
+					}()
+
+					if err := fn(); err != nil {
+						app.logger.PrintError(fmt.Errorf("%s: %w", name, err))
+					}
+				}()
+			}
+		}
+	}()
+}
+
+func (app *application) startJobs() {
+	app.runPeriodically("process hold queues", time.Minute, func() error {
+		_, err := app.models.Holds.ProcessQueues()
+		return err
+	})
+
+	app.runPeriodically("accrue overdue fines", time.Hour, func() error {
+		_, err := app.models.Fines.AccrueOverdue()
+		return err
+	})
+
+	app.runPeriodically("refresh search terms", 15*time.Minute, app.models.Books.RefreshSearchTerms)
+
+	app.runPeriodically("purge trash", time.Hour, app.purgeTrash)
+}
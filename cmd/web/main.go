@@ -7,24 +7,43 @@ import (
 	"html/template"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/alexedwards/scs/postgresstore"
 	"github.com/alexedwards/scs/v2"
 	_ "github.com/lib/pq"
 
+	"github.com/0xrinful/LibraryMS/internal/covers"
 	"github.com/0xrinful/LibraryMS/internal/data"
 	"github.com/0xrinful/LibraryMS/internal/logger"
+	"github.com/0xrinful/LibraryMS/internal/mailer"
+	"github.com/0xrinful/LibraryMS/internal/metadata"
+	"github.com/0xrinful/LibraryMS/internal/storage"
 )
 
 type config struct {
-	port int
-	db   struct {
+	port    int
+	baseURL string
+	db      struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	mailDir  string
+	mediaDir string
+	metadata struct {
+		url      string
+		fixtures string
+	}
 }
 
 type application struct {
@@ -33,6 +52,12 @@ type application struct {
 	models        data.Models
 	templateCache map[string]*template.Template
 	session       *scs.SessionManager
+	mailer        *mailer.Mailer
+	metadata      metadata.Provider
+	media         storage.Store
+	covers        *covers.Covers
+	wg            sync.WaitGroup
+	done          chan struct{}
 }
 
 func main() {
@@ -58,14 +83,23 @@ func main() {
 	sessionManager.Cookie.SameSite = http.SameSiteLaxMode
 	sessionManager.Cookie.Secure = false
 
+	media := storage.FileStore{Dir: cfg.mediaDir}
+
 	app := &application{
 		config:        cfg,
 		logger:        logger,
 		models:        data.NewModels(db),
 		templateCache: cache,
 		session:       sessionManager,
+		mailer:        mailer.New(newMailSender(cfg), cfg.smtp.sender),
+		metadata:      newMetadataProvider(cfg),
+		media:         media,
+		covers:        covers.New(media),
+		done:          make(chan struct{}),
 	}
 
+	app.startJobs()
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err)
@@ -97,9 +131,39 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 }
 
+// newMailSender delivers through SMTP when a host is configured and falls
+// back to writing .eml files into the mail directory otherwise.
+func newMailSender(cfg config) mailer.Sender {
+	if cfg.smtp.host == "" {
+		return mailer.FileSender{Dir: cfg.mailDir}
+	}
+	return mailer.SMTPSender{
+		Host:     cfg.smtp.host,
+		Port:     cfg.smtp.port,
+		Username: cfg.smtp.username,
+		Password: cfg.smtp.password,
+	}
+}
+
+// newMetadataProvider answers ISBN lookups from the fixtures directory when
+// one is configured, and from -metadata-url, an Open Library compatible API,
+// otherwise.
+func newMetadataProvider(cfg config) metadata.Provider {
+	if cfg.metadata.fixtures != "" {
+		return metadata.FixtureProvider{Dir: cfg.metadata.fixtures}
+	}
+	return metadata.OpenLibraryProvider{BaseURL: cfg.metadata.url}
+}
+
 func parseFlags() config {
 	var cfg config
 	flag.IntVar(&cfg.port, "port", 8000, "Web Server port")
+	flag.StringVar(
+		&cfg.baseURL,
+		"base-url",
+		"http://localhost:8000",
+		"Public base URL used for links in emails",
+	)
 	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
@@ -109,6 +173,29 @@ func parseFlags() config {
 		"15m",
 		"PostgreSQL max connection idle time",
 	)
+
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host (empty writes emails to -mail-dir)")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	flag.StringVar(
+		&cfg.smtp.sender,
+		"smtp-sender",
+		"LibraryMS <no-reply@libraryms.local>",
+		"SMTP sender",
+	)
+	flag.StringVar(&cfg.mailDir, "mail-dir", "./tmp/mail", "Directory for emails when SMTP is disabled")
+
+	flag.StringVar(&cfg.mediaDir, "media-dir", "./media", "Directory for uploaded cover images")
+
+	flag.StringVar(&cfg.metadata.url, "metadata-url", "https://openlibrary.org", "Book metadata API base URL")
+	flag.StringVar(
+		&cfg.metadata.fixtures,
+		"metadata-fixtures",
+		"",
+		"Directory of book metadata fixtures to use instead of -metadata-url",
+	)
+
 	flag.Parse()
 	return cfg
 }
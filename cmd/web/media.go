@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/0xrinful/LibraryMS/internal/covers"
+	"github.com/0xrinful/LibraryMS/internal/storage"
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+// serveMedia serves stored objects such as cover images. Their keys change
+// whenever their content does, so browsers may cache them for good.
+func (app *application) serveMedia(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/media/")
+
+	obj, err := app.media.Open(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+	defer obj.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, key, obj.ModTime, obj)
+}
+
+// parseBookForm parses a dashboard book form, which is multipart when it
+// carries an uploaded cover.
+func parseBookForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, covers.MaxBytes+1<<20)
+
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return err
+	}
+	return nil
+}
+
+// uploadedCover returns the cover file of a book form, or nil if none was
+// chosen.
+func uploadedCover(r *http.Request) (io.ReadCloser, error) {
+	file, _, err := r.FormFile("cover_file")
+	if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+		return nil, nil
+	}
+	return file, err
+}
+
+// saveCover stores the cover given for a book: an uploaded file, or else a
+// cover URL other than the book's current one, which is fetched once so it
+// doesn't have to be hotlinked. It returns the cover URL to save. A cover that
+// can't be used is reported through v; the error is for failing to store it.
+func (app *application) saveCover(
+	ctx context.Context,
+	file io.Reader,
+	coverURL, current string,
+	v *validator.Validator,
+) (string, error) {
+	var stored string
+	var err error
+
+	switch {
+	case file != nil:
+		stored, err = app.covers.Save(ctx, file)
+	case coverURL == "" || coverURL == current || covers.IsStored(coverURL):
+		return coverURL, nil
+	default:
+		stored, err = app.covers.Fetch(ctx, coverURL)
+	}
+
+	var invalid *covers.InvalidError
+	if errors.As(err, &invalid) {
+		v.AddError("cover_image", invalid.Reason)
+		return coverURL, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return stored, nil
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/0xrinful/rush"
 
+	"github.com/0xrinful/LibraryMS/internal/data"
 	"github.com/0xrinful/LibraryMS/ui"
 )
 
@@ -16,6 +17,7 @@ func (app *application) routes() http.Handler {
 
 	fileServer := http.FileServer(http.FS(ui.Files))
 	r.Handle("/static/*", fileServer, "GET")
+	r.Get("/media/*", app.serveMedia)
 
 	r.Get("/", app.home)
 
@@ -28,29 +30,124 @@ func (app *application) routes() http.Handler {
 	})
 	r.Get("/logout", app.logout)
 
+	r.Get("/activate", app.activate)
+	r.Post("/activate", app.activatePost)
+	r.Post("/activate/resend", app.resendActivation)
+
+	r.Get("/password/forgot", app.forgotPassword)
+	r.Post("/password/forgot", app.forgotPasswordPost)
+	r.Get("/password/reset", app.resetPassword)
+	r.Post("/password/reset", app.resetPasswordPost)
+
 	r.Get("/search", app.search)
+	r.Get("/search/suggest", app.searchSuggest)
 	r.Get("/books/{id}", app.displayBook)
+	r.Get("/authors/{id}", app.displayAuthor)
 	r.Get("/books", app.booksFragment)
 
 	r.Group(func(r *rush.Router) {
 		r.Use(app.requireAuthentication)
 
 		r.Get("/profile", app.profile)
+		r.Post("/profile/tokens", app.createAPIToken)
+		r.Post("/profile/tokens/{id}/revoke", app.revokeAPIToken)
 		r.Post("/books/{id}/borrow", app.borrowBook)
 		r.Post("/books/{id}/return", app.returnBook)
+		r.Post("/books/{id}/renew", app.renewBook)
+		r.Post("/books/{id}/hold", app.placeHold)
+		r.Post("/holds/{id}/cancel", app.cancelHold)
 
 		r.Group(func(r *rush.Router) {
 			r.Use(app.requireAdmin)
 
 			r.Get("/dashboard", app.dashboard)
+			r.Post("/dashboard/settings", app.updateSettings)
 			// Dashboard book management routes
+			r.Get("/dashboard/metadata", app.lookupMetadata)
 			r.Post("/dashboard/books", app.createBook)
 			r.Post("/dashboard/books/{id}/update", app.updateBook)
 			r.Post("/dashboard/books/{id}/delete", app.deleteBook)
+			r.Post("/dashboard/imports", app.importBooks)
+			r.Get("/dashboard/imports/{id}/report", app.importReport)
+			r.Get("/dashboard/exports/{dataset}", app.exportData)
+			r.Get("/dashboard/audit", app.auditLog)
+			r.Get("/dashboard/audit/export", app.exportAudit)
+			r.Post("/dashboard/trash/books/{id}/restore", app.restoreBook)
+			r.Post("/dashboard/trash/members/{id}/restore", app.restoreMember)
+			r.Get("/search/marc", app.exportSearch)
+			r.Get("/dashboard/books/{id}/copies", app.bookCopies)
+			r.Post("/dashboard/books/{id}/copies", app.createCopy)
+			r.Post("/dashboard/copies/{id}/update", app.updateCopy)
 
 			// Dashboard member management routes
 			r.Post("/dashboard/members/{id}/update", app.updateMember)
 			r.Post("/dashboard/members/{id}/delete", app.deleteMember)
+			r.Post("/dashboard/members/{id}/fines", app.recordFineTransaction)
+
+			// Dashboard circulation policy routes
+			r.Post("/dashboard/policies", app.createPolicy)
+			r.Post("/dashboard/policies/{id}/update", app.updatePolicy)
+			r.Post("/dashboard/policies/{id}/delete", app.deletePolicy)
+		})
+	})
+
+	r.GroupWithPrefix("/v1", func(r *rush.Router) {
+		r.Put("/users/activated", app.apiActivateUser)
+
+		r.Group(func(r *rush.Router) {
+			r.Use(app.requireScope(data.ScopeBooksRead))
+
+			r.Get("/books", app.apiListBooks)
+			r.Get("/books/search", app.apiSearchBooks)
+			r.Get("/books/{id}", app.apiShowBook)
+		})
+
+		r.Group(func(r *rush.Router) {
+			r.Use(app.requireAuthentication)
+
+			r.Get("/me", app.apiShowCurrentMember)
+
+			r.With(app.requireScope(data.ScopeCirculationWrite)).
+				Post("/books/{id}/borrow", app.apiBorrowBook)
+			r.With(app.requireScope(data.ScopeCirculationWrite)).
+				Post("/books/{id}/return", app.apiReturnBook)
+			r.With(app.requireScope(data.ScopeCirculationWrite)).
+				Post("/books/{id}/renew", app.apiRenewBook)
+			r.With(app.requireScope(data.ScopeCirculationRead)).
+				Get("/borrows/current", app.apiCurrentBorrows)
+			r.With(app.requireScope(data.ScopeCirculationRead)).
+				Get("/borrows/history", app.apiBorrowHistory)
+			r.With(app.requireScope(data.ScopeCirculationWrite)).
+				Post("/books/{id}/hold", app.apiPlaceHold)
+			r.With(app.requireScope(data.ScopeCirculationRead)).
+				Get("/holds", app.apiListHolds)
+			r.With(app.requireScope(data.ScopeCirculationRead)).
+				Get("/fines", app.apiListFines)
+			r.With(app.requireScope(data.ScopeCirculationWrite)).
+				Delete("/holds/{id}", app.apiCancelHold)
+
+			r.Group(func(r *rush.Router) {
+				r.Use(app.requireAdmin, app.requireScope(data.ScopeBooksWrite))
+
+				r.Post("/books", app.apiCreateBook)
+				r.Patch("/books/{id}", app.apiUpdateBook)
+				r.Delete("/books/{id}", app.apiDeleteBook)
+				r.Get("/books/{id}/copies", app.apiListCopies)
+				r.Post("/books/{id}/copies", app.apiCreateCopy)
+				r.Patch("/copies/{id}", app.apiUpdateCopy)
+			})
+
+			r.Group(func(r *rush.Router) {
+				r.Use(app.requireAdmin, app.requireScope(data.ScopeAdminMembers))
+
+				r.Get("/members", app.apiListMembers)
+				r.Get("/renewals", app.apiListRenewals)
+				r.Get("/members/{id}", app.apiShowMember)
+				r.Patch("/members/{id}", app.apiUpdateMember)
+				r.Delete("/members/{id}", app.apiDeleteMember)
+				r.Get("/members/{id}/fines", app.apiShowMemberFines)
+				r.Post("/members/{id}/fines", app.apiRecordFineTransaction)
+			})
 		})
 	})
 
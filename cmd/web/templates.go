@@ -1,10 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/0xrinful/LibraryMS/internal/covers"
 	"github.com/0xrinful/LibraryMS/internal/data"
 	"github.com/0xrinful/LibraryMS/ui"
 )
@@ -18,23 +22,104 @@ type templateData struct {
 	User            *data.User
 	Book            *data.Book
 	Books           []*data.Book
+	Editions        []*data.Book
+
+	Contributor      *data.Contributor
+	ContributedBooks []*data.ContributedBook
 
 	CurrentBorrows []*data.BorrowedBook
 	BorrowHistory  []*data.BorrowedBook
 	ActiveBorrows  int
 	TotalBorrowed  int
 
-	SearchQuery        string
-	SearchCategory     string
-	SearchAvailability string
-	SearchSort         string
+	Copies []*data.Copy
+
+	Hold            *data.Hold
+	Holds           []*data.Hold
+	HoldQueueLength int
+	Renewals        []*data.Renewal
+
+	Policy   *data.CirculationPolicy
+	Policies []*data.CirculationPolicy
+
+	FinePolicy   data.FinePolicy
+	FineBalance  int
+	FineLedger   []*data.FineTransaction
+	FineBalances []*data.FineBalance
+
+	Tokens      []*data.Token
+	TokenScopes []string
+	NewToken    string
+
+	Search        data.SearchFilters
+	QueryError    string
+	Facets        *data.SearchFacets
+	FuzzySearch   bool
+	Suggestion    string
+	SuggestionURL string
+
+	MARCExportURL    string
+	MARCXMLExportURL string
+
+	Metadata    data.Metadata
+	NextPageURL string
+	PrevPageURL string
 
 	TotalBooks    int
 	TotalMembers  int
 	BooksBorrowed int
 	OverdueBooks  int
 
-	Members []*data.User
+	Members            []*data.User
+	ActivationRequired string
+	HoldPickupDays     int
+
+	Imports      []*data.ImportJob
+	ImportFields []string
+
+	AuditEvents    []*data.AuditEvent
+	AuditFilters   data.AuditFilters
+	AuditExportURL string
+
+	DeletedBooks       []*data.Book
+	DeletedMembers     []*data.User
+	TrashRetentionDays int
+}
+
+// purgeDate is when a record deleted at t is purged from the trash.
+func purgeDate(t *time.Time, retentionDays int) time.Time {
+	return t.AddDate(0, 0, retentionDays)
+}
+
+// money formats an amount in cents as a decimal, e.g. 250 as "2.50".
+func money(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// highlight escapes a search snippet and wraps its matched words in <mark>.
+func highlight(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, data.HighlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, data.HighlightStop, "</mark>")
+	return template.HTML(escaped)
+}
+
+var functions = template.FuncMap{
+	"money":           money,
+	"highlight":       highlight,
+	"contributorList": data.FormatContributors,
+	"thumbnail":       covers.ThumbnailURL,
+	"copyConditions":  func() []string { return data.CopyConditions },
+	"copyStatuses":    func() []string { return data.CopyStatuses },
+	"searchSorts":     func() []string { return data.SearchSorts },
+	"auditActions":    func() []string { return data.AuditActions },
+	"auditTargets":    func() []string { return data.AuditTargetTypes },
+	"purgeDate":       purgeDate,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -54,7 +139,7 @@ func newTemplateCache() (map[string]*template.Template, error) {
 			page,
 		}
 
-		ts, err := template.New(name).ParseFS(ui.Files, patterns...)
+		ts, err := template.New(name).Funcs(functions).ParseFS(ui.Files, patterns...)
 		if err != nil {
 			return nil, err
 		}
@@ -69,7 +154,7 @@ func newTemplateCache() (map[string]*template.Template, error) {
 
 	for _, partial := range partials {
 		name := filepath.Base(partial)
-		ts, err := template.New(name).ParseFS(ui.Files, partial)
+		ts, err := template.New(name).Funcs(functions).ParseFS(ui.Files, partial)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+)
+
+func (app *application) restoreBook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditBookRestore, data.AuditTargetBook, int64(id), nil, nil)
+	err = app.models.Books.Restore(id, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "Book restored from the trash.")
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (app *application) restoreMember(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	ev := app.auditEvent(r, data.AuditMemberRestore, data.AuditTargetMember, id, nil, nil)
+	err = app.models.Users.Restore(id, ev)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFound(w, r)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.flashError(r, "Another member now uses this member's email address.")
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.flashInfo(r, "Member restored from the trash.")
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// purgeTrash permanently deletes the books and members that have been in the
+// trash for longer than the retention period.
+func (app *application) purgeTrash() error {
+	days, err := app.models.Settings.GetInt(data.SettingTrashRetentionDays, 30)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	books, err := app.models.Books.Purge(cutoff)
+	if err != nil {
+		return err
+	}
+
+	members, err := app.models.Users.Purge(cutoff)
+	if err != nil {
+		return err
+	}
+
+	if books > 0 || members > 0 {
+		app.logger.PrintInfo(fmt.Sprintf("purged %d books and %d members from the trash", books, members))
+	}
+	return nil
+}
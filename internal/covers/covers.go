@@ -0,0 +1,264 @@
+// Package covers turns uploaded or remote cover images into the sizes the
+// site shows and keeps them in a storage.Store, so covers no longer have to
+// be hotlinked.
+package covers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/storage"
+)
+
+const (
+	// MaxBytes is the largest cover file accepted.
+	MaxBytes = 5 << 20
+
+	// MaxDimension is the largest width or height accepted, which keeps a
+	// small file from decoding into an image too big to hold in memory.
+	MaxDimension = 4000
+
+	// URLPrefix is where stored objects are served from.
+	URLPrefix = "/media/"
+
+	// maxRedirects is how many redirects Fetch follows before giving up.
+	maxRedirects = 5
+)
+
+var contentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// Variant is one of the sizes a cover is stored in, resized to Width pixels
+// wide. Covers narrower than that are kept at their own size.
+type Variant struct {
+	Name  string
+	Width int
+}
+
+// Variants are largest first, since each is resized from the one before.
+var (
+	Detail    = Variant{Name: "detail", Width: 600}
+	Thumbnail = Variant{Name: "thumb", Width: 200}
+
+	variants = []Variant{Detail, Thumbnail}
+)
+
+// InvalidError reports a cover that can't be used, giving the reason in
+// words fit for the admin who supplied it.
+type InvalidError struct {
+	Reason string
+}
+
+func (e *InvalidError) Error() string {
+	return "covers: " + e.Reason
+}
+
+func invalid(format string, args ...any) error {
+	return &InvalidError{Reason: fmt.Sprintf(format, args...)}
+}
+
+type Covers struct {
+	store  storage.Store
+	client *http.Client
+}
+
+func New(store storage.Store) *Covers {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: dialPublic}
+
+	return &Covers{
+		store: store,
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+			// No proxy: the dialer has to see the address the cover
+			// actually comes from.
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			CheckRedirect: checkRedirect,
+		},
+	}
+}
+
+var errNotPublic = errors.New("covers: address is not public")
+
+// nonPublic are the ranges beyond those netip classifies as private,
+// loopback or link-local that no cover should be fetched from.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001::/23"),
+}
+
+// isPublic reports whether an address is on the public internet, so not the
+// server itself, its network or a cloud metadata service.
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublic {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic refuses connections to addresses that aren't public. It runs
+// after the host name is resolved, for every address tried and every
+// redirect followed, so a name that resolves to an internal address is
+// caught as well as a literal one.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !isPublic(ip) {
+		return errNotPublic
+	}
+	return nil
+}
+
+// checkRedirect follows at most maxRedirects redirects, and only to http or
+// https addresses.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > maxRedirects {
+		return invalid("Cover image could not be fetched: too many redirects")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" || req.URL.Host == "" {
+		return invalid("Cover image URL must be an http or https address")
+	}
+	return nil
+}
+
+// Save validates a cover image and stores each of its variants, returning
+// the URL of the detail variant for Book.CoverImage. Covers are stored under
+// a hash of their content, so saving the same file twice stores it once.
+func (c *Covers) Save(ctx context.Context, r io.Reader) (string, error) {
+	content, err := io.ReadAll(io.LimitReader(r, MaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(content) > MaxBytes {
+		return "", invalid("Cover image must not exceed %d MB", MaxBytes>>20)
+	}
+	if len(content) == 0 {
+		return "", invalid("Cover image is empty")
+	}
+
+	if !slices.Contains(contentTypes, http.DetectContentType(content)) {
+		return "", invalid("Cover image must be a JPEG, PNG or GIF file")
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return "", invalid("Cover image could not be read")
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return "", invalid("Cover image must not be larger than %dx%d pixels", MaxDimension, MaxDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return "", invalid("Cover image could not be read")
+	}
+
+	sum := sha256.Sum256(content)
+	id := hex.EncodeToString(sum[:16])
+
+	rgba := flatten(img)
+	for _, v := range variants {
+		rgba = resize(rgba, v.Width)
+
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: 85})
+		if err != nil {
+			return "", err
+		}
+
+		err = c.store.Put(ctx, key(id, v), &buf)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return URLPrefix + key(id, Detail), nil
+}
+
+// Fetch downloads the cover at a URL and saves it.
+func (c *Covers) Fetch(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", invalid("Cover image URL must be an http or https address")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join(contentTypes, ", "))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		var inv *InvalidError
+		switch {
+		case errors.As(err, &inv):
+			return "", inv
+		case errors.Is(err, errNotPublic):
+			return "", invalid("Cover image URL must be a public address")
+		default:
+			return "", invalid("Cover image could not be fetched from %s", u.Host)
+		}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", invalid("Cover image could not be fetched: %s answered %s", u.Host, res.Status)
+	}
+	if res.ContentLength > MaxBytes {
+		return "", invalid("Cover image must not exceed %d MB", MaxBytes>>20)
+	}
+
+	return c.Save(ctx, res.Body)
+}
+
+func key(id string, v Variant) string {
+	return "covers/" + id + "/" + v.Name + ".jpg"
+}
+
+// IsStored reports whether a cover URL is one returned by Save.
+func IsStored(coverURL string) bool {
+	return strings.HasPrefix(coverURL, URLPrefix+"covers/")
+}
+
+// ThumbnailURL returns the URL of the thumbnail of a stored cover. Other
+// cover URLs have no thumbnail and are returned as they are.
+func ThumbnailURL(coverURL string) string {
+	detail := "/" + Detail.Name + ".jpg"
+	if !IsStored(coverURL) || !strings.HasSuffix(coverURL, detail) {
+		return coverURL
+	}
+	return strings.TrimSuffix(coverURL, detail) + "/" + Thumbnail.Name + ".jpg"
+}
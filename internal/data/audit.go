@@ -0,0 +1,346 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+// Audited actions, named after the kind of record they change.
+const (
+	AuditBookCreate     = "book.create"
+	AuditBookUpdate     = "book.update"
+	AuditBookDelete     = "book.delete"
+	AuditBookRestore    = "book.restore"
+	AuditBookImport     = "book.import"
+	AuditBookCover      = "book.cover"
+	AuditCopyCreate     = "copy.create"
+	AuditCopyUpdate     = "copy.update"
+	AuditMemberUpdate   = "member.update"
+	AuditMemberDelete   = "member.delete"
+	AuditMemberRestore  = "member.restore"
+	AuditFinePayment    = "fine.payment"
+	AuditFineWaiver     = "fine.waiver"
+	AuditPolicyCreate   = "policy.create"
+	AuditPolicyUpdate   = "policy.update"
+	AuditPolicyDelete   = "policy.delete"
+	AuditSettingsUpdate = "settings.update"
+)
+
+var AuditActions = []string{
+	AuditBookCreate, AuditBookUpdate, AuditBookDelete, AuditBookRestore, AuditBookImport,
+	AuditBookCover, AuditCopyCreate, AuditCopyUpdate, AuditMemberUpdate, AuditMemberDelete,
+	AuditMemberRestore, AuditFinePayment, AuditFineWaiver, AuditPolicyCreate, AuditPolicyUpdate,
+	AuditPolicyDelete, AuditSettingsUpdate,
+}
+
+// Fines are logged against the member whose balance they change, and
+// settings, which are a single record, with a target ID of zero.
+const (
+	AuditTargetBook     = "book"
+	AuditTargetCopy     = "copy"
+	AuditTargetMember   = "member"
+	AuditTargetPolicy   = "policy"
+	AuditTargetSettings = "settings"
+)
+
+var AuditTargetTypes = []string{
+	AuditTargetBook, AuditTargetCopy, AuditTargetMember, AuditTargetPolicy, AuditTargetSettings,
+}
+
+// auditIgnored are fields left out of diffs because every save changes them.
+var auditIgnored = []string{"version"}
+
+// AuditEvent records one change an admin made.
+type AuditEvent struct {
+	ID         int64                  `json:"id"`
+	ActorID    int64                  `json:"actor_id"`
+	ActorEmail string                 `json:"actor_email"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   int64                  `json:"target_id"`
+	Changes    map[string]AuditChange `json:"changes"`
+	IP         string                 `json:"ip"`
+	CreatedAt  time.Time              `json:"created_at"`
+
+	// Before and After are the record either side of the change, which the
+	// model making it diffs into Changes once After has its final state.
+	Before any `json:"-"`
+	After  any `json:"-"`
+}
+
+// insertAudit writes the event in the transaction making the change it
+// records, so neither is saved without the other. A zero TargetID is taken
+// from targetID, for records that only get an ID as they are created. A nil
+// event records nothing.
+func insertAudit(ctx context.Context, tx *sql.Tx, e *AuditEvent, targetID int64) error {
+	if e == nil {
+		return nil
+	}
+	if e.TargetID == 0 {
+		e.TargetID = targetID
+	}
+
+	changes, err := AuditDiff(e.Before, e.After)
+	if err != nil {
+		return err
+	}
+	e.Changes = changes
+
+	js, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_events (actor_id, actor_email, action, target_type, target_id, changes, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+
+	return tx.QueryRowContext(
+		ctx, query, e.ActorID, e.ActorEmail, e.Action, e.TargetType, e.TargetID, js, e.IP,
+	).Scan(&e.ID, &e.CreatedAt)
+}
+
+// AuditChange is the value of one field before and after a change, as JSON.
+// Before is null for a created record and After for a deleted one.
+type AuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// Fields returns the names of the changed fields in order.
+func (e *AuditEvent) Fields() []string {
+	fields := make([]string, 0, len(e.Changes))
+	for field := range e.Changes {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// AuditDiff compares two versions of a record by the fields of their JSON
+// encoding and returns those that differ. Either may be nil, for a record
+// that was created or deleted.
+func AuditDiff(before, after any) (map[string]AuditChange, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]AuditChange)
+	for field, value := range b {
+		if !bytes.Equal(value, a[field]) {
+			changes[field] = AuditChange{Before: value, After: a[field]}
+		}
+	}
+	for field, value := range a {
+		if _, ok := b[field]; !ok {
+			changes[field] = AuditChange{After: value}
+		}
+	}
+	for _, field := range auditIgnored {
+		delete(changes, field)
+	}
+	return changes, nil
+}
+
+func jsonFields(v any) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if v == nil {
+		return fields, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(js, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// AuditFilters narrows the audit log. Zero values match every event; Actor
+// matches part of the actor's email.
+type AuditFilters struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   int64
+	Period     DateRange
+}
+
+func ValidateAuditFilters(v *validator.Validator, f AuditFilters) {
+	v.Check(len(f.Actor) <= 500, "actor", "Actor must not be more than 500 bytes long")
+	v.Check(f.Action == "" || slices.Contains(AuditActions, f.Action), "action", "Unknown action")
+	v.Check(f.TargetType == "" || slices.Contains(AuditTargetTypes, f.TargetType), "target_type", "Unknown target type")
+	v.Check(f.TargetID >= 0, "target_id", "Target ID must not be negative")
+	ValidateDateRange(v, f.Period)
+}
+
+// where returns the condition matching the filters, with their values
+// appended to args.
+func (f AuditFilters) where(args *[]any) string {
+	conds := []string{"TRUE"}
+	add := func(cond string, value any) {
+		*args = append(*args, value)
+		conds = append(conds, fmt.Sprintf(cond, len(*args)))
+	}
+
+	if f.Actor != "" {
+		add(`actor_email ILIKE '%%' || $%d::text || '%%'`, likeEscaper.Replace(f.Actor))
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID > 0 {
+		add("target_id = $%d", f.TargetID)
+	}
+	if f.Period.From != nil {
+		add("created_at >= $%d::date", *f.Period.From)
+	}
+	if f.Period.To != nil {
+		add("created_at < $%d::date + 1", *f.Period.To)
+	}
+	return strings.Join(conds, " AND ")
+}
+
+var auditKeys = []sortKey{{expr: "id", cast: "bigint", desc: true}}
+
+const auditColumns = `id, actor_id, actor_email, action, target_type, target_id, changes, ip, created_at`
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns a page of the events matching the filters, newest first.
+func (m AuditModel) GetAll(f AuditFilters, p Pagination) ([]*AuditEvent, Metadata, error) {
+	c, err := decodeCursor(p.Cursor, "audit", auditKeys)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var args []any
+	filter := f.where(&args)
+	position, where, orderBy := keysetSQL(auditKeys, c, &args)
+	args = append(args, p.Limit+1)
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s
+		FROM audit_events
+		WHERE %s AND %s
+		%s
+		LIMIT $%d`, auditColumns, position, filter, where, orderBy, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	var positions [][]string
+	for rows.Next() {
+		var keys []string
+		e, err := scanAuditEvent(rows, pq.Array(&keys))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		events = append(events, e)
+		positions = append(positions, keys)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	total, err := m.count(f)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	events, metadata := pageOf(events, positions, "audit", p, c, total)
+	return events, metadata, nil
+}
+
+func (m AuditModel) count(f AuditFilters) (int, error) {
+	var args []any
+	query := `SELECT count(*) FROM audit_events WHERE ` + f.where(&args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var total int
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&total)
+	return total, err
+}
+
+// Export calls fn with every event matching the filters, oldest first.
+func (m AuditModel) Export(f AuditFilters, fn func(*AuditEvent) error) error {
+	var args []any
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM audit_events
+		WHERE %s
+		ORDER BY id`, auditColumns, f.where(&args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// scanAuditEvent scans the auditColumns of a row, followed by any extra
+// columns into dest.
+func scanAuditEvent(rows *sql.Rows, dest ...any) (*AuditEvent, error) {
+	var e AuditEvent
+	var changes []byte
+
+	err := rows.Scan(append([]any{
+		&e.ID, &e.ActorID, &e.ActorEmail, &e.Action, &e.TargetType, &e.TargetID, &changes, &e.IP,
+		&e.CreatedAt,
+	}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(changes, &e.Changes); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
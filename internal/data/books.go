@@ -4,9 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/lib/pq"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+	"github.com/0xrinful/LibraryMS/internal/validator/isbn"
 )
 
 var (
@@ -15,69 +19,183 @@ var (
 	ErrDuplicateISBN     = errors.New("duplicate ISBN")
 )
 
+// Book is one edition of a work. Editions of the same work differ in ISBN,
+// publisher, language or publish date, and share a hold queue.
 type Book struct {
-	ID              int
-	Title           string
-	Author          string
-	PublishDate     time.Time
-	ISBN            string
-	Description     string
-	CoverImage      string
-	Genres          []string
-	Pages           int
-	Language        string
-	Publisher       string
-	CopiesTotal     int
-	CopiesAvailable int
-	Version         int
+	ID              int       `json:"id"`
+	WorkID          int64     `json:"work_id"`
+	Title           string    `json:"title"`
+	Author          string    `json:"author"` // derived from Contributors
+	PublishDate     time.Time `json:"publish_date"`
+	ISBN            string    `json:"isbn"`
+	Description     string    `json:"description"`
+	CoverImage      string    `json:"cover_image"`
+	Genres          []string  `json:"genres"`
+	Pages           int       `json:"pages"`
+	Language        string    `json:"language"`
+	Publisher       string    `json:"publisher"`
+	ItemType        string    `json:"item_type"`
+	CopiesTotal     int       `json:"copies_total"`     // derived from copies
+	CopiesAvailable int       `json:"copies_available"` // derived from copies
+	Version         int       `json:"version"`
+
+	// DeletedAt is when the book was moved to the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	Contributors []BookContributor `json:"contributors,omitempty"`
+
+	// Editions is how many editions of the work matched a search.
+	Editions int `json:"editions,omitempty"`
+
+	// Snippet is an excerpt of the description around the words that matched
+	// a search, with each match wrapped in HighlightStart and HighlightStop.
+	Snippet string `json:"-"`
+}
+
+// Authors returns the book's authors, in credit order.
+func (b *Book) Authors() []BookContributor {
+	var authors []BookContributor
+	for _, c := range b.Contributors {
+		if c.Role == ContributorAuthor {
+			authors = append(authors, c)
+		}
+	}
+	return authors
+}
+
+// OtherContributors returns everyone credited on the book who isn't an
+// author.
+func (b *Book) OtherContributors() []BookContributor {
+	var others []BookContributor
+	for _, c := range b.Contributors {
+		if c.Role != ContributorAuthor {
+			others = append(others, c)
+		}
+	}
+	return others
+}
+
+func ValidateBook(v *validator.Validator, book *Book) {
+	v.Check(validator.NotBlank(book.Title), "title", "Title is required")
+	v.Check(len(book.Title) <= 500, "title", "Title must not exceed 500 characters")
+
+	v.Check(len(book.Authors()) > 0, "contributors", "At least one author is required")
+	v.Check(len(book.Contributors) <= 50, "contributors", "Must not have more than 50 contributors")
+	for _, c := range book.Contributors {
+		v.Check(validator.NotBlank(c.Name), "contributors", "Contributor names must not be blank")
+		v.Check(len(c.Name) <= 500, "contributors", "Contributor names must not exceed 500 characters")
+		v.Check(
+			validator.PermittedValue(c.Role, ContributorRoles...),
+			"contributors",
+			"Contributor roles must be author, editor, translator or illustrator",
+		)
+	}
+
+	v.Check(validator.NotBlank(book.ISBN), "isbn", "ISBN is required")
+	v.Check(isbn.Valid(book.ISBN), "isbn", "ISBN must be a valid ISBN-10 or ISBN-13")
+
+	// Copies are only set when a book is created; afterwards they are
+	// managed one by one through CopyModel.
+	if book.ID == 0 {
+		v.Check(book.CopiesTotal >= 1, "copies_total", "Total copies must be at least 1")
+		v.Check(book.CopiesTotal <= 10000, "copies_total", "Total copies must not exceed 10000")
+	}
+
+	v.Check(book.Pages >= 0, "pages", "Pages cannot be negative")
+	v.Check(book.Pages <= 50000, "pages", "Pages must not exceed 50000")
+
+	v.Check(len(book.Description) <= 5000, "description", "Description must not exceed 5000 characters")
+
+	if book.ItemType != "" {
+		ValidateItemType(v, book.ItemType)
+	}
+}
+
+// DefaultItemType is the item type of a book saved without one.
+const DefaultItemType = "book"
+
+// ValidateItemType checks a book's item type, such as reference or dvd,
+// which circulation policies can be scoped to.
+func ValidateItemType(v *validator.Validator, itemType string) {
+	v.Check(validator.NotBlank(itemType), "item_type", "Item type must not be blank")
+	v.Check(len(itemType) <= 50, "item_type", "Item type must not exceed 50 characters")
 }
 
 type BookModel struct {
 	DB *sql.DB
 }
 
-func (m BookModel) GetBooks(limit, offset int) ([]*Book, error) {
-	query := `
-		SELECT id, title, author, publish_date, isbn, description, cover_image, genres, copies_total, copies_available
+// bookListKeys orders the catalogue by title. Availability changes with
+// every loan, so keying pages on it would let books skip or repeat between
+// pages.
+var bookListKeys = []sortKey{
+	{expr: "books.title", cast: "text"},
+	{expr: "books.id", cast: "bigint"},
+}
+
+func (m BookModel) GetBooks(p Pagination) ([]*Book, Metadata, error) {
+	c, err := decodeCursor(p.Cursor, "books", bookListKeys)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	args := []any{}
+	position, where, orderBy := keysetSQL(bookListKeys, c, &args)
+	args = append(args, p.Limit+1)
+
+	query := fmt.Sprintf(`
+		SELECT id, title, author, publish_date, isbn, description, cover_image, genres,
+		       copies_total, copies_available, %s
 		FROM books
-		ORDER BY copies_available DESC
-		LIMIT $1 OFFSET $2`
+		INNER JOIN book_copy_counts cc ON cc.book_id = books.id
+		WHERE deleted_at IS NULL AND %s
+		%s
+		LIMIT $%d`, position, where, orderBy, len(args))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, limit, offset)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
 	var books []*Book
+	var positions [][]string
 	for rows.Next() {
 		var b Book
-		var genres []string
+		var genres, keys []string
 		if err := rows.Scan(
 			&b.ID, &b.Title, &b.Author, &b.PublishDate, &b.ISBN, &b.Description, &b.CoverImage,
-			pq.Array(&genres), &b.CopiesTotal, &b.CopiesAvailable,
+			pq.Array(&genres), &b.CopiesTotal, &b.CopiesAvailable, pq.Array(&keys),
 		); err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		b.Genres = genres
 		books = append(books, &b)
+		positions = append(positions, keys)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return books, nil
+	total, err := m.Count()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	books, metadata := pageOf(books, positions, "books", p, c, total)
+	return books, metadata, nil
 }
 
 func (m BookModel) GetBookByID(id int) (*Book, error) {
 	query := `
-		SELECT id, title, author, publish_date, isbn, description, cover_image, genres, pages, language, publisher, copies_total, copies_available, version
+		SELECT id, work_id, title, author, publish_date, isbn, description, cover_image, genres, pages, language, publisher, item_type, copies_total, copies_available, version
 		FROM books
-		WHERE id = $1`
+		INNER JOIN book_copy_counts cc ON cc.book_id = books.id
+		WHERE id = $1 AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -87,6 +205,7 @@ func (m BookModel) GetBookByID(id int) (*Book, error) {
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&b.ID,
+		&b.WorkID,
 		&b.Title,
 		&b.Author,
 		&b.PublishDate,
@@ -97,6 +216,7 @@ func (m BookModel) GetBookByID(id int) (*Book, error) {
 		&b.Pages,
 		&b.Language,
 		&b.Publisher,
+		&b.ItemType,
 		&b.CopiesTotal,
 		&b.CopiesAvailable,
 		&b.Version,
@@ -109,38 +229,106 @@ func (m BookModel) GetBookByID(id int) (*Book, error) {
 	}
 
 	b.Genres = genres
+
+	err = loadContributors(ctx, m.DB, &b)
+	if err != nil {
+		return nil, err
+	}
+
 	return &b, nil
 }
 
-func (m BookModel) ISBNExists(isbn string) (bool, error) {
+// GetEditions lists every edition of the work, newest first.
+func (m BookModel) GetEditions(workID int64) ([]*Book, error) {
+	query := `
+		SELECT id, work_id, title, publish_date, isbn, language, publisher, copies_total, copies_available
+		FROM books
+		INNER JOIN book_copy_counts cc ON cc.book_id = books.id
+		WHERE work_id = $1 AND deleted_at IS NULL
+		ORDER BY publish_date DESC, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, workID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []*Book
+	for rows.Next() {
+		var b Book
+		if err := rows.Scan(
+			&b.ID, &b.WorkID, &b.Title, &b.PublishDate, &b.ISBN, &b.Language, &b.Publisher,
+			&b.CopiesTotal, &b.CopiesAvailable,
+		); err != nil {
+			return nil, err
+		}
+		books = append(books, &b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// WorkIDByISBN returns the work of the edition with the given ISBN, in
+// either form.
+func (m BookModel) WorkIDByISBN(number string) (int64, error) {
+	query := `SELECT work_id FROM books WHERE isbn = $1 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var workID int64
+	err := m.DB.QueryRowContext(ctx, query, canonicalISBN(number)).Scan(&workID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrRecordNotFound
+		}
+		return 0, err
+	}
+	return workID, nil
+}
+
+// ISBNExists and ISBNExistsExcluding compare canonical forms, so an ISBN-10
+// or a hyphenated ISBN-13 finds the book stored under the same number. Books
+// in the trash keep their ISBN, so they are included.
+func (m BookModel) ISBNExists(number string) (bool, error) {
 	query := `SELECT EXISTS(SELECT 1 FROM books WHERE isbn = $1)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var exists bool
-	err := m.DB.QueryRowContext(ctx, query, isbn).Scan(&exists)
+	err := m.DB.QueryRowContext(ctx, query, canonicalISBN(number)).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func (m BookModel) ISBNExistsExcluding(isbn string, excludeID int) (bool, error) {
+func (m BookModel) ISBNExistsExcluding(number string, excludeID int) (bool, error) {
 	query := `SELECT EXISTS(SELECT 1 FROM books WHERE isbn = $1 AND id != $2)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var exists bool
-	err := m.DB.QueryRowContext(ctx, query, isbn, excludeID).Scan(&exists)
+	err := m.DB.QueryRowContext(ctx, query, canonicalISBN(number), excludeID).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func (m BookModel) BorrowBook(userID, bookID int64, days int) error {
+// BorrowBook lends the user a copy of the book for the loan period of the
+// circulation policy that applies to them, provided they are under the
+// policy's loan limit and don't owe enough in fines to be blocked.
+func (m BookModel) BorrowBook(userID, bookID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -150,26 +338,96 @@ func (m BookModel) BorrowBook(userID, bookID int64, days int) error {
 	}
 	defer tx.Rollback()
 
-	var available int
-	err = tx.QueryRowContext(ctx,
-		`SELECT copies_available FROM books WHERE id = $1`,
-		bookID,
-	).Scan(&available)
+	_, err = lockBook(ctx, tx, bookID)
+	if err != nil {
+		return err
+	}
+
+	err = checkInCatalogue(ctx, tx, bookID)
+	if err != nil {
+		return err
+	}
+
+	err = lockUser(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Charge the member's overdue loans before checking the block, so fines
+	// the accrual job hasn't got to yet count too.
+	_, err = accrueFines(ctx, tx, "br.user_id = $1 AND br.returned_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+
+	blocked, err := finesBlock(ctx, tx, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return ErrRecordNotFound
-		}
 		return err
 	}
+	if blocked {
+		return ErrFinesOutstanding
+	}
 
-	if available < 1 {
-		return ErrNoAvailableCopies
+	policy, err := resolvePolicy(ctx, tx, userID, bookID)
+	if err != nil {
+		return err
+	}
+
+	var loans int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM borrow_records
+		WHERE user_id = $1 AND returned_at IS NULL
+	`, userID).Scan(&loans)
+	if err != nil {
+		return err
+	}
+	if loans >= policy.MaxLoans {
+		return ErrLoanLimitReached
+	}
+
+	err = refreshHolds(ctx, tx, bookID)
+	if err != nil {
+		return err
+	}
+
+	// A copy set aside for the user's own hold is the one they pick up;
+	// otherwise any copy on the shelf will do.
+	// The hold may have been filled by another edition of the work, in which
+	// case the loan is for that edition.
+	var copyID *int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE holds h
+		SET status = 'fulfilled'
+		FROM books b
+		WHERE b.id = h.book_id
+		  AND b.work_id = (SELECT work_id FROM books WHERE id = $2)
+		  AND h.user_id = $1 AND h.status = 'ready'
+		RETURNING h.copy_id, h.book_id
+	`, userID, bookID).Scan(&copyID, &bookID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if copyID == nil {
+		copyID = new(int64)
+		err = tx.QueryRowContext(ctx, `
+			SELECT id FROM copies
+			WHERE book_id = $1 AND status = 'available'
+			ORDER BY id
+			LIMIT 1
+		`, bookID).Scan(copyID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoAvailableCopies
+			}
+			return err
+		}
 	}
 
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO borrow_records (user_id, book_id, due_at)
-		VALUES ($1, $2, NOW() + ($3 || ' days')::interval)
-	`, userID, bookID, days)
+		INSERT INTO borrow_records (user_id, book_id, copy_id, due_at)
+		VALUES ($1, $2, $3, NOW() + make_interval(days => $4))
+	`, userID, bookID, *copyID, policy.LoanDays)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
 			return ErrAlreadyBorrowed
@@ -177,12 +435,7 @@ func (m BookModel) BorrowBook(userID, bookID int64, days int) error {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		UPDATE books
-		SET copies_available = copies_available - 1,
-		    version = version + 1
-		WHERE id = $1
-	`, bookID)
+	err = setCopyStatus(ctx, tx, *copyID, CopyOnLoan)
 	if err != nil {
 		return err
 	}
@@ -200,96 +453,56 @@ func (m BookModel) ReturnBook(userID, bookID int64) error {
 	}
 	defer tx.Rollback()
 
-	result, err := tx.ExecContext(ctx, `
+	_, err = lockBook(ctx, tx, bookID)
+	if err != nil {
+		return err
+	}
+
+	var borrowID int64
+	var copyID *int64
+	err = tx.QueryRowContext(ctx, `
 		UPDATE borrow_records
 		SET returned_at = NOW()
 		WHERE user_id = $1
 		  AND book_id = $2
 		  AND returned_at IS NULL
-	`, userID, bookID)
+		RETURNING id, copy_id
+	`, userID, bookID).Scan(&borrowID, &copyID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
 		return err
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return ErrRecordNotFound
-	}
-
-	_, err = tx.ExecContext(ctx, `
-		UPDATE books
-		SET copies_available = copies_available + 1,
-		    version = version + 1
-		WHERE id = $1
-	`, bookID)
+	// Settle the loan's fine now rather than waiting for the next accrual run,
+	// which only looks at loans that are still out.
+	_, err = accrueFines(ctx, tx, "br.id = $1", borrowID)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
-}
-
-func (m BookModel) Search(q, category, availability, sort string) ([]*Book, error) {
-	sqlStr := `
-        SELECT id, title, author, publish_date, isbn, description, cover_image, genres, pages,
-               language, publisher, copies_total, copies_available, version
-        FROM books
-        WHERE (title ILIKE $1 OR author ILIKE $1 OR isbn ILIKE $1)
-    `
-	args := []any{"%" + q + "%"}
-
-	if category != "" && category != "All Categories" {
-		sqlStr += " AND $2 = ANY(genres)"
-		args = append(args, category)
-	}
-
-	if availability != "" {
-		switch availability {
-		case "Available":
-			sqlStr += " AND copies_available > 0"
-		case "Borrowed":
-			sqlStr += " AND copies_available = 0"
+	if copyID != nil {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE copies
+			SET status = 'available', version = version + 1
+			WHERE id = $1 AND status = 'on_loan'
+		`, *copyID)
+		if err != nil {
+			return err
 		}
 	}
 
-	switch sort {
-	case "Title (A-Z)":
-		sqlStr += " ORDER BY title ASC"
-	case "Title (Z-A)":
-		sqlStr += " ORDER BY title DESC"
-	case "Author (A-Z)":
-		sqlStr += " ORDER BY author ASC"
-	case "Newest First":
-		sqlStr += " ORDER BY publish_date DESC"
-	default:
-		sqlStr += " ORDER BY title ASC"
-	}
-
-	rows, err := m.DB.Query(sqlStr, args...)
+	err = refreshHolds(ctx, tx, bookID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var books []*Book
-	for rows.Next() {
-		var b Book
-		var genres []string
-		if err := rows.Scan(
-			&b.ID, &b.Title, &b.Author, &b.PublishDate, &b.ISBN, &b.Description,
-			&b.CoverImage, pq.Array(&genres), &b.Pages, &b.Language, &b.Publisher,
-			&b.CopiesTotal, &b.CopiesAvailable, &b.Version,
-		); err != nil {
-			return nil, err
-		}
-		b.Genres = genres
-		books = append(books, &b)
-	}
-	return books, nil
+	return tx.Commit()
 }
 
 func (m BookModel) Count() (int, error) {
-	query := `SELECT COUNT(*) FROM books`
+	query := `SELECT COUNT(*) FROM books WHERE deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -304,8 +517,10 @@ func (m BookModel) Count() (int, error) {
 
 func (m BookModel) GetAll() ([]*Book, error) {
 	query := `
-		SELECT id, title, author, publish_date, isbn, description, cover_image, genres, pages, language, publisher, copies_total, copies_available, version
+		SELECT id, work_id, title, author, publish_date, isbn, description, cover_image, genres, pages, language, publisher, item_type, copies_total, copies_available, version
 		FROM books
+		INNER JOIN book_copy_counts cc ON cc.book_id = books.id
+		WHERE deleted_at IS NULL
 		ORDER BY title ASC`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -322,8 +537,8 @@ func (m BookModel) GetAll() ([]*Book, error) {
 		var b Book
 		var genres []string
 		if err := rows.Scan(
-			&b.ID, &b.Title, &b.Author, &b.PublishDate, &b.ISBN, &b.Description,
-			&b.CoverImage, pq.Array(&genres), &b.Pages, &b.Language, &b.Publisher,
+			&b.ID, &b.WorkID, &b.Title, &b.Author, &b.PublishDate, &b.ISBN, &b.Description,
+			&b.CoverImage, pq.Array(&genres), &b.Pages, &b.Language, &b.Publisher, &b.ItemType,
 			&b.CopiesTotal, &b.CopiesAvailable, &b.Version,
 		); err != nil {
 			return nil, err
@@ -336,21 +551,61 @@ func (m BookModel) GetAll() ([]*Book, error) {
 		return nil, err
 	}
 
+	err = loadContributors(ctx, m.DB, books...)
+	if err != nil {
+		return nil, err
+	}
+
 	return books, nil
 }
 
-func (m BookModel) Insert(book *Book) error {
+// Insert adds the book along with book.CopiesTotal copies, which are given
+// generated barcodes and start out available. The book is added as an edition
+// of book.WorkID, or as the first edition of a new work when that is zero.
+func (m BookModel) Insert(book *Book, ev *AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertBook(ctx, tx, book)
+	if err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, ev, int64(book.ID))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertBook(ctx context.Context, tx *sql.Tx, book *Book) error {
 	query := `
-		INSERT INTO books (title, author, publish_date, isbn, description, cover_image, genres, pages, language, publisher, copies_total, copies_available)
+		INSERT INTO books (title, author, publish_date, isbn, description, cover_image, genres, pages, language, publisher, work_id, item_type)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 		RETURNING id, version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	book.ISBN = canonicalISBN(book.ISBN)
+	if book.ItemType == "" {
+		book.ItemType = DefaultItemType
+	}
+
+	if book.WorkID == 0 {
+		err := tx.QueryRowContext(ctx, `INSERT INTO works DEFAULT VALUES RETURNING id`).Scan(&book.WorkID)
+		if err != nil {
+			return err
+		}
+	}
 
 	args := []any{
 		book.Title,
-		book.Author,
+		authorNames(book.Contributors),
 		book.PublishDate,
 		book.ISBN,
 		book.Description,
@@ -359,35 +614,77 @@ func (m BookModel) Insert(book *Book) error {
 		book.Pages,
 		book.Language,
 		book.Publisher,
-		book.CopiesTotal,
-		book.CopiesAvailable,
+		book.WorkID,
+		book.ItemType,
 	}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.Version)
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.Version)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
 			return ErrDuplicateISBN
 		}
 		return err
 	}
+
+	err = setContributors(ctx, tx, int64(book.ID), book.Contributors)
+	if err != nil {
+		return err
+	}
+	book.Author = authorNames(book.Contributors)
+
+	err = addCopies(ctx, tx, int64(book.ID), book.CopiesTotal)
+	if err != nil {
+		return err
+	}
+	book.CopiesAvailable = book.CopiesTotal
+
 	return nil
 }
 
-func (m BookModel) Update(book *Book) error {
+// Update saves the book. A non-zero book.WorkID moves it to that work, taking
+// its holds along; the work it leaves is removed if it has no editions left.
+func (m BookModel) Update(book *Book, ev *AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateBook(ctx, tx, book)
+	if err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, ev, int64(book.ID))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func updateBook(ctx context.Context, tx *sql.Tx, book *Book) error {
 	query := `
 		UPDATE books
 		SET title = $1, author = $2, publish_date = $3, isbn = $4, description = $5, 
 		    cover_image = $6, genres = $7, pages = $8, language = $9, publisher = $10, 
-		    copies_total = $11, copies_available = $12, version = version + 1
-		WHERE id = $13
-		RETURNING version`
+		    work_id = COALESCE(NULLIF($12::bigint, 0), books.work_id), item_type = $13,
+		    version = version + 1
+		FROM (SELECT work_id AS previous_work_id FROM books WHERE id = $11) previous
+		WHERE books.id = $11 AND books.deleted_at IS NULL
+		RETURNING books.version, books.work_id, previous.previous_work_id`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	book.ISBN = canonicalISBN(book.ISBN)
+	if book.ItemType == "" {
+		book.ItemType = DefaultItemType
+	}
 
 	args := []any{
 		book.Title,
-		book.Author,
+		authorNames(book.Contributors),
 		book.PublishDate,
 		book.ISBN,
 		book.Description,
@@ -396,12 +693,13 @@ func (m BookModel) Update(book *Book) error {
 		book.Pages,
 		book.Language,
 		book.Publisher,
-		book.CopiesTotal,
-		book.CopiesAvailable,
 		book.ID,
+		book.WorkID,
+		book.ItemType,
 	}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&book.Version)
+	var previousWorkID int64
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&book.Version, &book.WorkID, &previousWorkID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -413,32 +711,255 @@ func (m BookModel) Update(book *Book) error {
 			return err
 		}
 	}
+
+	err = setContributors(ctx, tx, int64(book.ID), book.Contributors)
+	if err != nil {
+		return err
+	}
+	book.Author = authorNames(book.Contributors)
+
+	if previousWorkID != book.WorkID {
+		return pruneWork(ctx, tx, previousWorkID)
+	}
 	return nil
 }
 
-func (m BookModel) Delete(id int) error {
+// Delete moves the book to the trash. It leaves the catalogue and its active
+// holds are cancelled, but its loans and their history are kept until the
+// book is purged. Copies still out on loan can be returned as usual.
+func (m BookModel) Delete(id int, ev *AuditEvent) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `DELETE FROM books WHERE id = $1`
-
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	_, err = lockBook(ctx, tx, int64(id))
 	if err != nil {
 		return err
 	}
 
+	result, err := tx.ExecContext(ctx, `
+		UPDATE books
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	// Holds on other editions that were filled with one of its copies go too,
+	// since the copy is leaving the shelf with it.
+	err = cancelActiveHolds(ctx, tx, "book_id = $1 OR copy_id IN (SELECT id FROM copies WHERE book_id = $1)", id)
+	if err != nil {
+		return err
+	}
+
+	// Copies of this edition no longer count towards the work's queue, which
+	// may leave holds on other editions ready with nothing to pick up.
+	err = refreshHolds(ctx, tx, int64(id))
+	if err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, ev, int64(id))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// pruneWork removes the work once its last edition is gone.
+func pruneWork(ctx context.Context, tx *sql.Tx, workID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM works w
+		WHERE w.id = $1 AND NOT EXISTS (SELECT 1 FROM books b WHERE b.work_id = w.id)
+	`, workID)
+	return err
+}
+
+// canonicalISBN returns the ISBN-13 form books are stored under. Anything
+// that isn't a valid ISBN is returned as it is, so it still fails to match.
+func canonicalISBN(number string) string {
+	canonical, err := isbn.To13(number)
+	if err != nil {
+		return number
+	}
+	return canonical
+}
+
+// ISBNChange is the outcome of normalizing one book's ISBN. Problem says why
+// a book was left as it is.
+type ISBNChange struct {
+	BookID  int64
+	From    string
+	To      string
+	Problem string
+}
+
+// NormalizeISBNs rewrites every stored ISBN in canonical ISBN-13 form and
+// returns the books that changed or couldn't be changed. Invalid ISBNs, and
+// ISBNs that are the same number as another book's, are reported rather than
+// rewritten. With dryRun nothing is saved.
+func (m BookModel) NormalizeISBNs(dryRun bool) ([]ISBNChange, error) {
+	// This walks the whole catalogue, so it gets far longer than the usual
+	// query timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, isbn FROM books ORDER BY id FOR UPDATE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []ISBNChange
+	for rows.Next() {
+		var c ISBNChange
+		if err := rows.Scan(&c.BookID, &c.From); err != nil {
+			return nil, err
+		}
+		books = append(books, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// A book already stored in canonical form keeps its number; otherwise the
+	// oldest book with the number does.
+	owners := make(map[string]int64)
+	for i := range books {
+		c := &books[i]
+		c.To, err = isbn.To13(c.From)
+		if err != nil {
+			c.Problem = "not a valid ISBN-10 or ISBN-13"
+			continue
+		}
+		if c.To == c.From {
+			owners[c.To] = c.BookID
+		}
+	}
+
+	var changes []ISBNChange
+	for i := range books {
+		c := &books[i]
+		if c.Problem == "" && c.To != c.From {
+			owner, taken := owners[c.To]
+			if taken {
+				c.Problem = fmt.Sprintf("same ISBN as book %d", owner)
+			} else {
+				owners[c.To] = c.BookID
+			}
+		}
+
+		if c.Problem == "" && c.To == c.From {
+			continue
+		}
+		changes = append(changes, *c)
+
+		if c.Problem != "" || dryRun {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE books SET isbn = $1, version = version + 1 WHERE id = $2`, c.To, c.BookID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+	return changes, tx.Commit()
+}
+
+// RemoteCovers returns the cover URLs of the books whose covers are still
+// hotlinked from another site, keyed by book ID. Books in the trash are left
+// out.
+func (m BookModel) RemoteCovers() (map[int]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT id, cover_image FROM books
+		WHERE (cover_image LIKE 'http://%' OR cover_image LIKE 'https://%')
+		  AND deleted_at IS NULL
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	covers := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			return nil, err
+		}
+		covers[id] = url
+	}
+	return covers, rows.Err()
+}
+
+// SetCoverImage replaces a book's cover URL, returning ErrRecordNotFound if
+// the book is in the trash. The event, if any, records the old and new URLs.
+func (m BookModel) SetCoverImage(id int, coverURL string, ev *AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRowContext(ctx, `SELECT cover_image FROM books WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).
+		Scan(&previous)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE books SET cover_image = $1, version = version + 1
+		 WHERE id = $2 AND deleted_at IS NULL`, coverURL, id)
+	if err != nil {
+		return err
+	}
+
+	if ev != nil {
+		ev.Before = map[string]string{"cover_image": previous}
+		ev.After = map[string]string{"cover_image": coverURL}
+	}
+	err = insertAudit(ctx, tx, ev, int64(id))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -6,15 +6,19 @@ import (
 )
 
 type BorrowedBook struct {
-	BorrowID   int64
-	BorrowedAt time.Time
-	ReturnedAt *time.Time
-	DueAt      time.Time
+	BorrowID   int64      `json:"borrow_id,omitempty"`
+	BorrowedAt time.Time  `json:"borrowed_at"`
+	ReturnedAt *time.Time `json:"returned_at,omitempty"`
+	DueAt      time.Time  `json:"due_at"`
+	Renewals   int        `json:"renewals"`
+	// MaxRenewals is the renewal limit of the loan's circulation policy.
+	MaxRenewals int    `json:"max_renewals,omitempty"`
+	Barcode     string `json:"barcode,omitempty"`
 
-	BookID     int
-	Title      string
-	Author     string
-	CoverImage string
+	BookID     int    `json:"book_id"`
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	CoverImage string `json:"cover_image"`
 }
 
 type BorrowRecordModel struct {
@@ -23,9 +27,12 @@ type BorrowRecordModel struct {
 
 func (m BorrowRecordModel) GetCurrentBorrows(userID int64) ([]*BorrowedBook, error) {
 	query := `
-		SELECT b.id, b.title, b.author, b.cover_image, br.borrowed_at, br.due_at
+		SELECT b.id, b.title, b.author, b.cover_image, br.borrowed_at, br.due_at, br.renewal_count,
+		       COALESCE(c.barcode, ''),
+		       COALESCE((SELECT max_renewals FROM circulation_policy_for(br.user_id, br.book_id)), 0)
 		FROM borrow_records br
 		INNER JOIN books b ON br.book_id = b.id
+		LEFT JOIN copies c ON br.copy_id = c.id
 		WHERE br.user_id = $1 AND br.returned_at IS NULL
 		ORDER BY br.borrowed_at DESC
 	`
@@ -38,7 +45,7 @@ func (m BorrowRecordModel) GetCurrentBorrows(userID int64) ([]*BorrowedBook, err
 	var borrows []*BorrowedBook
 	for rows.Next() {
 		var bb BorrowedBook
-		if err := rows.Scan(&bb.BookID, &bb.Title, &bb.Author, &bb.CoverImage, &bb.BorrowedAt, &bb.DueAt); err != nil {
+		if err := rows.Scan(&bb.BookID, &bb.Title, &bb.Author, &bb.CoverImage, &bb.BorrowedAt, &bb.DueAt, &bb.Renewals, &bb.Barcode, &bb.MaxRenewals); err != nil {
 			return nil, err
 		}
 		borrows = append(borrows, &bb)
@@ -48,7 +55,7 @@ func (m BorrowRecordModel) GetCurrentBorrows(userID int64) ([]*BorrowedBook, err
 
 func (m BorrowRecordModel) GetBorrowHistory(userID int64) ([]*BorrowedBook, error) {
 	query := `
-		SELECT b.id, b.title, b.author, b.cover_image, br.borrowed_at, br.due_at, br.returned_at
+		SELECT b.id, b.title, b.author, b.cover_image, br.borrowed_at, br.due_at, br.returned_at, br.renewal_count
 		FROM borrow_records br
 		INNER JOIN books b ON br.book_id = b.id
 		WHERE br.user_id = $1 AND br.returned_at IS NOT NULL
@@ -63,7 +70,7 @@ func (m BorrowRecordModel) GetBorrowHistory(userID int64) ([]*BorrowedBook, erro
 	var history []*BorrowedBook
 	for rows.Next() {
 		var bb BorrowedBook
-		if err := rows.Scan(&bb.BookID, &bb.Title, &bb.Author, &bb.CoverImage, &bb.BorrowedAt, &bb.DueAt, &bb.ReturnedAt); err != nil {
+		if err := rows.Scan(&bb.BookID, &bb.Title, &bb.Author, &bb.CoverImage, &bb.BorrowedAt, &bb.DueAt, &bb.ReturnedAt, &bb.Renewals); err != nil {
 			return nil, err
 		}
 		history = append(history, &bb)
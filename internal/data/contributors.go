@@ -0,0 +1,261 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ContributorAuthor      = "author"
+	ContributorEditor      = "editor"
+	ContributorTranslator  = "translator"
+	ContributorIllustrator = "illustrator"
+)
+
+var ContributorRoles = []string{
+	ContributorAuthor,
+	ContributorEditor,
+	ContributorTranslator,
+	ContributorIllustrator,
+}
+
+var contributorCredits = map[string]string{
+	ContributorAuthor:      "Written by",
+	ContributorEditor:      "Edited by",
+	ContributorTranslator:  "Translated by",
+	ContributorIllustrator: "Illustrated by",
+}
+
+// Contributor is a person credited on one or more books. Names that differ
+// only in case, spacing or punctuation are the same contributor.
+type Contributor struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int       `json:"version"`
+}
+
+// BookContributor credits a contributor on a book in one role.
+type BookContributor struct {
+	ContributorID int64  `json:"contributor_id"`
+	Name          string `json:"name"`
+	Role          string `json:"role"`
+}
+
+// Credit describes the role for a book page, e.g. "Translated by".
+func (c BookContributor) Credit() string {
+	return contributorCredits[c.Role]
+}
+
+// ContributedBook is a book on a contributor's page along with the roles they
+// had in it.
+type ContributedBook struct {
+	*Book
+	Roles []string
+}
+
+// authorNames joins the names of the authors among the contributors the same
+// way the database fills in books.author.
+func authorNames(contributors []BookContributor) string {
+	var names []string
+	for _, c := range contributors {
+		if c.Role == ContributorAuthor {
+			names = append(names, c.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// ParseContributors reads a list of contributors such as
+// "Ursula K. Le Guin; Jane Doe (translator)". Entries are separated by
+// semicolons and are authors unless a role is given in brackets.
+func ParseContributors(s string) []BookContributor {
+	contributors := []BookContributor{}
+	for _, part := range strings.Split(s, ";") {
+		name := strings.TrimSpace(part)
+		if name == "" {
+			continue
+		}
+
+		role := ContributorAuthor
+		if open := strings.LastIndex(name, "("); open > 0 && strings.HasSuffix(name, ")") {
+			role = strings.ToLower(strings.TrimSpace(name[open+1 : len(name)-1]))
+			name = strings.TrimSpace(name[:open])
+		}
+
+		contributors = append(contributors, BookContributor{Name: name, Role: role})
+	}
+	return contributors
+}
+
+// FormatContributors writes contributors in the form ParseContributors
+// reads.
+func FormatContributors(contributors []BookContributor) string {
+	parts := make([]string, len(contributors))
+	for i, c := range contributors {
+		parts[i] = c.Name
+		if c.Role != ContributorAuthor {
+			parts[i] += " (" + c.Role + ")"
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+type ContributorModel struct {
+	DB *sql.DB
+}
+
+func (m ContributorModel) Get(id int64) (*Contributor, error) {
+	query := `SELECT id, name, created_at, version FROM contributors WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var c Contributor
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&c.ID, &c.Name, &c.CreatedAt, &c.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetBooks lists the books the contributor is credited on, newest first.
+func (m ContributorModel) GetBooks(id int64) ([]*ContributedBook, error) {
+	query := `
+		SELECT books.id, books.title, books.author, books.publish_date, books.isbn,
+		       books.cover_image, books.genres, cc.copies_total, cc.copies_available,
+		       array_agg(bc.role ORDER BY bc.role)
+		FROM book_contributors bc
+		INNER JOIN books ON books.id = bc.book_id
+		INNER JOIN book_copy_counts cc ON cc.book_id = books.id
+		WHERE bc.contributor_id = $1 AND books.deleted_at IS NULL
+		GROUP BY books.id, cc.copies_total, cc.copies_available
+		ORDER BY books.publish_date DESC, books.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []*ContributedBook
+	for rows.Next() {
+		var b Book
+		var genres, roles []string
+		if err := rows.Scan(
+			&b.ID, &b.Title, &b.Author, &b.PublishDate, &b.ISBN, &b.CoverImage,
+			pq.Array(&genres), &b.CopiesTotal, &b.CopiesAvailable, pq.Array(&roles),
+		); err != nil {
+			return nil, err
+		}
+		b.Genres = genres
+		books = append(books, &ContributedBook{Book: &b, Roles: roles})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// loadContributors fills in the Contributors of each book, in credit order.
+func loadContributors(
+	ctx context.Context,
+	q interface {
+		QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	},
+	books ...*Book,
+) error {
+	ids := make([]int64, len(books))
+	byID := make(map[int64]*Book, len(books))
+	for i, b := range books {
+		ids[i] = int64(b.ID)
+		byID[int64(b.ID)] = b
+		b.Contributors = []BookContributor{}
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT bc.book_id, c.id, c.name, bc.role
+		FROM book_contributors bc
+		INNER JOIN contributors c ON c.id = bc.contributor_id
+		WHERE bc.book_id = ANY($1)
+		ORDER BY bc.book_id, bc.position, c.name
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var c BookContributor
+		if err := rows.Scan(&bookID, &c.ContributorID, &c.Name, &c.Role); err != nil {
+			return err
+		}
+		b := byID[bookID]
+		b.Contributors = append(b.Contributors, c)
+	}
+
+	return rows.Err()
+}
+
+// setContributors replaces the book's credits. Each contributor is matched
+// to an existing one by name, taking that spelling, or created. Contributors
+// left with no books are removed.
+func setContributors(ctx context.Context, tx *sql.Tx, bookID int64, contributors []BookContributor) error {
+	var previous []int64
+	err := tx.QueryRowContext(ctx, `
+		WITH removed AS (
+			DELETE FROM book_contributors WHERE book_id = $1 RETURNING contributor_id
+		)
+		SELECT COALESCE(array_agg(contributor_id), '{}') FROM removed
+	`, bookID).Scan(pq.Array(&previous))
+	if err != nil {
+		return err
+	}
+
+	for i := range contributors {
+		c := &contributors[i]
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO contributors (name) VALUES ($1)
+			ON CONFLICT (name_key) DO UPDATE SET name = contributors.name
+			RETURNING id, name
+		`, c.Name).Scan(&c.ContributorID, &c.Name)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO book_contributors (book_id, contributor_id, role, position)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+		`, bookID, c.ContributorID, c.Role, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	return pruneContributors(ctx, tx, previous)
+}
+
+func pruneContributors(ctx context.Context, tx *sql.Tx, ids []int64) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM contributors c
+		WHERE c.id = ANY($1)
+		  AND NOT EXISTS (SELECT 1 FROM book_contributors bc WHERE bc.contributor_id = c.id)
+	`, pq.Array(ids))
+	return err
+}
@@ -0,0 +1,345 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+var (
+	ErrDuplicateBarcode  = errors.New("duplicate barcode")
+	ErrCopyStatusChanged = errors.New("copy status changed")
+)
+
+const (
+	CopyAvailable = "available"
+	CopyOnHold    = "on_hold"
+	CopyOnLoan    = "on_loan"
+	CopyLost      = "lost"
+	CopyDamaged   = "damaged"
+	CopyWithdrawn = "withdrawn"
+)
+
+var (
+	CopyConditions = []string{"new", "good", "fair", "poor"}
+
+	// CopyStatuses are the statuses an admin can put a copy in by hand;
+	// on_hold and on_loan are only set by circulation.
+	CopyStatuses = []string{CopyAvailable, CopyLost, CopyDamaged, CopyWithdrawn}
+)
+
+// barcodeSQL generates the next barcode for a book, e.g. LMS-000042-003.
+const barcodeSQL = `'LMS-' || lpad($1::bigint::text, 6, '0') || '-' || lpad((
+	(SELECT COUNT(*) FROM copies WHERE book_id = $1::bigint) + n)::text, 3, '0')`
+
+type Copy struct {
+	ID         int64     `json:"id"`
+	BookID     int64     `json:"book_id"`
+	Barcode    string    `json:"barcode"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	Condition  string    `json:"condition"`
+	Status     string    `json:"status"`
+	Notes      string    `json:"notes"`
+	CreatedAt  time.Time `json:"created_at"`
+	Version    int       `json:"version"`
+
+	Borrower string     `json:"borrower,omitempty"`
+	DueAt    *time.Time `json:"due_at,omitempty"`
+}
+
+// ValidateCopy checks a copy about to be saved. previous is the copy's status
+// before the change, or empty for a new copy.
+func ValidateCopy(v *validator.Validator, c *Copy, previous string) {
+	if c.ID != 0 {
+		v.Check(validator.NotBlank(c.Barcode), "barcode", "Barcode is required")
+	}
+	v.Check(len(c.Barcode) <= 50, "barcode", "Barcode must not exceed 50 characters")
+
+	v.Check(
+		validator.PermittedValue(c.Condition, CopyConditions...),
+		"condition",
+		"Condition must be new, good, fair or poor",
+	)
+
+	if c.Status != previous {
+		v.Check(
+			validator.PermittedValue(c.Status, CopyStatuses...),
+			"status",
+			"Status must be available, lost, damaged or withdrawn",
+		)
+		v.Check(
+			previous != CopyOnLoan || c.Status == CopyLost,
+			"status",
+			"A copy on loan can only be marked lost",
+		)
+	}
+
+	v.Check(!c.AcquiredAt.After(time.Now()), "acquired_at", "Acquisition date cannot be in the future")
+	v.Check(len(c.Notes) <= 1000, "notes", "Notes must not exceed 1000 characters")
+}
+
+// copyStatusAllowed reports whether an admin may move a copy from previous
+// to next: by hand only to one of CopyStatuses, and a copy on loan only to
+// lost.
+func copyStatusAllowed(previous, next string) bool {
+	if next == previous {
+		return true
+	}
+	return slices.Contains(CopyStatuses, next) && (previous != CopyOnLoan || next == CopyLost)
+}
+
+type CopyModel struct {
+	DB *sql.DB
+}
+
+const copyColumns = `
+	c.id, c.book_id, c.barcode, c.acquired_at, c.condition, c.status, c.notes, c.created_at,
+	c.version, COALESCE(u.name, ''), br.due_at`
+
+const copyJoins = `
+	LEFT JOIN borrow_records br ON br.copy_id = c.id AND br.returned_at IS NULL
+	LEFT JOIN users u ON br.user_id = u.id`
+
+func scanCopy(row interface{ Scan(...any) error }) (*Copy, error) {
+	var c Copy
+	err := row.Scan(
+		&c.ID, &c.BookID, &c.Barcode, &c.AcquiredAt, &c.Condition, &c.Status, &c.Notes,
+		&c.CreatedAt, &c.Version, &c.Borrower, &c.DueAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (m CopyModel) GetAllForBook(bookID int64) ([]*Copy, error) {
+	query := `
+		SELECT ` + copyColumns + `
+		FROM copies c` + copyJoins + `
+		WHERE c.book_id = $1
+		ORDER BY c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var copies []*Copy
+	for rows.Next() {
+		c, err := scanCopy(rows)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return copies, nil
+}
+
+func (m CopyModel) Get(id int64) (*Copy, error) {
+	query := `
+		SELECT ` + copyColumns + `
+		FROM copies c` + copyJoins + `
+		WHERE c.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	c, err := scanCopy(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// Insert adds a copy to its book, generating a barcode when none is given.
+// A new copy on the shelf goes straight to the hold queue if anyone is
+// waiting.
+func (m CopyModel) Insert(c *Copy, ev *AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockBook(ctx, tx, c.BookID)
+	if err != nil {
+		return err
+	}
+
+	if c.Barcode == "" {
+		err = tx.QueryRowContext(ctx, `SELECT `+barcodeSQL+` FROM (SELECT 1 AS n) s`, c.BookID).
+			Scan(&c.Barcode)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO copies (book_id, barcode, acquired_at, condition, status, notes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, version`
+
+	args := []any{c.BookID, c.Barcode, c.AcquiredAt, c.Condition, c.Status, c.Notes}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&c.ID, &c.CreatedAt, &c.Version)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicateBarcode
+		}
+		return err
+	}
+
+	err = refreshHolds(ctx, tx, c.BookID)
+	if err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, ev, c.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update saves changes to a copy. Marking a copy on loan as lost closes the
+// loan, and taking a copy that was set aside for a hold off the shelf puts the
+// hold back at the front of the queue. It returns ErrCopyStatusChanged if
+// circulation has since moved the copy to a status the change isn't allowed
+// from.
+func (m CopyModel) Update(c *Copy, ev *AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockBook(ctx, tx, c.BookID)
+	if err != nil {
+		return err
+	}
+
+	var previous string
+	err = tx.QueryRowContext(ctx,
+		`SELECT status FROM copies WHERE id = $1 FOR UPDATE`,
+		c.ID,
+	).Scan(&previous)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	// The copy may have been borrowed, returned or set aside since the admin
+	// loaded it, so check the change against the status it has now.
+	if !copyStatusAllowed(previous, c.Status) {
+		return ErrCopyStatusChanged
+	}
+
+	if previous == CopyOnLoan && c.Status != CopyOnLoan {
+		var borrowID int64
+		err = tx.QueryRowContext(ctx, `
+			UPDATE borrow_records
+			SET returned_at = NOW()
+			WHERE copy_id = $1 AND returned_at IS NULL
+			RETURNING id
+		`, c.ID).Scan(&borrowID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil {
+			_, err = accrueFines(ctx, tx, "br.id = $1", borrowID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if previous == CopyOnHold && c.Status != CopyOnHold {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE holds
+			SET status = 'waiting', copy_id = NULL, ready_at = NULL, expires_at = NULL
+			WHERE copy_id = $1 AND status = 'ready'
+		`, c.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE copies
+		SET barcode = $1, acquired_at = $2, condition = $3, status = $4, notes = $5,
+		    version = version + 1
+		WHERE id = $6
+		RETURNING version`
+
+	args := []any{c.Barcode, c.AcquiredAt, c.Condition, c.Status, c.Notes, c.ID}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&c.Version)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicateBarcode
+		}
+		return err
+	}
+
+	err = refreshHolds(ctx, tx, c.BookID)
+	if err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, ev, c.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addCopies gives a new book n available copies with generated barcodes.
+func addCopies(ctx context.Context, tx *sql.Tx, bookID int64, n int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO copies (book_id, barcode)
+		SELECT $1::bigint, `+barcodeSQL+`
+		FROM generate_series(1, $2) AS n
+	`, bookID, n)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicateBarcode
+		}
+	}
+	return err
+}
+
+func setCopyStatus(ctx context.Context, tx *sql.Tx, copyID int64, status string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE copies SET status = $2, version = version + 1 WHERE id = $1`,
+		copyID, status,
+	)
+	return err
+}
@@ -0,0 +1,28 @@
+package data
+
+import "testing"
+
+func TestCopyStatusAllowed(t *testing.T) {
+	tests := []struct {
+		previous, next string
+		want           bool
+	}{
+		{CopyAvailable, CopyAvailable, true},
+		{CopyAvailable, CopyDamaged, true},
+		{CopyOnHold, CopyWithdrawn, true},
+		{CopyLost, CopyAvailable, true},
+		{CopyOnLoan, CopyOnLoan, true},
+		{CopyOnLoan, CopyLost, true},
+		{CopyOnLoan, CopyDamaged, false},
+		{CopyOnLoan, CopyWithdrawn, false},
+		{CopyOnLoan, CopyAvailable, false},
+		{CopyAvailable, CopyOnLoan, false},
+		{CopyAvailable, CopyOnHold, false},
+	}
+
+	for _, tt := range tests {
+		if got := copyStatusAllowed(tt.previous, tt.next); got != tt.want {
+			t.Errorf("copyStatusAllowed(%q, %q) = %v, want %v", tt.previous, tt.next, got, tt.want)
+		}
+	}
+}
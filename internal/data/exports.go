@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+// ExportTimeout bounds an export, which streams a whole table to a client
+// that may be slow to read it. The handlers streaming exports give their
+// responses as long to be written.
+const ExportTimeout = 10 * time.Minute
+
+// BorrowRecord is one loan as it appears in a circulation export.
+type BorrowRecord struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	UserName   string     `json:"user_name"`
+	UserEmail  string     `json:"user_email"`
+	BookID     int64      `json:"book_id"`
+	Title      string     `json:"title"`
+	ISBN       string     `json:"isbn"`
+	Barcode    string     `json:"barcode,omitempty"`
+	BorrowedAt time.Time  `json:"borrowed_at"`
+	DueAt      time.Time  `json:"due_at"`
+	ReturnedAt *time.Time `json:"returned_at,omitempty"`
+	Renewals   int        `json:"renewals"`
+}
+
+// DateRange limits a listing to records made on or between two days, such
+// as loans in a circulation export. Either end may be left open.
+type DateRange struct {
+	From *time.Time
+	To   *time.Time
+}
+
+func ValidateDateRange(v *validator.Validator, r DateRange) {
+	if r.From != nil && r.To != nil {
+		v.Check(!r.To.Before(*r.From), "to", "End date must not be before the start date")
+	}
+}
+
+// contributorsJSONSQL aggregates the contributors of the book with the id
+// in the given column into JSON, in credit order.
+func contributorsJSONSQL(idColumn string) string {
+	return `COALESCE((
+		SELECT json_agg(json_build_object(
+			'contributor_id', c.id, 'name', c.name, 'role', bc.role
+		) ORDER BY bc.position, c.name)
+		FROM book_contributors bc
+		INNER JOIN contributors c ON c.id = bc.contributor_id
+		WHERE bc.book_id = ` + idColumn + `
+	), '[]')`
+}
+
+// exportBooks calls fn with each book in rows, which hold the book columns
+// followed by contributorsJSONSQL.
+func exportBooks(rows *sql.Rows, fn func(*Book) error) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Book
+		var genres []string
+		var contributors []byte
+		if err := rows.Scan(
+			&b.ID, &b.WorkID, &b.Title, &b.Author, &b.PublishDate, &b.ISBN, &b.Description,
+			&b.CoverImage, pq.Array(&genres), &b.Pages, &b.Language, &b.Publisher, &b.ItemType,
+			&b.CopiesTotal, &b.CopiesAvailable, &b.Version, &contributors,
+		); err != nil {
+			return err
+		}
+		b.Genres = genres
+
+		if err := json.Unmarshal(contributors, &b.Contributors); err != nil {
+			return err
+		}
+
+		if err := fn(&b); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Export calls fn with every book in the catalogue, in id order, as the rows
+// are read rather than loading the whole table first. It stops at the first
+// error from fn.
+func (m BookModel) Export(fn func(*Book) error) error {
+	query := `
+		SELECT books.id, books.work_id, title, author, publish_date, isbn, description, cover_image,
+		       genres, pages, language, publisher, item_type, cc.copies_total, cc.copies_available, version,
+		       ` + contributorsJSONSQL("books.id") + `
+		FROM books
+		INNER JOIN book_copy_counts cc ON cc.book_id = books.id
+		WHERE books.deleted_at IS NULL
+		ORDER BY books.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	return exportBooks(rows, fn)
+}
+
+// Export calls fn with every member, in id order, as the rows are read.
+// Password hashes are never selected.
+func (m UserModel) Export(fn func(*User) error) error {
+	query := `
+		SELECT id, created_at, name, email, activated, role, tier
+		FROM users
+		WHERE deleted_at IS NULL
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.CreatedAt, &u.Name, &u.Email, &u.Activated, &u.Role, &u.Tier); err != nil {
+			return err
+		}
+
+		if err := fn(&u); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Export calls fn with every loan made within the date range, oldest first,
+// as the rows are read.
+func (m BorrowRecordModel) Export(r DateRange, fn func(*BorrowRecord) error) error {
+	query := `
+		SELECT br.id, br.user_id, u.name, u.email, br.book_id, b.title, b.isbn,
+		       COALESCE(c.barcode, ''), br.borrowed_at, br.due_at, br.returned_at, br.renewal_count
+		FROM borrow_records br
+		INNER JOIN users u ON u.id = br.user_id
+		INNER JOIN books b ON b.id = br.book_id
+		LEFT JOIN copies c ON c.id = br.copy_id
+		WHERE ($1::date IS NULL OR br.borrowed_at >= $1::date)
+		  AND ($2::date IS NULL OR br.borrowed_at < $2::date + 1)
+		ORDER BY br.borrowed_at, br.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, r.From, r.To)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var br BorrowRecord
+		if err := rows.Scan(
+			&br.ID, &br.UserID, &br.UserName, &br.UserEmail, &br.BookID, &br.Title, &br.ISBN,
+			&br.Barcode, &br.BorrowedAt, &br.DueAt, &br.ReturnedAt, &br.Renewals,
+		); err != nil {
+			return err
+		}
+
+		if err := fn(&br); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+const (
+	SettingFineDailyCents = "fine_daily_cents"
+	SettingFineGraceDays  = "fine_grace_days"
+	SettingFineMaxCents   = "fine_max_cents"
+	SettingFineBlockCents = "fine_block_cents"
+)
+
+const (
+	FineCharge  = "charge"
+	FinePayment = "payment"
+	FineWaiver  = "waiver"
+)
+
+// fineAccrualLockKey serialises accrual runs so the background job and a
+// return happening at the same time can't both charge the same delta.
+const fineAccrualLockKey = 7_001
+
+// accrueFinesSQL charges each overdue loan matching the filter the difference
+// between what it owes under the current policy and what it has already been
+// charged. A loan owes the daily rate for every full day late beyond the grace
+// period, up to the per-item cap, on top of its baseline: what it was charged
+// for the periods before it was last renewed. Waivers and payments don't
+// reduce what is owed, so waived fines are not charged again.
+const accrueFinesSQL = `
+	WITH policy AS (
+		SELECT
+			COALESCE((SELECT value::int FROM settings WHERE key = 'fine_daily_cents'), 25) AS rate,
+			COALESCE((SELECT value::int FROM settings WHERE key = 'fine_grace_days'), 2) AS grace,
+			COALESCE((SELECT value::int FROM settings WHERE key = 'fine_max_cents'), 1000) AS cap
+	),
+	owed AS (
+		SELECT br.id AS borrow_id, br.user_id,
+		       br.fines_baseline_cents + LEAST(p.cap, p.rate * GREATEST(0,
+		           floor(extract(epoch FROM COALESCE(br.returned_at, NOW()) - br.due_at) / 86400)::int
+		           - p.grace)) AS amount
+		FROM borrow_records br
+		CROSS JOIN policy p
+		WHERE br.due_at < COALESCE(br.returned_at, NOW()) AND %s
+	),
+	charged AS (
+		SELECT borrow_id, SUM(amount_cents) AS total
+		FROM fine_transactions
+		WHERE kind = 'charge' AND borrow_id IN (SELECT borrow_id FROM owed)
+		GROUP BY borrow_id
+	)
+	INSERT INTO fine_transactions (user_id, borrow_id, kind, amount_cents, note)
+	SELECT o.user_id, o.borrow_id, 'charge', o.amount - COALESCE(c.total, 0), 'Overdue fine'
+	FROM owed o
+	LEFT JOIN charged c ON c.borrow_id = o.borrow_id
+	WHERE o.amount > COALESCE(c.total, 0)`
+
+// FinePolicy is the admin-configured fine schedule. Amounts are in cents and
+// a BlockCents of zero never blocks borrowing.
+type FinePolicy struct {
+	DailyCents int
+	GraceDays  int
+	MaxCents   int
+	BlockCents int
+}
+
+// Blocks reports whether a member owing balance may no longer borrow.
+func (p FinePolicy) Blocks(balance int) bool {
+	return p.BlockCents > 0 && balance >= p.BlockCents
+}
+
+type FineTransaction struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	BorrowID    *int64    `json:"borrow_id,omitempty"`
+	Kind        string    `json:"kind"`
+	AmountCents int       `json:"amount_cents"`
+	Note        string    `json:"note"`
+	CreatedBy   *int64    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	BookTitle string `json:"book_title,omitempty"`
+}
+
+// FineBalance is a member's outstanding fines, in cents.
+type FineBalance struct {
+	UserID       int64  `json:"user_id"`
+	UserName     string `json:"user_name"`
+	UserEmail    string `json:"user_email"`
+	BalanceCents int    `json:"balance_cents"`
+}
+
+func ValidateFineTransaction(v *validator.Validator, t *FineTransaction, balance int) {
+	v.Check(
+		validator.PermittedValue(t.Kind, FinePayment, FineWaiver),
+		"kind",
+		"Kind must be payment or waiver",
+	)
+	v.Check(t.AmountCents > 0, "amount", "Amount must be greater than zero")
+	v.Check(t.AmountCents <= balance, "amount", "Amount must not exceed the outstanding balance")
+	v.Check(len(t.Note) <= 500, "note", "Note must not exceed 500 characters")
+}
+
+type FineModel struct {
+	DB *sql.DB
+}
+
+func accrueFines(ctx context.Context, tx *sql.Tx, filter string, args ...any) (int64, error) {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, fineAccrualLockKey)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(accrueFinesSQL, filter), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (m FineModel) GetPolicy() (FinePolicy, error) {
+	query := `
+		SELECT
+			COALESCE((SELECT value::int FROM settings WHERE key = 'fine_daily_cents'), 25),
+			COALESCE((SELECT value::int FROM settings WHERE key = 'fine_grace_days'), 2),
+			COALESCE((SELECT value::int FROM settings WHERE key = 'fine_max_cents'), 1000),
+			COALESCE((SELECT value::int FROM settings WHERE key = 'fine_block_cents'), 500)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p FinePolicy
+	err := m.DB.QueryRowContext(ctx, query).Scan(&p.DailyCents, &p.GraceDays, &p.MaxCents, &p.BlockCents)
+	return p, err
+}
+
+// AccrueOverdue brings the charges for every loan that is still out up to
+// date. It returns the number of charges added.
+func (m FineModel) AccrueOverdue() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := accrueFines(ctx, tx, "br.returned_at IS NULL")
+	if err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// Record adds a payment or waiver to the member's ledger.
+func (m FineModel) Record(t *FineTransaction, ev *AuditEvent) error {
+	query := `
+		INSERT INTO fine_transactions (user_id, kind, amount_cents, note, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := []any{t.UserID, t.Kind, t.AmountCents, t.Note, t.CreatedBy}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, ev, t.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// finesBlock reports whether the user owes enough in fines, as of the
+// transaction, that the fine policy stops them from borrowing.
+func finesBlock(ctx context.Context, tx *sql.Tx, userID int64) (bool, error) {
+	var p FinePolicy
+	var balance int
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT value::int FROM settings WHERE key = 'fine_block_cents'), 500),
+		       COALESCE(SUM(CASE kind WHEN 'charge' THEN amount_cents ELSE -amount_cents END), 0)
+		FROM fine_transactions
+		WHERE user_id = $1
+	`, userID).Scan(&p.BlockCents, &balance)
+	if err != nil {
+		return false, err
+	}
+	return p.Blocks(balance), nil
+}
+
+func (m FineModel) Balance(userID int64) (int, error) {
+	query := `
+		SELECT COALESCE(SUM(CASE kind WHEN 'charge' THEN amount_cents ELSE -amount_cents END), 0)
+		FROM fine_transactions
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var balance int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+func (m FineModel) GetLedger(userID int64) ([]*FineTransaction, error) {
+	query := `
+		SELECT f.id, f.user_id, f.borrow_id, f.kind, f.amount_cents, f.note, f.created_by,
+		       f.created_at, COALESCE(b.title, '')
+		FROM fine_transactions f
+		LEFT JOIN borrow_records br ON f.borrow_id = br.id
+		LEFT JOIN books b ON br.book_id = b.id
+		WHERE f.user_id = $1
+		ORDER BY f.created_at DESC, f.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ledger []*FineTransaction
+	for rows.Next() {
+		var t FineTransaction
+		err := rows.Scan(
+			&t.ID, &t.UserID, &t.BorrowID, &t.Kind, &t.AmountCents, &t.Note, &t.CreatedBy,
+			&t.CreatedAt, &t.BookTitle,
+		)
+		if err != nil {
+			return nil, err
+		}
+		ledger = append(ledger, &t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ledger, nil
+}
+
+// GetOutstanding lists every member who currently owes fines, largest
+// balance first.
+func (m FineModel) GetOutstanding() ([]*FineBalance, error) {
+	query := `
+		SELECT u.id, u.name, u.email,
+		       SUM(CASE f.kind WHEN 'charge' THEN f.amount_cents ELSE -f.amount_cents END) AS balance
+		FROM fine_transactions f
+		INNER JOIN users u ON f.user_id = u.id
+		GROUP BY u.id, u.name, u.email
+		HAVING SUM(CASE f.kind WHEN 'charge' THEN f.amount_cents ELSE -f.amount_cents END) > 0
+		ORDER BY balance DESC, u.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []*FineBalance
+	for rows.Next() {
+		var b FineBalance
+		err := rows.Scan(&b.UserID, &b.UserName, &b.UserEmail, &b.BalanceCents)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, &b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
@@ -0,0 +1,543 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateHold = errors.New("hold already placed")
+	ErrHoldNotNeeded = errors.New("copies are available to borrow")
+)
+
+const SettingHoldPickupDays = "hold_pickup_days"
+
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
+)
+
+// pickupDeadlineSQL is the deadline given to a hold when a copy is set aside
+// for it, using the admin-configured pickup window.
+const pickupDeadlineSQL = `NOW() + make_interval(days => COALESCE(
+	(SELECT value::int FROM settings WHERE key = 'hold_pickup_days'), 3))`
+
+type Hold struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"-"`
+	BookID    int        `json:"book_id"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Position  int        `json:"position,omitempty"`
+
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	CoverImage string `json:"cover_image"`
+}
+
+type HoldModel struct {
+	DB *sql.DB
+}
+
+// Place adds the user to the back of the hold queue for the book's work, so
+// the hold can be filled by a copy of any edition. Holds are only accepted
+// while every copy of every edition is either on loan or set aside for someone
+// else, and only up to the hold limit of the member's circulation policy.
+func (m HoldModel) Place(userID, bookID int64) (*Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	available, err := lockBook(ctx, tx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkInCatalogue(ctx, tx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	var borrowing bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM borrow_records br
+			INNER JOIN books b ON b.id = br.book_id
+			WHERE br.user_id = $1 AND br.returned_at IS NULL
+			  AND b.work_id = (SELECT work_id FROM books WHERE id = $2)
+		)
+	`, userID, bookID).Scan(&borrowing)
+	if err != nil {
+		return nil, err
+	}
+	if borrowing {
+		return nil, ErrAlreadyBorrowed
+	}
+
+	if available > 0 {
+		return nil, ErrHoldNotNeeded
+	}
+
+	var holding bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM holds h
+			INNER JOIN books b ON b.id = h.book_id
+			WHERE h.user_id = $1 AND h.status IN ('waiting', 'ready')
+			  AND b.work_id = (SELECT work_id FROM books WHERE id = $2)
+		)
+	`, userID, bookID).Scan(&holding)
+	if err != nil {
+		return nil, err
+	}
+	if holding {
+		return nil, ErrDuplicateHold
+	}
+
+	err = lockUser(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := resolvePolicy(ctx, tx, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	var holds int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM holds
+		WHERE user_id = $1 AND status IN ('waiting', 'ready')
+	`, userID).Scan(&holds)
+	if err != nil {
+		return nil, err
+	}
+	if holds >= policy.MaxHolds {
+		return nil, ErrHoldLimitReached
+	}
+
+	hold := &Hold{UserID: userID, BookID: int(bookID), Status: HoldWaiting}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO holds (user_id, book_id)
+		VALUES ($1, $2)
+		RETURNING id, created_at
+	`, userID, bookID).Scan(&hold.ID, &hold.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrDuplicateHold
+		}
+		return nil, err
+	}
+
+	return hold, tx.Commit()
+}
+
+// Cancel withdraws one of the user's active holds. A copy that was already
+// set aside for the hold is passed on to the next person in the queue.
+func (m HoldModel) Cancel(userID, holdID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var bookID int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT book_id FROM holds
+		WHERE id = $1 AND user_id = $2 AND status IN ('waiting', 'ready')
+	`, holdID, userID).Scan(&bookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	err = cancelHold(ctx, tx, holdID, bookID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// cancelHold cancels an active hold on the book, first locking the book's
+// work. A copy that was set aside for the hold is passed on to the next
+// person in the queue.
+func cancelHold(ctx context.Context, tx *sql.Tx, holdID, bookID int64) error {
+	_, err := lockBook(ctx, tx, bookID)
+	if err != nil {
+		return err
+	}
+
+	// The status is checked again now that the book is locked; the hold may
+	// have been promoted or expired since the first lookup.
+	var copyID *int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE holds
+		SET status = 'cancelled'
+		WHERE id = $1 AND status IN ('waiting', 'ready')
+		RETURNING copy_id
+	`, holdID).Scan(&copyID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	// A ready hold had a copy set aside; put it back so the next person in
+	// line can have it.
+	if copyID != nil {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE copies
+			SET status = 'available', version = version + 1
+			WHERE id = $1 AND status = 'on_hold'
+		`, *copyID)
+		if err != nil {
+			return err
+		}
+
+		return refreshHolds(ctx, tx, bookID)
+	}
+
+	return nil
+}
+
+// cancelActiveHolds cancels the active holds matching a condition on the
+// holds table, such as "user_id = $1".
+func cancelActiveHolds(ctx context.Context, tx *sql.Tx, where string, args ...any) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, book_id FROM holds
+		WHERE status IN ('waiting', 'ready') AND (`+where+`)
+		ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+
+	type hold struct{ id, bookID int64 }
+	var holds []hold
+	for rows.Next() {
+		var h hold
+		if err := rows.Scan(&h.id, &h.bookID); err != nil {
+			rows.Close()
+			return err
+		}
+		holds = append(holds, h)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, h := range holds {
+		err = cancelHold(ctx, tx, h.id, h.bookID)
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+const holdColumns = `
+	h.id, h.user_id, h.book_id, h.status, h.created_at, h.ready_at, h.expires_at,
+	CASE WHEN h.status = 'waiting' THEN (
+		SELECT COUNT(*) FROM holds q
+		INNER JOIN books qb ON qb.id = q.book_id
+		WHERE qb.work_id = b.work_id
+		  AND q.status = 'waiting'
+		  AND (q.created_at, q.id) <= (h.created_at, h.id)
+	) ELSE 0 END,
+	b.title, b.author, b.cover_image`
+
+func scanHold(row interface{ Scan(...any) error }) (*Hold, error) {
+	var h Hold
+	err := row.Scan(
+		&h.ID, &h.UserID, &h.BookID, &h.Status, &h.CreatedAt, &h.ReadyAt, &h.ExpiresAt,
+		&h.Position, &h.Title, &h.Author, &h.CoverImage,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (m HoldModel) GetActiveForUser(userID int64) ([]*Hold, error) {
+	query := `
+		SELECT ` + holdColumns + `
+		FROM holds h
+		INNER JOIN books b ON h.book_id = b.id
+		WHERE h.user_id = $1 AND h.status IN ('waiting', 'ready')
+		ORDER BY h.created_at ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holds []*Hold
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
+
+// GetActive returns the user's hold on the book's work, which may be waiting
+// on or have a copy set aside from another edition.
+func (m HoldModel) GetActive(userID, bookID int64) (*Hold, error) {
+	query := `
+		SELECT ` + holdColumns + `
+		FROM holds h
+		INNER JOIN books b ON h.book_id = b.id
+		WHERE h.user_id = $1 AND h.status IN ('waiting', 'ready')
+		  AND b.work_id = (SELECT work_id FROM books WHERE id = $2)
+		ORDER BY h.status = 'ready' DESC, h.created_at
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	h, err := scanHold(m.DB.QueryRowContext(ctx, query, userID, bookID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return h, nil
+}
+
+func (m HoldModel) CountWaiting(bookID int64) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM holds h
+		INNER JOIN books b ON b.id = h.book_id
+		WHERE b.work_id = (SELECT work_id FROM books WHERE id = $1) AND h.status = 'waiting'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, bookID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ProcessQueues expires ready holds whose pickup window has passed and hands
+// any free copies to waiting holds. It returns the number of works touched.
+func (m HoldModel) ProcessQueues() (int, error) {
+	query := `
+		SELECT DISTINCT ON (b.work_id) h.book_id
+		FROM holds h
+		INNER JOIN books b ON b.id = h.book_id
+		WHERE (h.status = 'ready' AND h.expires_at < NOW())
+		   OR (h.status = 'waiting' AND EXISTS (
+		       SELECT 1 FROM copies c
+		       INNER JOIN books cb ON cb.id = c.book_id
+		       WHERE cb.work_id = b.work_id AND c.status = 'available' AND cb.deleted_at IS NULL))
+		ORDER BY b.work_id, h.book_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	var bookIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		bookIDs = append(bookIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, bookID := range bookIDs {
+		err := m.processQueue(ctx, bookID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(bookIDs), nil
+}
+
+func (m HoldModel) processQueue(ctx context.Context, bookID int64) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockBook(ctx, tx, bookID)
+	if err != nil {
+		return err
+	}
+
+	err = refreshHolds(ctx, tx, bookID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockBook takes a row lock on the book's work for the rest of the
+// transaction and returns how many copies of the work's editions in the
+// catalogue are on the shelf. Every code path that moves copies between the
+// shelf, loans and the hold queue locks the work first, so editions sharing a
+// hold queue serialise on the same row.
+func lockBook(ctx context.Context, tx *sql.Tx, bookID int64) (int, error) {
+	var workID int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT w.id FROM works w
+		INNER JOIN books b ON b.work_id = w.id
+		WHERE b.id = $1
+		FOR UPDATE OF w
+	`, bookID).Scan(&workID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrRecordNotFound
+		}
+		return 0, err
+	}
+
+	var available int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM copies c
+		INNER JOIN books b ON b.id = c.book_id
+		WHERE b.work_id = $1 AND c.status = 'available' AND b.deleted_at IS NULL
+	`, workID).Scan(&available)
+	if err != nil {
+		return 0, err
+	}
+	return available, nil
+}
+
+// refreshHolds works through the hold queue of the book's work. It expires
+// ready holds that were never picked up, putting their copies back on the
+// shelf, and then sets aside shelf copies for the people at the front of the
+// queue.
+func refreshHolds(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		WITH expired AS (
+			UPDATE holds h
+			SET status = 'expired'
+			FROM books b
+			WHERE b.id = h.book_id
+			  AND b.work_id = (SELECT work_id FROM books WHERE id = $1)
+			  AND h.status = 'ready' AND h.expires_at < NOW()
+			RETURNING h.copy_id
+		)
+		UPDATE copies
+		SET status = 'available', version = version + 1
+		WHERE id IN (SELECT copy_id FROM expired) AND status = 'on_hold'
+	`, bookID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		promoted, err := promoteNextHold(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+		if !promoted {
+			return nil
+		}
+	}
+}
+
+// promoteNextHold sets aside a shelf copy for the oldest waiting hold on the
+// work, preferring the edition the hold was placed on. The hold moves to the
+// edition of the copy, which is the one the member picks up. It reports false
+// when there is no one waiting or nothing on the shelf.
+func promoteNextHold(ctx context.Context, tx *sql.Tx, bookID int64) (bool, error) {
+	var holdID, heldBookID int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT h.id, h.book_id FROM holds h
+		INNER JOIN books b ON b.id = h.book_id
+		WHERE b.work_id = (SELECT work_id FROM books WHERE id = $1) AND h.status = 'waiting'
+		ORDER BY h.created_at, h.id
+		LIMIT 1
+	`, bookID).Scan(&holdID, &heldBookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var copyID, copyBookID int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT c.id, c.book_id FROM copies c
+		INNER JOIN books b ON b.id = c.book_id
+		WHERE b.work_id = (SELECT work_id FROM books WHERE id = $1) AND c.status = 'available'
+		  AND b.deleted_at IS NULL
+		ORDER BY c.book_id = $2 DESC, c.id
+		LIMIT 1
+	`, bookID, heldBookID).Scan(&copyID, &copyBookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	err = setCopyStatus(ctx, tx, copyID, CopyOnHold)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE holds
+		SET status = 'ready',
+		    book_id = $3,
+		    copy_id = $2,
+		    ready_at = NOW(),
+		    expires_at = `+pickupDeadlineSQL+`
+		WHERE id = $1
+	`, holdID, copyID, copyBookID)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
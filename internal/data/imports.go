@@ -0,0 +1,724 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+var (
+	ErrImportEmpty    = errors.New("import file is empty")
+	ErrImportNoISBN   = errors.New("import file has no isbn column")
+	ErrImportMapping  = errors.New("invalid column mapping")
+	ErrImportNoColumn = errors.New("mapped column not found in import file")
+)
+
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// Outcomes of an import row. A dry run reports what would have happened.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportInvalid = "invalid"
+)
+
+const DefaultImportBatchSize = 200
+
+// ImportFields are the book fields a CSV column can be filled from. They take
+// the same values as the dashboard book form, with contributors written as in
+// "Ursula K. Le Guin; Jane Doe (translator)" and genres separated by commas.
+var ImportFields = []string{
+	"title", "contributors", "isbn", "edition_of", "description", "cover_image", "genres",
+	"pages", "language", "publisher", "item_type", "publish_date", "copies_total",
+}
+
+// ImportMapping maps book fields to the CSV column headers they are read
+// from. Fields that aren't mapped are read from a column named after the
+// field, if there is one.
+type ImportMapping map[string]string
+
+// ParseImportMapping reads a mapping written as "field=Column" pairs
+// separated by commas or new lines, e.g. "title=Book Title, isbn=ISBN13".
+func ParseImportMapping(s string) (ImportMapping, error) {
+	mapping := ImportMapping{}
+	for _, pair := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		field, column, ok := strings.Cut(pair, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		column = strings.TrimSpace(column)
+		if !ok || column == "" || !slices.Contains(ImportFields, field) {
+			return nil, fmt.Errorf("%w: %q", ErrImportMapping, pair)
+		}
+		mapping[field] = column
+	}
+	return mapping, nil
+}
+
+// columns finds the index of each field's column in the header row. Headers
+// are matched without regard to case or surrounding spaces.
+func (mapping ImportMapping) columns(header []string) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if _, exists := index[h]; !exists {
+			index[h] = i
+		}
+	}
+
+	columns := make(map[string]int)
+	for _, field := range ImportFields {
+		column, mapped := mapping[field]
+		if !mapped {
+			column = field
+		}
+
+		i, ok := index[strings.ToLower(column)]
+		if !ok {
+			if mapped {
+				return nil, fmt.Errorf("%w: %q", ErrImportNoColumn, column)
+			}
+			continue
+		}
+		columns[field] = i
+	}
+
+	if _, ok := columns["isbn"]; !ok {
+		return nil, ErrImportNoISBN
+	}
+	return columns, nil
+}
+
+type ImportOptions struct {
+	Mapping   ImportMapping
+	DryRun    bool
+	BatchSize int
+
+	// Audit, if set, is copied into a book.import event for each book the
+	// import creates or updates, saved in the same batch as the book.
+	Audit *AuditEvent
+}
+
+// ImportRow is the outcome of one data row, numbered by its line in the file
+// or, for MARC, by its record.
+type ImportRow struct {
+	Line   int               `json:"line"`
+	ISBN   string            `json:"isbn"`
+	Title  string            `json:"title"`
+	Action string            `json:"action"`
+	BookID int               `json:"book_id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun  bool        `json:"dry_run"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Invalid int         `json:"invalid"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+func (r *ImportReport) add(row ImportRow) {
+	switch row.Action {
+	case ImportCreated:
+		r.Created++
+	case ImportUpdated:
+		r.Updated++
+	case ImportInvalid:
+		r.Invalid++
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// truncate drops the rows after the first n, such as those of a batch that
+// was rolled back.
+func (r *ImportReport) truncate(n int) {
+	rows := r.Rows[:n]
+	r.Rows, r.Created, r.Updated, r.Invalid = nil, 0, 0, 0
+	for _, row := range rows {
+		r.add(row)
+	}
+}
+
+// WriteCSV writes the report with one line per row of the import.
+func (r *ImportReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write([]string{"line", "isbn", "title", "action", "book_id", "errors"})
+	if err != nil {
+		return err
+	}
+
+	for _, row := range r.Rows {
+		fields := make([]string, 0, len(row.Errors))
+		for field := range row.Errors {
+			fields = append(fields, field)
+		}
+		slices.Sort(fields)
+
+		var errs []string
+		for _, field := range fields {
+			errs = append(errs, field+": "+row.Errors[field])
+		}
+
+		bookID := ""
+		if row.BookID != 0 {
+			bookID = strconv.Itoa(row.BookID)
+		}
+
+		err = cw.Write([]string{
+			strconv.Itoa(row.Line), row.ISBN, row.Title, row.Action, bookID, strings.Join(errs, "; "),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ImportValues are the fields of one row of an import, keyed by the names in
+// ImportFields.
+type ImportValues map[string]string
+
+// An ImportSource reads the rows of an import one at a time, numbered by
+// their line or record in the file, and returns io.EOF after the last.
+type ImportSource interface {
+	Next() (line int, values ImportValues, err error)
+}
+
+// csvSource reads import rows from CSV, with the columns chosen by an
+// ImportMapping.
+type csvSource struct {
+	cr      *csv.Reader
+	columns map[string]int
+}
+
+func newCSVSource(r io.Reader, mapping ImportMapping) (*csvSource, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrImportEmpty
+		}
+		return nil, err
+	}
+
+	columns, err := mapping.columns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csvSource{cr: cr, columns: columns}, nil
+}
+
+func (s *csvSource) Next() (int, ImportValues, error) {
+	record, err := s.cr.Read()
+	if err != nil {
+		return 0, nil, err
+	}
+	line, _ := s.cr.FieldPos(0)
+
+	values := make(ImportValues, len(s.columns))
+	for field, i := range s.columns {
+		if i < len(record) {
+			values[field] = record[i]
+		}
+	}
+	return line, values, nil
+}
+
+// Import reads books from CSV and creates or updates them as ImportFrom
+// does. The first line is the header, with columns picked out by
+// opts.Mapping.
+func (m BookModel) Import(r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	src, err := newCSVSource(r, opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	return m.ImportFrom(src, opts)
+}
+
+// ImportFrom creates or updates the books read from src, matching existing
+// books on ISBN. Each row is validated with the same rules as the book form;
+// invalid rows are reported and skipped. In an existing book, blank and
+// missing fields keep their current values, and copies_total is ignored
+// since copies are then managed one by one.
+//
+// Rows are written in transactions of opts.BatchSize, so an error part way
+// through leaves the earlier batches saved; the report returned alongside the
+// error covers the saved rows. A dry run makes the same changes, batch by
+// batch, and rolls each batch back. It doesn't lock the books it reads, so
+// it can't hold up borrowing while it runs.
+func (m BookModel) ImportFrom(src ImportSource, opts ImportOptions) (*ImportReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	batchSize := opts.BatchSize
+	if batchSize < 1 {
+		batchSize = DefaultImportBatchSize
+	}
+
+	report := &ImportReport{DryRun: opts.DryRun}
+	state := &importState{
+		seen:    make(map[string]int),
+		created: make(map[string]bool),
+		dryRun:  opts.DryRun,
+	}
+
+	// A dry run is rolled back, so there is nothing to audit.
+	if !opts.DryRun {
+		state.audit = opts.Audit
+	}
+
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// saved is how many rows of the report have been committed, or for a
+	// dry run, rolled back.
+	saved := 0
+	fail := func(err error) (*ImportReport, error) {
+		if !opts.DryRun {
+			report.truncate(saved)
+		}
+		return report, err
+	}
+
+	for {
+		line, values, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		if tx == nil {
+			tx, err = m.DB.BeginTx(ctx, nil)
+			if err != nil {
+				return fail(err)
+			}
+		}
+
+		row, err := importRow(ctx, tx, line, values, state)
+		if err != nil {
+			return fail(fmt.Errorf("line %d: %w", line, err))
+		}
+		if opts.DryRun {
+			row.BookID = 0
+		}
+		report.add(row)
+
+		if len(report.Rows)-saved == batchSize {
+			if opts.DryRun {
+				err = tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+			tx = nil
+			if err != nil {
+				return fail(err)
+			}
+			saved = len(report.Rows)
+		}
+	}
+
+	if tx != nil && !opts.DryRun {
+		err := tx.Commit()
+		tx = nil
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	return report, nil
+}
+
+// importState is what importRow remembers from one row to the next.
+type importState struct {
+	// seen holds the line of each ISBN read so far, so a book can only
+	// appear once per file.
+	seen map[string]int
+
+	// created holds the ISBNs of books a dry run created in batches since
+	// rolled back, so later rows can still name them in edition_of.
+	created map[string]bool
+
+	dryRun bool
+
+	// audit, if set, is copied into an event for each book saved.
+	audit *AuditEvent
+}
+
+// importRow validates one row and, if it is valid, saves the book.
+func importRow(
+	ctx context.Context,
+	tx *sql.Tx,
+	line int,
+	values ImportValues,
+	state *importState,
+) (ImportRow, error) {
+	value := func(field string) string {
+		return strings.TrimSpace(values[field])
+	}
+
+	row := ImportRow{Line: line, ISBN: value("isbn")}
+	v := validator.New()
+
+	book, err := bookByISBN(ctx, tx, row.ISBN, !state.dryRun)
+	switch {
+	case errors.Is(err, ErrRecordNotFound):
+		book = &Book{
+			ISBN:        row.ISBN,
+			Language:    "English",
+			PublishDate: time.Now(),
+			Genres:      []string{},
+			CopiesTotal: 1,
+		}
+	case err != nil:
+		return row, err
+	case book.DeletedAt != nil:
+		v.AddError("isbn", "A book with this ISBN is in the trash; restore it to update it")
+	}
+
+	var before any
+	if book.ID != 0 {
+		previous := *book
+		before = &previous
+	}
+
+	if s := value("title"); s != "" {
+		book.Title = s
+	}
+	if s := value("contributors"); s != "" {
+		book.Contributors = ParseContributors(s)
+	}
+	if s := value("description"); s != "" {
+		book.Description = s
+	}
+	if s := value("cover_image"); s != "" {
+		book.CoverImage = s
+	}
+	if s := value("genres"); s != "" {
+		book.Genres = []string{}
+		for _, g := range strings.Split(s, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				book.Genres = append(book.Genres, g)
+			}
+		}
+	}
+	if s := value("language"); s != "" {
+		book.Language = s
+	}
+	if s := value("publisher"); s != "" {
+		book.Publisher = s
+	}
+	if s := value("item_type"); s != "" {
+		book.ItemType = s
+	}
+	if s := value("pages"); s != "" {
+		pages, err := strconv.Atoi(s)
+		if err != nil {
+			v.AddError("pages", "Pages must be a whole number")
+		}
+		book.Pages = pages
+	}
+	if s := value("publish_date"); s != "" {
+		publishDate, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			v.AddError("publish_date", "Publish date must be in YYYY-MM-DD format")
+		} else {
+			book.PublishDate = publishDate
+		}
+	}
+	if s := value("copies_total"); s != "" && book.ID == 0 {
+		copies, err := strconv.Atoi(s)
+		if err != nil {
+			v.AddError("copies_total", "Total copies must be a whole number")
+		}
+		book.CopiesTotal = copies
+	}
+	row.Title = book.Title
+
+	ValidateBook(v, book)
+
+	if s := value("edition_of"); s != "" {
+		err = tx.QueryRowContext(ctx, `SELECT work_id FROM books WHERE isbn = $1 AND deleted_at IS NULL`, canonicalISBN(s)).
+			Scan(&book.WorkID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			if !state.created[canonicalISBN(s)] {
+				v.AddError("edition_of", "No book with this ISBN exists")
+			}
+		case err != nil:
+			return row, err
+		}
+	}
+
+	if row.ISBN != "" {
+		key := canonicalISBN(row.ISBN)
+		if first, ok := state.seen[key]; ok {
+			v.AddError("isbn", fmt.Sprintf("Same ISBN as line %d", first))
+		} else {
+			state.seen[key] = line
+		}
+	}
+
+	if !v.Valid() {
+		row.Action = ImportInvalid
+		row.Errors = v.Errors
+		return row, nil
+	}
+
+	if book.ID == 0 {
+		row.Action = ImportCreated
+		err = insertBook(ctx, tx, book)
+	} else {
+		row.Action = ImportUpdated
+		err = updateBook(ctx, tx, book)
+	}
+	if err != nil {
+		return row, err
+	}
+
+	if state.dryRun && row.Action == ImportCreated {
+		state.created[book.ISBN] = true
+	}
+
+	if state.audit != nil {
+		ev := *state.audit
+		ev.Action = AuditBookImport
+		ev.TargetType = AuditTargetBook
+		ev.Before = before
+		ev.After = book
+		err = insertAudit(ctx, tx, &ev, int64(book.ID))
+		if err != nil {
+			return row, err
+		}
+	}
+
+	row.ISBN = book.ISBN
+	row.BookID = book.ID
+	return row, nil
+}
+
+// bookByISBN fetches the book with the ISBN, in either form, and if lock is
+// set, locks it for the rest of the transaction.
+func bookByISBN(ctx context.Context, tx *sql.Tx, number string, lock bool) (*Book, error) {
+	query := `
+		SELECT id, work_id, title, author, publish_date, isbn, description, cover_image, genres,
+		       pages, language, publisher, item_type, version, deleted_at
+		FROM books
+		WHERE isbn = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	var b Book
+	var genres []string
+	err := tx.QueryRowContext(ctx, query, canonicalISBN(number)).Scan(
+		&b.ID, &b.WorkID, &b.Title, &b.Author, &b.PublishDate, &b.ISBN, &b.Description,
+		&b.CoverImage, pq.Array(&genres), &b.Pages, &b.Language, &b.Publisher, &b.ItemType,
+		&b.Version, &b.DeletedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	b.Genres = genres
+
+	err = loadContributors(ctx, tx, &b)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ImportJob is a record of one import. Dashboard imports run in the
+// background, so a job stays running until Finish is called.
+type ImportJob struct {
+	ID         int64      `json:"id"`
+	UserID     *int64     `json:"user_id"`
+	UserName   string     `json:"user_name"`
+	Filename   string     `json:"filename"`
+	DryRun     bool       `json:"dry_run"`
+	Status     string     `json:"status"`
+	Created    int        `json:"created"`
+	Updated    int        `json:"updated"`
+	Invalid    int        `json:"invalid"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type ImportModel struct {
+	DB *sql.DB
+}
+
+// Start records an import as running. userID is nil for imports run from the
+// command line.
+func (m ImportModel) Start(userID *int64, filename string, dryRun bool) (*ImportJob, error) {
+	query := `
+		INSERT INTO import_jobs (user_id, filename, dry_run)
+		VALUES ($1, $2, $3)
+		RETURNING id, status, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	job := &ImportJob{UserID: userID, Filename: filename, DryRun: dryRun}
+	err := m.DB.QueryRowContext(ctx, query, userID, filename, dryRun).
+		Scan(&job.ID, &job.Status, &job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Finish saves the outcome of an import. The report may be partial, or nil,
+// when the import failed with importErr.
+func (m ImportModel) Finish(job *ImportJob, report *ImportReport, importErr error) error {
+	query := `
+		UPDATE import_jobs
+		SET status = $1, rows_created = $2, rows_updated = $3, rows_invalid = $4,
+		    error = $5, report = $6, finished_at = NOW()
+		WHERE id = $7
+		RETURNING finished_at`
+
+	job.Status = ImportDone
+	job.Error = ""
+	if importErr != nil {
+		job.Status = ImportFailed
+		job.Error = importErr.Error()
+	}
+
+	rows := []ImportRow{}
+	if report != nil {
+		job.Created, job.Updated, job.Invalid = report.Created, report.Updated, report.Invalid
+		if report.Rows != nil {
+			rows = report.Rows
+		}
+	}
+
+	js, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []any{job.Status, job.Created, job.Updated, job.Invalid, job.Error, js, job.ID}
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&job.FinishedAt)
+}
+
+const importJobColumns = `
+	j.id, j.user_id, COALESCE(u.name, ''), j.filename, j.dry_run, j.status, j.rows_created,
+	j.rows_updated, j.rows_invalid, j.error, j.created_at, j.finished_at`
+
+func scanImportJob(row interface{ Scan(...any) error }, dest ...any) (*ImportJob, error) {
+	var j ImportJob
+	err := row.Scan(append([]any{
+		&j.ID, &j.UserID, &j.UserName, &j.Filename, &j.DryRun, &j.Status, &j.Created,
+		&j.Updated, &j.Invalid, &j.Error, &j.CreatedAt, &j.FinishedAt,
+	}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+func (m ImportModel) GetRecent(limit int) ([]*ImportJob, error) {
+	query := `
+		SELECT ` + importJobColumns + `
+		FROM import_jobs j
+		LEFT JOIN users u ON u.id = j.user_id
+		ORDER BY j.created_at DESC, j.id DESC
+		LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*ImportJob
+	for rows.Next() {
+		j, err := scanImportJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// GetReport returns the job along with the report of its rows.
+func (m ImportModel) GetReport(id int64) (*ImportJob, *ImportReport, error) {
+	query := `
+		SELECT ` + importJobColumns + `, j.report
+		FROM import_jobs j
+		LEFT JOIN users u ON u.id = j.user_id
+		WHERE j.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var js []byte
+	job, err := scanImportJob(m.DB.QueryRowContext(ctx, query, id), &js)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrRecordNotFound
+		}
+		return nil, nil, err
+	}
+
+	report := &ImportReport{
+		DryRun:  job.DryRun,
+		Created: job.Created,
+		Updated: job.Updated,
+		Invalid: job.Invalid,
+	}
+	err = json.Unmarshal(js, &report.Rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return job, report, nil
+}
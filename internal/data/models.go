@@ -3,6 +3,8 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"io"
+	"time"
 )
 
 var ErrRecordNotFound = errors.New("models: record not found")
@@ -12,25 +14,45 @@ type Models struct {
 		Insert(user *User) error
 		GetByEmail(email string) (*User, error)
 		Get(id int64) (*User, error)
+		GetForToken(purpose, tokenPlaintext string) (*User, error)
+		ResetPassword(tokenPlaintext, plaintextPassword string) error
 		Count() (int, error)
 		GetAll() ([]*User, error)
-		Update(user *User) error
-		Delete(id int64) error
+		Export(fn func(*User) error) error
+		Update(user *User, ev *AuditEvent) error
+		Delete(id int64, ev *AuditEvent) error
+		GetDeleted() ([]*User, error)
+		Restore(id int64, ev *AuditEvent) error
+		Purge(before time.Time) (int, error)
 	}
 
 	Books interface {
-		GetBooks(limit, offset int) ([]*Book, error)
+		GetBooks(p Pagination) ([]*Book, Metadata, error)
 		GetBookByID(id int) (*Book, error)
-		BorrowBook(userID, bookID int64, days int) error
+		BorrowBook(userID, bookID int64) error
 		ReturnBook(userID, bookID int64) error
-		Search(q, category, availability, sort string) ([]*Book, error)
+		Search(f SearchFilters, p Pagination) (*SearchResult, error)
+		Suggest(prefix string, limit int) ([]*Suggestion, error)
+		RefreshSearchTerms() error
 		Count() (int, error)
 		GetAll() ([]*Book, error)
-		Insert(book *Book) error
-		Update(book *Book) error
-		Delete(id int) error
+		Insert(book *Book, ev *AuditEvent) error
+		Update(book *Book, ev *AuditEvent) error
+		Delete(id int, ev *AuditEvent) error
+		GetDeleted() ([]*Book, error)
+		Restore(id int, ev *AuditEvent) error
+		Purge(before time.Time) (int, error)
+		GetEditions(workID int64) ([]*Book, error)
+		WorkIDByISBN(isbn string) (int64, error)
 		ISBNExists(isbn string) (bool, error)
 		ISBNExistsExcluding(isbn string, excludeID int) (bool, error)
+		NormalizeISBNs(dryRun bool) ([]ISBNChange, error)
+		RemoteCovers() (map[int]string, error)
+		SetCoverImage(id int, coverURL string, ev *AuditEvent) error
+		Import(r io.Reader, opts ImportOptions) (*ImportReport, error)
+		ImportFrom(src ImportSource, opts ImportOptions) (*ImportReport, error)
+		Export(fn func(*Book) error) error
+		ExportSearch(f SearchFilters, fn func(*Book) error) error
 	}
 
 	BorrowRecord interface {
@@ -38,6 +60,79 @@ type Models struct {
 		GetBorrowHistory(userID int64) ([]*BorrowedBook, error)
 		CountActiveBorrows() (int, error)
 		CountOverdue() (int, error)
+		Export(r DateRange, fn func(*BorrowRecord) error) error
+	}
+
+	Tokens interface {
+		New(userID int64, ttl time.Duration, purpose string) (*Token, error)
+		NewAPIToken(userID int64, name string, scopes []string, ttl time.Duration) (*Token, error)
+		Insert(token *Token) error
+		Get(purpose, tokenPlaintext string) (*Token, error)
+		GetAllForUser(userID int64, purpose string) ([]*Token, error)
+		Delete(id, userID int64) error
+		DeleteAllForUser(purpose string, userID int64) error
+	}
+
+	Contributors interface {
+		Get(id int64) (*Contributor, error)
+		GetBooks(id int64) ([]*ContributedBook, error)
+	}
+
+	Copies interface {
+		GetAllForBook(bookID int64) ([]*Copy, error)
+		Get(id int64) (*Copy, error)
+		Insert(c *Copy, ev *AuditEvent) error
+		Update(c *Copy, ev *AuditEvent) error
+	}
+
+	Holds interface {
+		Place(userID, bookID int64) (*Hold, error)
+		Cancel(userID, holdID int64) error
+		GetActiveForUser(userID int64) ([]*Hold, error)
+		GetActive(userID, bookID int64) (*Hold, error)
+		CountWaiting(bookID int64) (int, error)
+		ProcessQueues() (int, error)
+	}
+
+	Renewals interface {
+		Renew(userID, bookID int64) (*Renewal, error)
+		GetRecent(limit int) ([]*Renewal, error)
+	}
+
+	Fines interface {
+		GetPolicy() (FinePolicy, error)
+		AccrueOverdue() (int64, error)
+		Record(t *FineTransaction, ev *AuditEvent) error
+		Balance(userID int64) (int, error)
+		GetLedger(userID int64) ([]*FineTransaction, error)
+		GetOutstanding() ([]*FineBalance, error)
+	}
+
+	Policies interface {
+		Resolve(userID, bookID int64) (*CirculationPolicy, error)
+		GetAll() ([]*CirculationPolicy, error)
+		Get(id int64) (*CirculationPolicy, error)
+		Insert(p *CirculationPolicy, ev *AuditEvent) error
+		Update(p *CirculationPolicy, ev *AuditEvent) error
+		Delete(id int64, ev *AuditEvent) error
+	}
+
+	Settings interface {
+		Get(key string) (string, error)
+		GetInt(key string, fallback int) (int, error)
+		SetAll(values map[string]string, ev *AuditEvent) error
+	}
+
+	Imports interface {
+		Start(userID *int64, filename string, dryRun bool) (*ImportJob, error)
+		Finish(job *ImportJob, report *ImportReport, importErr error) error
+		GetRecent(limit int) ([]*ImportJob, error)
+		GetReport(id int64) (*ImportJob, *ImportReport, error)
+	}
+
+	Audit interface {
+		GetAll(f AuditFilters, p Pagination) ([]*AuditEvent, Metadata, error)
+		Export(f AuditFilters, fn func(*AuditEvent) error) error
 	}
 }
 
@@ -46,5 +141,15 @@ func NewModels(db *sql.DB) Models {
 		Users:        UserModel{DB: db},
 		Books:        BookModel{DB: db},
 		BorrowRecord: BorrowRecordModel{DB: db},
+		Tokens:       TokenModel{DB: db},
+		Contributors: ContributorModel{DB: db},
+		Copies:       CopyModel{DB: db},
+		Holds:        HoldModel{DB: db},
+		Renewals:     RenewalModel{DB: db},
+		Fines:        FineModel{DB: db},
+		Policies:     PolicyModel{DB: db},
+		Settings:     SettingModel{DB: db},
+		Imports:      ImportModel{DB: db},
+		Audit:        AuditModel{DB: db},
 	}
 }
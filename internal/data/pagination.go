@@ -0,0 +1,165 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Pagination asks for one page of a keyset-paginated listing. Cursor is
+// empty for the first page, otherwise it is a NextCursor or PrevCursor from
+// the Metadata of an earlier page.
+type Pagination struct {
+	Cursor string
+	Limit  int
+}
+
+func ValidatePagination(v *validator.Validator, p Pagination) {
+	v.Check(p.Limit >= 1 && p.Limit <= 100, "limit", "must be between 1 and 100")
+	v.Check(len(p.Cursor) <= 1000, "cursor", "must not be more than 1000 characters long")
+}
+
+type Metadata struct {
+	TotalRecords int    `json:"total_records"`
+	PageSize     int    `json:"page_size"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+}
+
+// sortKey is one column of a keyset ordering. Every ordering ends with the
+// book id so that rows with equal values still come back in a fixed order.
+type sortKey struct {
+	expr string
+	cast string
+	desc bool
+}
+
+// cursor is the position of a row in a listing: the values of its sort keys,
+// rendered as text by PostgreSQL so they compare exactly when cast back.
+// Before marks a cursor that pages backwards from the row.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Keys   []string `json:"k"`
+	Before bool     `json:"b,omitempty"`
+}
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s, sort string, keys []sortKey) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.Sort != sort || len(c.Keys) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+
+	// Check the values will cast so a tampered cursor is rejected here rather
+	// than failing in the database.
+	for i, k := range keys {
+		switch k.cast {
+		case "int", "bigint":
+			_, err = strconv.ParseInt(c.Keys[i], 10, 64)
+		case "float8":
+			_, err = strconv.ParseFloat(c.Keys[i], 64)
+		case "date":
+			_, err = time.Parse("2006-01-02", c.Keys[i])
+		}
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	return &c, nil
+}
+
+// keysetSQL returns the select expression that captures a row's position and
+// the WHERE condition and ORDER BY clause for the page after (or before) the
+// cursor. The cursor's values are appended to args.
+func keysetSQL(keys []sortKey, c *cursor, args *[]any) (position, where, orderBy string) {
+	backward := c != nil && c.Before
+
+	var exprs, orders []string
+	for _, k := range keys {
+		exprs = append(exprs, k.expr+"::text")
+
+		dir := "ASC"
+		if k.desc != backward {
+			dir = "DESC"
+		}
+		orders = append(orders, k.expr+" "+dir)
+	}
+	position = "ARRAY[" + strings.Join(exprs, ", ") + "]"
+	orderBy = " ORDER BY " + strings.Join(orders, ", ")
+
+	if c == nil {
+		return position, "TRUE", orderBy
+	}
+
+	// (a, b, id) after (x, y, z) is a > x OR (a = x AND b > y) OR (a = x AND
+	// b = y AND id > z), with < in place of > for descending keys.
+	var alternatives []string
+	var equal []string
+	for i, k := range keys {
+		*args = append(*args, c.Keys[i])
+		value := fmt.Sprintf("$%d::%s", len(*args), k.cast)
+
+		op := ">"
+		if k.desc != backward {
+			op = "<"
+		}
+
+		alternatives = append(alternatives,
+			"("+strings.Join(append(slices.Clone(equal), k.expr+" "+op+" "+value), " AND ")+")")
+		equal = append(equal, k.expr+" = "+value)
+	}
+	return position, "(" + strings.Join(alternatives, " OR ") + ")", orderBy
+}
+
+// pageOf trims a listing fetched with one row more than the page size down to
+// the page, and works out the cursors for the pages either side of it.
+func pageOf[T any](items []T, positions [][]string, sort string, p Pagination, c *cursor, total int) ([]T, Metadata) {
+	more := len(items) > p.Limit
+	if more {
+		items, positions = items[:p.Limit], positions[:p.Limit]
+	}
+
+	backward := c != nil && c.Before
+	if backward {
+		slices.Reverse(items)
+		slices.Reverse(positions)
+	}
+
+	metadata := Metadata{TotalRecords: total, PageSize: p.Limit}
+	if len(items) == 0 {
+		return items, metadata
+	}
+
+	if more || backward {
+		metadata.NextCursor = encodeCursor(cursor{Sort: sort, Keys: positions[len(positions)-1]})
+	}
+	if (c != nil && !backward) || (backward && more) {
+		metadata.PrevCursor = encodeCursor(cursor{Sort: sort, Keys: positions[0], Before: true})
+	}
+
+	return items, metadata
+}
@@ -0,0 +1,363 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+var (
+	ErrLoanLimitReached = errors.New("loan limit reached")
+	ErrHoldLimitReached = errors.New("hold limit reached")
+	ErrDuplicatePolicy  = errors.New("duplicate circulation policy")
+	ErrDefaultPolicy    = errors.New("default circulation policy cannot be deleted")
+	ErrNoMatchingPolicy = errors.New("no circulation policy applies")
+)
+
+var policyRoleLabels = map[string]string{"user": "Members", "admin": "Admins"}
+
+// CirculationPolicy is a set of loan rules scoped to any of a member role,
+// a member tier, a book genre and a book item type. A nil scope matches any
+// value, so the policy with all four unset is the library-wide default.
+type CirculationPolicy struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Role        *string   `json:"role"`
+	Tier        *string   `json:"tier"`
+	Genre       *string   `json:"genre"`
+	ItemType    *string   `json:"item_type"`
+	LoanDays    int       `json:"loan_days"`
+	MaxLoans    int       `json:"max_loans"`
+	MaxRenewals int       `json:"max_renewals"`
+	RenewalDays int       `json:"renewal_days"`
+	MaxHolds    int       `json:"max_holds"`
+	CreatedAt   time.Time `json:"created_at"`
+	Version     int       `json:"version"`
+}
+
+func (p *CirculationPolicy) IsDefault() bool {
+	return p.Role == nil && p.Tier == nil && p.Genre == nil && p.ItemType == nil
+}
+
+// RoleLabel describes who the policy applies to.
+func (p *CirculationPolicy) RoleLabel() string {
+	if p.Role == nil {
+		return "Everyone"
+	}
+	return policyRoleLabels[*p.Role]
+}
+
+// RoleName, TierName, GenreName and ItemTypeName return the policy's scope,
+// or "" for any.
+func (p *CirculationPolicy) RoleName() string {
+	return scopeName(p.Role)
+}
+
+func (p *CirculationPolicy) TierName() string {
+	return scopeName(p.Tier)
+}
+
+func (p *CirculationPolicy) GenreName() string {
+	return scopeName(p.Genre)
+}
+
+func (p *CirculationPolicy) ItemTypeName() string {
+	return scopeName(p.ItemType)
+}
+
+func scopeName(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func ValidatePolicy(v *validator.Validator, p *CirculationPolicy) {
+	v.Check(validator.NotBlank(p.Name), "name", "Name is required")
+	v.Check(len(p.Name) <= 100, "name", "Name must not exceed 100 characters")
+
+	if p.Role != nil {
+		v.Check(validator.PermittedValue(*p.Role, "user", "admin"), "role", "Role must be user or admin")
+	}
+	if p.Tier != nil {
+		ValidateTier(v, *p.Tier)
+	}
+	if p.Genre != nil {
+		v.Check(validator.NotBlank(*p.Genre), "genre", "Genre must not be blank")
+		v.Check(len(*p.Genre) <= 100, "genre", "Genre must not exceed 100 characters")
+	}
+	if p.ItemType != nil {
+		ValidateItemType(v, *p.ItemType)
+	}
+
+	v.Check(p.LoanDays >= 1 && p.LoanDays <= 365, "loan_days", "Loan period must be between 1 and 365 days")
+	v.Check(p.MaxLoans >= 0, "max_loans", "Maximum loans cannot be negative")
+	v.Check(p.MaxRenewals >= 0, "max_renewals", "Maximum renewals cannot be negative")
+	v.Check(
+		p.RenewalDays >= 1 && p.RenewalDays <= 365,
+		"renewal_days",
+		"Renewal period must be between 1 and 365 days",
+	)
+	v.Check(p.MaxHolds >= 0, "max_holds", "Maximum holds cannot be negative")
+}
+
+type PolicyModel struct {
+	DB *sql.DB
+}
+
+const policyColumns = `
+	id, name, role, tier, genre, item_type, loan_days, max_loans, max_renewals, renewal_days,
+	max_holds, created_at, version`
+
+func scanPolicy(row interface{ Scan(...any) error }) (*CirculationPolicy, error) {
+	var p CirculationPolicy
+	err := row.Scan(
+		&p.ID, &p.Name, &p.Role, &p.Tier, &p.Genre, &p.ItemType, &p.LoanDays, &p.MaxLoans,
+		&p.MaxRenewals, &p.RenewalDays, &p.MaxHolds, &p.CreatedAt, &p.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// resolvePolicy returns the policy that applies to the user borrowing the
+// book. It takes either the pool or a transaction.
+func resolvePolicy(
+	ctx context.Context,
+	q interface {
+		QueryRowContext(context.Context, string, ...any) *sql.Row
+	},
+	userID, bookID int64,
+) (*CirculationPolicy, error) {
+	query := `SELECT ` + policyColumns + ` FROM circulation_policy_for($1, $2)`
+
+	p, err := scanPolicy(q.QueryRowContext(ctx, query, userID, bookID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoMatchingPolicy
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+func (m PolicyModel) Resolve(userID, bookID int64) (*CirculationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return resolvePolicy(ctx, m.DB, userID, bookID)
+}
+
+// GetAll lists every policy, the default first and then from most to least
+// specific, in the order circulation_policy_for considers them.
+func (m PolicyModel) GetAll() ([]*CirculationPolicy, error) {
+	query := `
+		SELECT ` + policyColumns + `
+		FROM circulation_policies
+		ORDER BY (role IS NOT NULL OR tier IS NOT NULL OR genre IS NOT NULL OR item_type IS NOT NULL),
+		         (role IS NOT NULL)::int + (tier IS NOT NULL)::int
+		           + (genre IS NOT NULL)::int + (item_type IS NOT NULL)::int DESC,
+		         (item_type IS NOT NULL) DESC,
+		         (genre IS NOT NULL) DESC,
+		         (tier IS NOT NULL) DESC,
+		         item_type, genre, tier, role, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []*CirculationPolicy
+	for rows.Next() {
+		p, err := scanPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+func (m PolicyModel) Get(id int64) (*CirculationPolicy, error) {
+	query := `SELECT ` + policyColumns + ` FROM circulation_policies WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	p, err := scanPolicy(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+func (m PolicyModel) Insert(p *CirculationPolicy, ev *AuditEvent) error {
+	query := `
+		INSERT INTO circulation_policies
+			(name, role, tier, genre, item_type, loan_days, max_loans, max_renewals, renewal_days,
+			 max_holds)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		p.Name, p.Role, p.Tier, p.Genre, p.ItemType, p.LoanDays, p.MaxLoans, p.MaxRenewals,
+		p.RenewalDays, p.MaxHolds,
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&p.ID, &p.CreatedAt, &p.Version)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicatePolicy
+		}
+		return err
+	}
+
+	err = insertAudit(ctx, tx, ev, p.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update saves the policy's rules. The scope of the default policy is fixed,
+// so a role, tier, genre or item type on it is ignored.
+func (m PolicyModel) Update(p *CirculationPolicy, ev *AuditEvent) error {
+	query := `
+		WITH current AS (
+			SELECT role IS NULL AND tier IS NULL AND genre IS NULL AND item_type IS NULL AS is_default
+			FROM circulation_policies
+			WHERE id = $11
+		)
+		UPDATE circulation_policies
+		SET name = $1,
+		    role = CASE WHEN current.is_default THEN NULL ELSE $2 END,
+		    tier = CASE WHEN current.is_default THEN NULL ELSE $3 END,
+		    genre = CASE WHEN current.is_default THEN NULL ELSE $4 END,
+		    item_type = CASE WHEN current.is_default THEN NULL ELSE $5 END,
+		    loan_days = $6, max_loans = $7, max_renewals = $8, renewal_days = $9,
+		    max_holds = $10, version = version + 1
+		FROM current
+		WHERE id = $11
+		RETURNING role, tier, genre, item_type, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		p.Name, p.Role, p.Tier, p.Genre, p.ItemType, p.LoanDays, p.MaxLoans, p.MaxRenewals,
+		p.RenewalDays, p.MaxHolds, p.ID,
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&p.Role, &p.Tier, &p.Genre, &p.ItemType, &p.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrDuplicatePolicy
+			}
+			return err
+		}
+	}
+
+	err = insertAudit(ctx, tx, ev, p.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PolicyModel) Delete(id int64, ev *AuditEvent) error {
+	query := `
+		DELETE FROM circulation_policies
+		WHERE id = $1
+		  AND (role IS NOT NULL OR tier IS NOT NULL OR genre IS NOT NULL OR item_type IS NOT NULL)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		p, err := m.Get(id)
+		if err != nil {
+			return err
+		}
+		if p.IsDefault() {
+			return ErrDefaultPolicy
+		}
+		return ErrRecordNotFound
+	}
+
+	err = insertAudit(ctx, tx, ev, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockUser serialises circulation for one member so that two loans or holds
+// placed at the same time can't both slip under a limit. Callers lock the
+// book first and the user second.
+func lockUser(ctx context.Context, tx *sql.Tx, userID int64) error {
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
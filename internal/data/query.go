@@ -0,0 +1,574 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The search box accepts a small query language on top of plain words:
+//
+//	author:"Le Guin" genre:fantasy year:1960..1975 pages:<300 lang:English available:yes
+//
+// Terms next to each other must all match, OR between terms matches either,
+// and NOT or a leading - excludes a term. NOT binds tightest and OR loosest;
+// parentheses group terms. Words and "quoted phrases" without a field are
+// matched against the full text of the book.
+var queryFields = map[string]string{
+	"title":       "title",
+	"author":      "author",
+	"contributor": "contributor",
+	"genre":       "genre",
+	"publisher":   "publisher",
+	"lang":        "language",
+	"language":    "language",
+	"isbn":        "isbn",
+	"year":        "year",
+	"pages":       "pages",
+	"available":   "available",
+}
+
+// QueryError reports where and why a search query couldn't be parsed.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos+1)
+}
+
+// Query is a parsed search query. Advanced is set when the query uses field
+// qualifiers, grouping, AND or NOT; otherwise it is plain text that
+// websearch_to_tsquery understands as it is. Text holds the words and phrases
+// the books should match, which are used to rank results and highlight
+// snippets.
+type Query struct {
+	Text     string
+	Advanced bool
+	root     queryNode
+}
+
+// ParseQuery parses a search query. An empty query parses to a Query that
+// matches every book.
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, &QueryError{t.pos, "unexpected closing parenthesis"}
+	}
+
+	q := &Query{root: root}
+	for _, t := range tokens {
+		switch t.kind {
+		case tokenTerm:
+			if _, ok := t.node.(queryText); !ok {
+				q.Advanced = true
+			}
+		case tokenAnd, tokenOpen:
+			q.Advanced = true
+		case tokenNot:
+			if t.value == "NOT" {
+				q.Advanced = true
+			}
+		}
+	}
+
+	var words []string
+	collectText(root, false, &words)
+	q.Text = strings.Join(words, " ")
+
+	return q, nil
+}
+
+// SQL compiles the query to a condition on the books table, appending its
+// parameters to args.
+func (q *Query) SQL(args *[]any) string {
+	if q.root == nil {
+		return "TRUE"
+	}
+	return q.root.sql(args)
+}
+
+type queryNode interface {
+	sql(args *[]any) string
+}
+
+type (
+	queryAnd []queryNode
+	queryOr  []queryNode
+	queryNot struct{ node queryNode }
+
+	// queryText is a word or phrase matched against the book's full text.
+	queryText struct {
+		value  string
+		phrase bool
+	}
+
+	// queryMatch is a field compared with a value: a substring of the title,
+	// publisher or a contributor's name, or the whole genre, language or ISBN.
+	queryMatch struct {
+		field string
+		value string
+	}
+
+	// queryRange is an inclusive range of years or page counts. A nil bound
+	// is open.
+	queryRange struct {
+		field    string
+		min, max *int
+	}
+
+	queryAvailable bool
+)
+
+func (n queryAnd) sql(args *[]any) string {
+	return joinQuerySQL(n, " AND ", args)
+}
+
+func (n queryOr) sql(args *[]any) string {
+	return joinQuerySQL(n, " OR ", args)
+}
+
+func joinQuerySQL(nodes []queryNode, sep string, args *[]any) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = n.sql(args)
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+func (n queryNot) sql(args *[]any) string {
+	return "NOT " + n.node.sql(args)
+}
+
+// A word made up only of stop words gives an empty tsquery, which would match
+// nothing, so it is treated as matching everything instead.
+func (n queryText) sql(args *[]any) string {
+	*args = append(*args, n.value)
+	return fmt.Sprintf(
+		"(numnode(phraseto_tsquery('english', $%[1]d::text)) = 0"+
+			" OR books.search_vector @@ phraseto_tsquery('english', $%[1]d::text))",
+		len(*args),
+	)
+}
+
+func (n queryMatch) sql(args *[]any) string {
+	switch n.field {
+	case "author", "contributor":
+		// Names are compared by their keys, so initials match however they
+		// are punctuated.
+		role := ""
+		if n.field == "author" {
+			role = " AND bc.role = 'author'"
+		}
+		*args = append(*args, n.value)
+		return fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM book_contributors bc
+			INNER JOIN contributors c ON c.id = bc.contributor_id
+			WHERE bc.book_id = books.id%s
+			  AND c.name_key LIKE '%%' || contributor_name_key($%d::text) || '%%')`, role, len(*args))
+	case "title", "publisher":
+		*args = append(*args, "%"+likeEscaper.Replace(n.value)+"%")
+		return fmt.Sprintf("books.%s ILIKE $%d::text", n.field, len(*args))
+	case "genre":
+		*args = append(*args, n.value)
+		return fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM unnest(books.genres) AS g WHERE lower(g) = lower($%d::text))",
+			len(*args),
+		)
+	case "language":
+		*args = append(*args, n.value)
+		return fmt.Sprintf("lower(books.language) = lower($%d::text)", len(*args))
+	default:
+		*args = append(*args, canonicalISBN(n.value))
+		return fmt.Sprintf("replace(books.isbn, '-', '') = replace($%d::text, '-', '')", len(*args))
+	}
+}
+
+func (n queryRange) sql(args *[]any) string {
+	expr := "books.pages"
+	if n.field == "year" {
+		expr = "extract(year FROM books.publish_date)::int"
+	}
+
+	var conds []string
+	if n.min != nil {
+		*args = append(*args, *n.min)
+		conds = append(conds, fmt.Sprintf("%s >= $%d::int", expr, len(*args)))
+	}
+	if n.max != nil {
+		*args = append(*args, *n.max)
+		conds = append(conds, fmt.Sprintf("%s <= $%d::int", expr, len(*args)))
+	}
+	return "(" + strings.Join(conds, " AND ") + ")"
+}
+
+func (n queryAvailable) sql(args *[]any) string {
+	*args = append(*args, bool(n))
+	return fmt.Sprintf("((cc.copies_available > 0) = $%d::boolean)", len(*args))
+}
+
+// collectText gathers the words and phrases a book must contain to match,
+// leaving out excluded ones, in the syntax of websearch_to_tsquery.
+func collectText(n queryNode, negated bool, words *[]string) {
+	switch n := n.(type) {
+	case queryAnd:
+		for _, c := range n {
+			collectText(c, negated, words)
+		}
+	case queryOr:
+		for _, c := range n {
+			collectText(c, negated, words)
+		}
+	case queryNot:
+		collectText(n.node, !negated, words)
+	case queryText:
+		if negated {
+			return
+		}
+		if n.phrase {
+			*words = append(*words, `"`+n.value+`"`)
+		} else {
+			*words = append(*words, n.value)
+		}
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenTerm
+	tokenOr
+	tokenAnd
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind  tokenKind
+	pos   int
+	value string
+	node  queryNode
+}
+
+func lexQuery(s string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen, pos: i})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose, pos: i})
+			i++
+
+		case r == '-' && i+1 < len(s) && (s[i+1] == '(' || !isQueryBreak(s[i+1])):
+			tokens = append(tokens, token{kind: tokenNot, pos: i, value: "-"})
+			i++
+
+		case r == '"':
+			phrase, end, err := lexQuoted(s, i)
+			if err != nil {
+				return nil, err
+			}
+			if phrase != "" {
+				tokens = append(tokens, token{kind: tokenTerm, pos: i, node: queryText{phrase, true}})
+			}
+			i = end
+
+		default:
+			start := i
+			for i < len(s) && !isQueryBreak(s[i]) && s[i] != '"' {
+				i++
+			}
+			word := s[start:i]
+
+			switch word {
+			case "OR":
+				tokens = append(tokens, token{kind: tokenOr, pos: start})
+				continue
+			case "AND":
+				tokens = append(tokens, token{kind: tokenAnd, pos: start})
+				continue
+			case "NOT":
+				tokens = append(tokens, token{kind: tokenNot, pos: start, value: word})
+				continue
+			}
+
+			name, value, found := strings.Cut(word, ":")
+			field, ok := queryFields[strings.ToLower(name)]
+			if !found || !ok {
+				tokens = append(tokens, token{kind: tokenTerm, pos: start, node: queryText{word, false}})
+				continue
+			}
+
+			if value == "" && i < len(s) && s[i] == '"' {
+				var err error
+				value, i, err = lexQuoted(s, i)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			node, err := fieldNode(field, strings.TrimSpace(value))
+			if err != nil {
+				return nil, &QueryError{start, err.Error()}
+			}
+			tokens = append(tokens, token{kind: tokenTerm, pos: start, node: node})
+		}
+	}
+
+	return tokens, nil
+}
+
+// lexQuoted reads the quoted string starting at s[i], returning its contents
+// and the index just past the closing quote.
+func lexQuoted(s string, i int) (string, int, error) {
+	end := strings.IndexByte(s[i+1:], '"')
+	if end < 0 {
+		return "", 0, &QueryError{i, "unterminated quote"}
+	}
+	return strings.TrimSpace(s[i+1 : i+1+end]), i + end + 2, nil
+}
+
+func isQueryBreak(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(' || c == ')'
+}
+
+func fieldNode(field, value string) (queryNode, error) {
+	if value == "" {
+		return nil, fmt.Errorf("%s: needs a value", field)
+	}
+
+	switch field {
+	case "year", "pages":
+		return parseRange(field, value)
+	case "available":
+		switch strings.ToLower(value) {
+		case "yes", "true":
+			return queryAvailable(true), nil
+		case "no", "false":
+			return queryAvailable(false), nil
+		}
+		return nil, fmt.Errorf("available: must be yes or no")
+	}
+	return queryMatch{field, value}, nil
+}
+
+// queryRangeLimits are the numbers a range may be given, which keeps them
+// well inside the int the SQL compares them as.
+var queryRangeLimits = map[string][2]int{
+	"year":  {0, 9999},
+	"pages": {0, 1000000},
+}
+
+// parseRange reads a number (1999), a range (1960..1975, 1960.., ..1975) or a
+// comparison (<300, <=300, >300, >=300). Bounds are turned inclusive, which
+// works because years and page counts are whole numbers.
+func parseRange(field, value string) (queryNode, error) {
+	limits := queryRangeLimits[field]
+	number := func(s string) (*int, error) {
+		if s == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("%s: %q is not a number", field, s)
+		}
+		if err != nil || n < limits[0] || n > limits[1] {
+			return nil, fmt.Errorf("%s: must be between %d and %d", field, limits[0], limits[1])
+		}
+		return &n, nil
+	}
+
+	n := queryRange{field: field}
+	var err error
+
+	switch {
+	case strings.Contains(value, ".."):
+		lo, hi, _ := strings.Cut(value, "..")
+		if n.min, err = number(lo); err != nil {
+			return nil, err
+		}
+		if n.max, err = number(hi); err != nil {
+			return nil, err
+		}
+		if n.min == nil && n.max == nil {
+			return nil, fmt.Errorf("%s: a range needs at least one bound", field)
+		}
+		if n.min != nil && n.max != nil && *n.min > *n.max {
+			return nil, fmt.Errorf("%s: range starts after it ends", field)
+		}
+
+	case strings.HasPrefix(value, "<="):
+		n.max, err = number(value[2:])
+	case strings.HasPrefix(value, ">="):
+		n.min, err = number(value[2:])
+	case strings.HasPrefix(value, "<"):
+		if n.max, err = number(value[1:]); n.max != nil {
+			*n.max--
+		}
+	case strings.HasPrefix(value, ">"):
+		if n.min, err = number(value[1:]); n.min != nil {
+			*n.min++
+		}
+	default:
+		n.min, err = number(value)
+		n.max = n.min
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if n.min == nil && n.max == nil {
+		return nil, fmt.Errorf("%s: needs a number", field)
+	}
+	return n, nil
+}
+
+type queryParser struct {
+	tokens []token
+	i      int
+}
+
+func (p *queryParser) peek() token {
+	if p.i >= len(p.tokens) {
+		return token{kind: tokenEnd, pos: -1}
+	}
+	return p.tokens[p.i]
+}
+
+// parseOr parses terms separated by OR. It returns nil when there are no
+// terms before the end of the query or group.
+func (p *queryParser) parseOr() (queryNode, error) {
+	var nodes []queryNode
+
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		t := p.peek()
+		if n == nil {
+			switch {
+			case t.kind == tokenOr:
+				return nil, &QueryError{t.pos, "OR must come between two terms"}
+			case len(nodes) > 0:
+				return nil, &QueryError{p.tokens[p.i-1].pos, "OR must come between two terms"}
+			}
+			return nil, nil
+		}
+		nodes = append(nodes, n)
+
+		if t.kind != tokenOr {
+			break
+		}
+		p.i++
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return queryOr(nodes), nil
+}
+
+// parseAnd parses terms that must all match, joined by AND or just written
+// next to each other.
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var nodes []queryNode
+
+	for {
+		t := p.peek()
+		switch t.kind {
+		case tokenEnd, tokenOr, tokenClose:
+			switch len(nodes) {
+			case 0:
+				return nil, nil
+			case 1:
+				return nodes[0], nil
+			}
+			return queryAnd(nodes), nil
+
+		case tokenAnd:
+			p.i++
+			next := p.peek().kind
+			if len(nodes) == 0 || next == tokenEnd || next == tokenOr || next == tokenClose ||
+				next == tokenAnd {
+				return nil, &QueryError{t.pos, "AND must come between two terms"}
+			}
+			continue
+		}
+
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	t := p.peek()
+	if t.kind != tokenNot {
+		return p.parsePrimary()
+	}
+
+	p.i++
+	switch p.peek().kind {
+	case tokenTerm, tokenOpen, tokenNot:
+	default:
+		return nil, &QueryError{t.pos, t.value + " must be followed by a term"}
+	}
+
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return queryNot{n}, nil
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	t := p.peek()
+	p.i++
+
+	if t.kind == tokenTerm {
+		return t.node, nil
+	}
+
+	// Anything else reaching here is an opening parenthesis; parseAnd stops
+	// before closing ones.
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenClose {
+		return nil, &QueryError{t.pos, "missing closing parenthesis"}
+	}
+	if n == nil {
+		return nil, &QueryError{t.pos, "empty parentheses"}
+	}
+	p.i++
+	return n, nil
+}
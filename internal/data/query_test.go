@@ -0,0 +1,209 @@
+package data
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func intp(n int) *int {
+	return &n
+}
+
+func word(s string) queryText {
+	return queryText{value: s}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  queryNode
+	}{
+		{"empty", "", nil},
+		{"blank", "   ", nil},
+		{"word", "dune", word("dune")},
+		{"words", "dune herbert", queryAnd{word("dune"), word("herbert")}},
+		{"hyphenated word", "sci-fi", word("sci-fi")},
+		{"lone hyphen", "a - b", queryAnd{word("a"), word("-"), word("b")}},
+
+		// Precedence: NOT binds tightest, then AND, then OR.
+		{"and before or", "a b OR c", queryOr{queryAnd{word("a"), word("b")}, word("c")}},
+		{"or before and", "a OR b c", queryOr{word("a"), queryAnd{word("b"), word("c")}}},
+		{"explicit and", "a AND b OR c", queryOr{queryAnd{word("a"), word("b")}, word("c")}},
+		{"not before and", "NOT a b", queryAnd{queryNot{word("a")}, word("b")}},
+		{"not before or", "NOT a OR b", queryOr{queryNot{word("a")}, word("b")}},
+		{"double not", "NOT NOT a", queryNot{queryNot{word("a")}}},
+		{"parentheses", "a AND (b OR c)", queryAnd{word("a"), queryOr{word("b"), word("c")}}},
+		{"nested parentheses", "((a))", word("a")},
+		{"lowercase operators are words", "a or b", queryAnd{word("a"), word("or"), word("b")}},
+
+		// A leading - excludes like NOT.
+		{"exclusion", "dune -messiah", queryAnd{word("dune"), queryNot{word("messiah")}}},
+		{"excluded group", "-(a OR b)", queryNot{queryOr{word("a"), word("b")}}},
+		{"exclusion before or", "-a OR b", queryOr{queryNot{word("a")}, word("b")}},
+		{"excluded field", "-genre:horror", queryNot{queryMatch{"genre", "horror"}}},
+
+		// Quoting.
+		{"phrase", `"left hand of darkness"`, queryText{"left hand of darkness", true}},
+		{"phrase is trimmed", `" dune "`, queryText{"dune", true}},
+		{"empty phrase is dropped", `"" dune`, word("dune")},
+		{"quoted field value", `author:"Le Guin"`, queryMatch{"author", "Le Guin"}},
+		{"quoted value is trimmed", `title:" Dune "`, queryMatch{"title", "Dune"}},
+		{"phrase after field", `author:"Le Guin" "earthsea"`,
+			queryAnd{queryMatch{"author", "Le Guin"}, queryText{"earthsea", true}}},
+
+		// Fields.
+		{"field", "genre:fantasy", queryMatch{"genre", "fantasy"}},
+		{"field name is case insensitive", "AUTHOR:tolkien", queryMatch{"author", "tolkien"}},
+		{"field alias", "lang:English", queryMatch{"language", "English"}},
+		{"unknown field is a word", "foo:bar", word("foo:bar")},
+		{"isbn", "isbn:978-0441013593", queryMatch{"isbn", "978-0441013593"}},
+		{"available", "available:yes", queryAvailable(true)},
+		{"not available", "available:NO", queryAvailable(false)},
+
+		// Ranges.
+		{"single year", "year:1999", queryRange{"year", intp(1999), intp(1999)}},
+		{"closed range", "year:1960..1975", queryRange{"year", intp(1960), intp(1975)}},
+		{"range of one", "year:1960..1960", queryRange{"year", intp(1960), intp(1960)}},
+		{"open end", "year:1960..", queryRange{"year", intp(1960), nil}},
+		{"open start", "year:..1975", queryRange{"year", nil, intp(1975)}},
+		{"less than", "pages:<300", queryRange{"pages", nil, intp(299)}},
+		{"at most", "pages:<=300", queryRange{"pages", nil, intp(300)}},
+		{"more than", "pages:>300", queryRange{"pages", intp(301), nil}},
+		{"at least", "pages:>=300", queryRange{"pages", intp(300), nil}},
+		{"less than lowest", "pages:<0", queryRange{"pages", nil, intp(-1)}},
+		{"upper limit", "year:..9999", queryRange{"year", nil, intp(9999)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseQuery(tt.input)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q): %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(q.root, tt.want) {
+				t.Errorf("ParseQuery(%q) = %#v, want %#v", tt.input, q.root, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		msg   string
+		pos   int
+	}{
+		{"a)", "unexpected closing parenthesis", 1},
+		{"(a", "missing closing parenthesis", 0},
+		{"a ()", "empty parentheses", 2},
+		{`dune "messiah`, "unterminated quote", 5},
+		{`author:"Le Guin`, "unterminated quote", 7},
+
+		{"OR a", "OR must come between two terms", 0},
+		{"a OR", "OR must come between two terms", 2},
+		{"a OR OR b", "OR must come between two terms", 5},
+		{"AND a", "AND must come between two terms", 0},
+		{"a AND", "AND must come between two terms", 2},
+		{"a AND OR b", "AND must come between two terms", 2},
+		{"a AND AND b", "AND must come between two terms", 2},
+		{"a NOT", "NOT must be followed by a term", 2},
+		{"NOT OR a", "NOT must be followed by a term", 0},
+		{"a -OR b", "- must be followed by a term", 2},
+
+		{"title:", "title: needs a value", 0},
+		{`author:""`, "author: needs a value", 0},
+		{"available:maybe", "available: must be yes or no", 0},
+		{"x year:abc", `year: "abc" is not a number`, 2},
+		{"year:19x0..1975", `year: "19x0" is not a number`, 0},
+		{"year:..", "year: a range needs at least one bound", 0},
+		{"year:1975..1960", "year: range starts after it ends", 0},
+		{"pages:<", "pages: needs a number", 0},
+		{"pages:>=", "pages: needs a number", 0},
+		{"year:10000", "year: must be between 0 and 9999", 0},
+		{"year:-5", "year: must be between 0 and 9999", 0},
+		{"year:99999999999", "year: must be between 0 and 9999", 0},
+		{"pages:<99999999999", "pages: must be between 0 and 1000000", 0},
+		{"pages:>999999999999999999999", "pages: must be between 0 and 1000000", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := ParseQuery(tt.input)
+
+			var qe *QueryError
+			if !errors.As(err, &qe) {
+				t.Fatalf("ParseQuery(%q) error = %v, want a QueryError", tt.input, err)
+			}
+			if qe.Msg != tt.msg || qe.Pos != tt.pos {
+				t.Errorf("ParseQuery(%q) error = %q at %d, want %q at %d",
+					tt.input, qe.Msg, qe.Pos, tt.msg, tt.pos)
+			}
+		})
+	}
+}
+
+func TestQueryErrorPosition(t *testing.T) {
+	_, err := ParseQuery("a)")
+	if got, want := err.Error(), "unexpected closing parenthesis at position 2"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestQueryTextAndAdvanced(t *testing.T) {
+	tests := []struct {
+		input    string
+		text     string
+		advanced bool
+	}{
+		{"dune herbert", "dune herbert", false},
+		{"dune OR arrakis", "dune arrakis", false},
+		{`"dune messiah" -children`, `"dune messiah"`, false},
+		{"NOT messiah", "", true},
+		{"dune AND arrakis", "dune arrakis", true},
+		{"(dune)", "dune", true},
+		{"author:herbert dune", "dune", true},
+		{"NOT (a -b)", "b", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			q, err := ParseQuery(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Text != tt.text || q.Advanced != tt.advanced {
+				t.Errorf("ParseQuery(%q) = text %q, advanced %v; want %q, %v",
+					tt.input, q.Text, q.Advanced, tt.text, tt.advanced)
+			}
+		})
+	}
+}
+
+func TestQuerySQL(t *testing.T) {
+	q, err := ParseQuery("year:1960..1975 pages:<300")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var args []any
+	got := q.SQL(&args)
+	want := "((extract(year FROM books.publish_date)::int >= $1::int" +
+		" AND extract(year FROM books.publish_date)::int <= $2::int)" +
+		" AND (books.pages <= $3::int))"
+	if got != want {
+		t.Errorf("SQL() = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(args, []any{1960, 1975, 299}) {
+		t.Errorf("args = %v", args)
+	}
+
+	empty, err := ParseQuery("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := empty.SQL(&args); got != "TRUE" {
+		t.Errorf("empty SQL() = %q, want TRUE", got)
+	}
+}
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrRenewalLimitReached = errors.New("renewal limit reached")
+	ErrBookOnHold          = errors.New("book is on hold for other members")
+	ErrFinesOutstanding    = errors.New("outstanding fines block borrowing")
+)
+
+type Renewal struct {
+	ID            int64     `json:"id"`
+	BorrowID      int64     `json:"borrow_id"`
+	RenewedAt     time.Time `json:"renewed_at"`
+	PreviousDueAt time.Time `json:"previous_due_at"`
+	NewDueAt      time.Time `json:"new_due_at"`
+	RenewalCount  int       `json:"renewal_count"`
+
+	UserID    int64  `json:"user_id"`
+	UserName  string `json:"user_name"`
+	BookID    int    `json:"book_id"`
+	BookTitle string `json:"book_title"`
+}
+
+type RenewalModel struct {
+	DB *sql.DB
+}
+
+// Renew pushes back the due date of the user's current loan of the book by
+// the renewal period of its circulation policy, counted from the later of the current due
+// date and now. Loans that have used up their renewals, or whose book other
+// members are waiting for, are refused, as are all renewals for a member
+// whose fines block borrowing. An overdue loan is charged its fine first, so
+// renewing it can't escape the fine or the block.
+func (m RenewalModel) Renew(userID, bookID int64) (*Renewal, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the book so a hold can't be placed between the queue check and
+	// the due date moving.
+	_, err = lockBook(ctx, tx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	renewal := &Renewal{UserID: userID, BookID: int(bookID)}
+
+	var count int
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, due_at, renewal_count
+		FROM borrow_records
+		WHERE user_id = $1 AND book_id = $2 AND returned_at IS NULL
+		FOR UPDATE
+	`, userID, bookID).Scan(&renewal.BorrowID, &renewal.PreviousDueAt, &count)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	_, err = accrueFines(ctx, tx, "br.id = $1", renewal.BorrowID)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := finesBlock(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrFinesOutstanding
+	}
+
+	policy, err := resolvePolicy(ctx, tx, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	if count >= policy.MaxRenewals {
+		return nil, ErrRenewalLimitReached
+	}
+
+	var onHold bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM holds h
+			INNER JOIN books b ON b.id = h.book_id
+			WHERE b.work_id = (SELECT work_id FROM books WHERE id = $1)
+			  AND h.user_id <> $2 AND h.status IN ('waiting', 'ready')
+		)
+	`, bookID, userID).Scan(&onHold)
+	if err != nil {
+		return nil, err
+	}
+	if onHold {
+		return nil, ErrBookOnHold
+	}
+
+	// Fines for the period just ended were charged above; the new period
+	// starts counting from its own due date.
+	err = tx.QueryRowContext(ctx, `
+		UPDATE borrow_records
+		SET due_at = GREATEST(due_at, NOW()) + make_interval(days => $2),
+		    renewal_count = renewal_count + 1,
+		    fines_baseline_cents = (
+		        SELECT COALESCE(SUM(amount_cents), 0) FROM fine_transactions
+		        WHERE borrow_id = $1 AND kind = 'charge')
+		WHERE id = $1
+		RETURNING due_at, renewal_count
+	`, renewal.BorrowID, policy.RenewalDays).Scan(&renewal.NewDueAt, &renewal.RenewalCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO renewals (borrow_id, previous_due_at, new_due_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, renewed_at
+	`, renewal.BorrowID, renewal.PreviousDueAt, renewal.NewDueAt).Scan(&renewal.ID, &renewal.RenewedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return renewal, tx.Commit()
+}
+
+// GetRecent returns the latest renewals across all members, newest first.
+func (m RenewalModel) GetRecent(limit int) ([]*Renewal, error) {
+	query := `
+		SELECT r.id, r.borrow_id, r.renewed_at, r.previous_due_at, r.new_due_at,
+		       (SELECT COUNT(*) FROM renewals p WHERE p.borrow_id = r.borrow_id AND p.id <= r.id),
+		       u.id, u.name, b.id, b.title
+		FROM renewals r
+		INNER JOIN borrow_records br ON r.borrow_id = br.id
+		INNER JOIN users u ON br.user_id = u.id
+		INNER JOIN books b ON br.book_id = b.id
+		ORDER BY r.renewed_at DESC, r.id DESC
+		LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var renewals []*Renewal
+	for rows.Next() {
+		var r Renewal
+		err := rows.Scan(
+			&r.ID, &r.BorrowID, &r.RenewedAt, &r.PreviousDueAt, &r.NewDueAt,
+			&r.RenewalCount, &r.UserID, &r.UserName, &r.BookID, &r.BookTitle,
+		)
+		if err != nil {
+			return nil, err
+		}
+		renewals = append(renewals, &r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return renewals, nil
+}
@@ -0,0 +1,623 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+	"github.com/0xrinful/LibraryMS/internal/validator/isbn"
+)
+
+const (
+	AvailabilityAvailable   = "available"
+	AvailabilityUnavailable = "unavailable"
+)
+
+var SearchSorts = []string{"Relevance", "Title (A-Z)", "Title (Z-A)", "Author (A-Z)", "Newest First"}
+
+// HighlightStart and HighlightStop mark matched words in a Book's Snippet.
+// They are private-use characters so they can't clash with catalogue text and
+// survive HTML escaping unchanged.
+const (
+	HighlightStart = "\ue000"
+	HighlightStop  = "\ue001"
+)
+
+var headlineOptions = "StartSel=" + HighlightStart + ", StopSel=" + HighlightStop +
+	", MaxFragments=2, MaxWords=25, MinWords=10"
+
+// SearchFilters narrows a catalogue search. Query is written in the language
+// parsed by ParseQuery. Values within one facet are
+// alternatives (a book in any of the genres matches); different facets must
+// all match.
+type SearchFilters struct {
+	Query        string
+	Genres       []string
+	Languages    []string
+	Publishers   []string
+	Decades      []int
+	Availability string
+	Sort         string
+}
+
+func ValidateSearchFilters(v *validator.Validator, f *SearchFilters) {
+	v.Check(len(f.Query) <= 500, "q", "must not be more than 500 characters long")
+
+	if _, err := ParseQuery(f.Query); err != nil {
+		v.AddError("q", err.Error())
+	}
+
+	for _, d := range f.Decades {
+		v.Check(d >= 0 && d <= 9990 && d%10 == 0, "decade", "must be a decade such as 1990")
+	}
+
+	if f.Availability != "" {
+		v.Check(
+			validator.PermittedValue(f.Availability, AvailabilityAvailable, AvailabilityUnavailable),
+			"availability",
+			"must be available or unavailable",
+		)
+	}
+
+	if f.Sort != "" {
+		v.Check(validator.PermittedValue(f.Sort, SearchSorts...), "sort", "invalid sort value")
+	}
+}
+
+// FacetBucket is one value of a facet and how many books in the results
+// have it.
+type FacetBucket struct {
+	Value    string `json:"value"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected"`
+}
+
+type SearchFacets struct {
+	Genres       []FacetBucket `json:"genres"`
+	Languages    []FacetBucket `json:"languages"`
+	Publishers   []FacetBucket `json:"publishers"`
+	Decades      []FacetBucket `json:"decades"`
+	Availability []FacetBucket `json:"availability"`
+}
+
+// searchMatchSQL finds the books matching the text condition (filled in
+// with exactMatchSQL or fuzzyMatchSQL) and works out, per book, which of the
+// facet filters it passes. Keeping the filters as columns lets the facet
+// counts leave out their own facet's filter, so picking one genre still shows
+// how many books the other genres would add.
+const searchMatchSQL = `
+	SELECT books.id, books.work_id, books.title, books.author, books.publish_date, books.isbn,
+	       books.description, books.cover_image, books.genres, books.pages, books.language,
+	       books.publisher, cc.copies_total, cc.copies_available, books.version,
+	       books.search_vector, query,
+	       extract(year FROM books.publish_date)::int / 10 * 10 AS decade,
+	       (COALESCE(cardinality($2::text[]), 0) = 0 OR books.genres && $2::text[]) AS genre_ok,
+	       (COALESCE(cardinality($3::text[]), 0) = 0 OR books.language = ANY($3::text[])) AS language_ok,
+	       (COALESCE(cardinality($4::text[]), 0) = 0 OR books.publisher = ANY($4::text[])) AS publisher_ok,
+	       (COALESCE(cardinality($5::int[]), 0) = 0
+	           OR extract(year FROM books.publish_date)::int / 10 * 10 = ANY($5::int[])) AS decade_ok,
+	       ($6 = '' OR ($6 = 'available') = (cc.copies_available > 0)) AS availability_ok
+	FROM books
+	INNER JOIN book_copy_counts cc ON cc.book_id = books.id
+	CROSS JOIN websearch_to_tsquery('english', $1) AS query
+	WHERE books.deleted_at IS NULL AND %s`
+
+// exactMatchSQL matches the query, written in the syntax of
+// websearch_to_tsquery (quoted phrases, OR, -exclusions), against the full
+// text of each book. An ISBN matches exactly, with or without hyphens, and an
+// empty query matches every book.
+const exactMatchSQL = `($1 = '' OR books.search_vector @@ query
+	OR replace(books.isbn, '-', '') = replace($1, '-', ''))`
+
+// fuzzyMatchSQL is the fallback for queries with no exact matches: titles and
+// authors containing words similar to the query, which catches most typos.
+// The similarity cut-off is set by fuzzyThresholdSQL.
+const fuzzyMatchSQL = `($1 <% books.title OR $1 <% books.author)`
+
+const fuzzyThresholdSQL = `SET LOCAL pg_trgm.word_similarity_threshold = 0.4`
+
+// Facets and totals count works, since that is what the results list.
+const searchFacetsSQL = `
+	WITH matched AS (%s)
+	SELECT 'genre', g, COUNT(DISTINCT work_id)
+	FROM matched CROSS JOIN unnest(genres) AS g
+	WHERE language_ok AND publisher_ok AND decade_ok AND availability_ok
+	GROUP BY g
+	UNION ALL
+	SELECT 'language', language, COUNT(DISTINCT work_id)
+	FROM matched
+	WHERE genre_ok AND publisher_ok AND decade_ok AND availability_ok
+	GROUP BY language
+	UNION ALL
+	SELECT 'publisher', publisher, COUNT(DISTINCT work_id)
+	FROM matched
+	WHERE genre_ok AND language_ok AND decade_ok AND availability_ok
+	GROUP BY publisher
+	UNION ALL
+	SELECT 'decade', decade::text, COUNT(DISTINCT work_id)
+	FROM matched
+	WHERE genre_ok AND language_ok AND publisher_ok AND availability_ok
+	GROUP BY decade
+	UNION ALL
+	SELECT 'availability',
+	       CASE WHEN copies_available > 0 THEN 'available' ELSE 'unavailable' END, COUNT(DISTINCT work_id)
+	FROM matched
+	WHERE genre_ok AND language_ok AND publisher_ok AND decade_ok
+	GROUP BY 2
+	ORDER BY 1, 3 DESC, 2`
+
+const searchCountSQL = `
+	WITH matched AS (%s)
+	SELECT COUNT(DISTINCT work_id) FROM matched
+	WHERE genre_ok AND language_ok AND publisher_ok AND decade_ok AND availability_ok`
+
+// suggestionSQL swaps each word of the query for the closest word in the
+// catalogue's vocabulary, leaving words with no close match as they are.
+const suggestionSQL = `
+	SELECT COALESCE(string_agg(COALESCE(best.term, w.word), ' ' ORDER BY w.n), '')
+	FROM unnest($1::text[]) WITH ORDINALITY AS w(word, n)
+	LEFT JOIN LATERAL (
+		SELECT term FROM search_terms
+		WHERE term % w.word
+		ORDER BY similarity(term, w.word) DESC, term
+		LIMIT 1
+	) best ON TRUE`
+
+// SearchResult is one page of search results. Fuzzy is set when nothing
+// matched the query exactly and the books are close matches instead.
+type SearchResult struct {
+	Books      []*Book       `json:"books"`
+	Facets     *SearchFacets `json:"facets"`
+	Metadata   Metadata      `json:"metadata"`
+	Fuzzy      bool          `json:"fuzzy"`
+	Suggestion string        `json:"suggestion,omitempty"`
+}
+
+func (f SearchFilters) args() []any {
+	decades := make([]int64, len(f.Decades))
+	for i, d := range f.Decades {
+		decades[i] = int64(d)
+	}
+
+	// An ISBN in either form is looked up by the canonical form it is stored
+	// under.
+	query := strings.TrimSpace(f.Query)
+	if canonical, err := isbn.To13(query); err == nil {
+		query = canonical
+	}
+
+	return []any{
+		query,
+		pq.Array(f.Genres),
+		pq.Array(f.Languages),
+		pq.Array(f.Publishers),
+		pq.Array(decades),
+		f.Availability,
+	}
+}
+
+// searchSortKeys are the keyset orderings for each search sort. Relevance is
+// cast to float8 so its text form round-trips exactly through a cursor.
+var searchSortKeys = map[string][]sortKey{
+	"Relevance": {
+		{expr: "ts_rank_cd(search_vector, query)::float8", cast: "float8", desc: true},
+		{expr: "title", cast: "text"},
+		{expr: "id", cast: "bigint"},
+	},
+	"Title (A-Z)": {
+		{expr: "title", cast: "text"},
+		{expr: "id", cast: "bigint"},
+	},
+	"Title (Z-A)": {
+		{expr: "title", cast: "text", desc: true},
+		{expr: "id", cast: "bigint"},
+	},
+	"Author (A-Z)": {
+		{expr: "author", cast: "text"},
+		{expr: "id", cast: "bigint"},
+	},
+	"Newest First": {
+		{expr: "publish_date", cast: "date", desc: true},
+		{expr: "id", cast: "bigint"},
+	},
+}
+
+// fuzzyRelevanceKeys ranks fuzzy matches by how closely the title or author
+// resembles the query.
+var fuzzyRelevanceKeys = []sortKey{
+	{expr: "GREATEST(word_similarity($1, title), word_similarity($1, author))::float8", cast: "float8", desc: true},
+	{expr: "title", cast: "text"},
+	{expr: "id", cast: "bigint"},
+}
+
+// Search returns a page of the books matching the filters along with facet
+// counts for refining the results further. When the query matches nothing
+// exactly, close matches on title and author are returned instead, along with
+// a corrected query to suggest.
+//
+// Results are collapsed to one book per work: the matching edition with
+// copies on the shelf, or else the newest, with Editions set to how many of
+// the work's editions matched.
+func (m BookModel) Search(f SearchFilters, p Pagination) (*SearchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q, err := ParseQuery(f.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Plain text goes to websearch_to_tsquery as typed. An advanced query is
+	// compiled to its own condition, with only its words used for ranking.
+	filterArgs := f.args()
+	cond := exactMatchSQL
+	if q.Advanced {
+		filterArgs[0] = q.Text
+		cond = q.SQL(&filterArgs)
+	}
+
+	result := &SearchResult{}
+	match := fmt.Sprintf(searchMatchSQL, cond)
+
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(searchCountSQL, match), filterArgs...).
+		Scan(&result.Metadata.TotalRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.TrimSpace(f.Query)
+	if result.Metadata.TotalRecords == 0 && query != "" && !q.Advanced {
+		result.Suggestion, err = suggestQuery(ctx, tx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, fuzzyThresholdSQL)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Fuzzy = true
+		match = fmt.Sprintf(searchMatchSQL, fuzzyMatchSQL)
+
+		err = tx.QueryRowContext(ctx, fmt.Sprintf(searchCountSQL, match), filterArgs...).
+			Scan(&result.Metadata.TotalRecords)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort := f.Sort
+	if sort == "" {
+		sort = "Relevance"
+	}
+	keys := searchSortKeys[sort]
+	if result.Fuzzy {
+		// Fuzzy cursors are kept apart from exact ones so a page of close
+		// matches can't be continued as an exact search or vice versa.
+		if sort == "Relevance" {
+			keys = fuzzyRelevanceKeys
+		}
+		sort += "/fuzzy"
+	}
+
+	c, err := decodeCursor(p.Cursor, sort, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	args := append(slices.Clone(filterArgs), headlineOptions)
+	headline := len(args)
+	position, where, orderBy := keysetSQL(keys, c, &args)
+	args = append(args, p.Limit+1)
+
+	stmt := fmt.Sprintf(`
+		WITH matched AS (%s),
+		editions AS (
+			SELECT matched.*, COUNT(*) OVER (PARTITION BY work_id) AS editions
+			FROM matched
+			WHERE genre_ok AND language_ok AND publisher_ok AND decade_ok AND availability_ok
+		),
+		collapsed AS (
+			SELECT DISTINCT ON (work_id) *
+			FROM editions
+			ORDER BY work_id, copies_available > 0 DESC, publish_date DESC, id
+		)
+		SELECT id, work_id, title, author, publish_date, isbn, description, cover_image, genres, pages,
+		       language, publisher, copies_total, copies_available, version, editions,
+		       CASE WHEN $1 = '' THEN '' ELSE ts_headline('english', description, query, $%d) END,
+		       %s
+		FROM collapsed
+		WHERE %s
+		%s
+		LIMIT $%d`, match, headline, position, where, orderBy, len(args))
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []*Book
+	var positions [][]string
+	for rows.Next() {
+		var b Book
+		var genres, keys []string
+		if err := rows.Scan(
+			&b.ID, &b.WorkID, &b.Title, &b.Author, &b.PublishDate, &b.ISBN, &b.Description,
+			&b.CoverImage, pq.Array(&genres), &b.Pages, &b.Language, &b.Publisher,
+			&b.CopiesTotal, &b.CopiesAvailable, &b.Version, &b.Editions, &b.Snippet, pq.Array(&keys),
+		); err != nil {
+			return nil, err
+		}
+		b.Genres = genres
+		books = append(books, &b)
+		positions = append(positions, keys)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Facets, err = searchFacets(ctx, tx, match, filterArgs, f)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Books, result.Metadata = pageOf(books, positions, sort, p, c, result.Metadata.TotalRecords)
+	return result, nil
+}
+
+// ExportSearch calls fn with every book matching the filters, falling back
+// to close matches the way Search does. Unlike Search, results are neither
+// paged nor collapsed into works: each matching edition is exported, in
+// title order.
+func (m BookModel) ExportSearch(f SearchFilters, fn func(*Book) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q, err := ParseQuery(f.Query)
+	if err != nil {
+		return err
+	}
+
+	args := f.args()
+	cond := exactMatchSQL
+	if q.Advanced {
+		args[0] = q.Text
+		cond = q.SQL(&args)
+	}
+	match := fmt.Sprintf(searchMatchSQL, cond)
+
+	if strings.TrimSpace(f.Query) != "" && !q.Advanced {
+		var total int
+		err = tx.QueryRowContext(ctx, fmt.Sprintf(searchCountSQL, match), args...).Scan(&total)
+		if err != nil {
+			return err
+		}
+
+		if total == 0 {
+			_, err = tx.ExecContext(ctx, fuzzyThresholdSQL)
+			if err != nil {
+				return err
+			}
+			match = fmt.Sprintf(searchMatchSQL, fuzzyMatchSQL)
+		}
+	}
+
+	stmt := fmt.Sprintf(`
+		WITH matched AS (%s)
+		SELECT id, work_id, title, author, publish_date, isbn, description, cover_image, genres, pages,
+		       language, publisher, item_type, copies_total, copies_available, version, %s
+		FROM matched
+		WHERE genre_ok AND language_ok AND publisher_ok AND decade_ok AND availability_ok
+		ORDER BY title, id`, match, contributorsJSONSQL("matched.id"))
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	return exportBooks(rows, fn)
+}
+
+// suggestQuery returns the query with misspelt words corrected, or "" when
+// every word is already in the catalogue's vocabulary.
+func suggestQuery(ctx context.Context, tx *sql.Tx, query string) (string, error) {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	var suggestion string
+	err := tx.QueryRowContext(ctx, suggestionSQL, pq.Array(words)).Scan(&suggestion)
+	if err != nil {
+		return "", err
+	}
+
+	if suggestion == strings.Join(words, " ") {
+		return "", nil
+	}
+	return suggestion, nil
+}
+
+// Suggestion is an autocomplete entry for the search box. Kind is "title",
+// "author" or "isbn"; authors link to their contributor page and the rest to
+// the book.
+type Suggestion struct {
+	Kind     string `json:"kind"`
+	Value    string `json:"value"`
+	BookID   int64  `json:"book_id,omitempty"`
+	AuthorID int64  `json:"author_id,omitempty"`
+}
+
+// suggestSQL completes a partly typed query. Titles and authors starting with
+// it come first, then those containing a word like it; ISBNs are matched by
+// prefix, ignoring hyphens.
+const suggestSQL = `
+	SELECT kind, value, book_id, author_id
+	FROM (
+		(SELECT 'title' AS kind, title AS value, id AS book_id, 0::bigint AS author_id,
+		        CASE WHEN lower(title) LIKE $2 THEN 2 ELSE word_similarity($1, title) END AS score
+		 FROM books
+		 WHERE (lower(title) LIKE $2 OR $1 <% title) AND deleted_at IS NULL
+		 ORDER BY score DESC, title
+		 LIMIT $4)
+		UNION ALL
+		(SELECT 'author', c.name, 0::bigint, c.id,
+		        CASE WHEN lower(c.name) LIKE $2 THEN 2 ELSE word_similarity($1, c.name) END AS score
+		 FROM contributors c
+		 WHERE (lower(c.name) LIKE $2 OR $1 <% c.name)
+		   AND EXISTS (
+		       SELECT 1 FROM book_contributors bc
+		       INNER JOIN books b ON b.id = bc.book_id
+		       WHERE bc.contributor_id = c.id AND bc.role = 'author' AND b.deleted_at IS NULL)
+		 ORDER BY score DESC, c.name
+		 LIMIT $4)
+		UNION ALL
+		(SELECT 'isbn', isbn, id, 0::bigint, 2
+		 FROM books
+		 WHERE $3 <> '' AND replace(isbn, '-', '') LIKE $3 AND deleted_at IS NULL
+		 ORDER BY isbn
+		 LIMIT $4)
+	) s
+	ORDER BY score DESC, value
+	LIMIT $4`
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Suggest returns up to limit titles, authors and ISBNs matching a partly
+// typed query.
+func (m BookModel) Suggest(prefix string, limit int) ([]*Suggestion, error) {
+	prefix = strings.TrimSpace(prefix)
+
+	isbnPrefix := ""
+	if digits := strings.ReplaceAll(prefix, "-", ""); strings.ContainsAny(digits, "0123456789") &&
+		strings.Trim(digits, "0123456789Xx") == "" {
+		isbnPrefix = likeEscaper.Replace(digits) + "%"
+	}
+
+	args := []any{prefix, likeEscaper.Replace(strings.ToLower(prefix)) + "%", isbnPrefix, limit}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, suggestSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+	for rows.Next() {
+		var s Suggestion
+		if err := rows.Scan(&s.Kind, &s.Value, &s.BookID, &s.AuthorID); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// RefreshSearchTerms rebuilds the vocabulary used for query suggestions.
+func (m BookModel) RefreshSearchTerms() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY search_terms`)
+	return err
+}
+
+func searchFacets(ctx context.Context, tx *sql.Tx, match string, args []any, f SearchFilters) (*SearchFacets, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(searchFacetsSQL, match), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	decades := make([]string, len(f.Decades))
+	for i, d := range f.Decades {
+		decades[i] = strconv.Itoa(d)
+	}
+	var availability []string
+	if f.Availability != "" {
+		availability = []string{f.Availability}
+	}
+
+	facets := &SearchFacets{}
+	fields := map[string]*[]FacetBucket{
+		"genre":        &facets.Genres,
+		"language":     &facets.Languages,
+		"publisher":    &facets.Publishers,
+		"decade":       &facets.Decades,
+		"availability": &facets.Availability,
+	}
+	selected := map[string][]string{
+		"genre":        f.Genres,
+		"language":     f.Languages,
+		"publisher":    f.Publishers,
+		"decade":       decades,
+		"availability": availability,
+	}
+
+	for rows.Next() {
+		var kind string
+		var bucket FacetBucket
+		if err := rows.Scan(&kind, &bucket.Value, &bucket.Count); err != nil {
+			return nil, err
+		}
+		bucket.Selected = slices.Contains(selected[kind], bucket.Value)
+		*fields[kind] = append(*fields[kind], bucket)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Keep selected values that no longer match anything so they can still be
+	// unticked.
+	for kind, values := range selected {
+		for _, value := range values {
+			found := slices.ContainsFunc(*fields[kind], func(b FacetBucket) bool {
+				return b.Value == value
+			})
+			if !found {
+				*fields[kind] = append(*fields[kind], FacetBucket{Value: value, Selected: true})
+			}
+		}
+	}
+
+	slices.SortFunc(facets.Decades, func(a, b FacetBucket) int {
+		x, _ := strconv.Atoi(a.Value)
+		y, _ := strconv.Atoi(b.Value)
+		return x - y
+	})
+
+	return facets, nil
+}
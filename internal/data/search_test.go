@@ -0,0 +1,183 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestDB connects to the database named by LibraryMS_TEST_DB_DSN and
+// applies every up migration in a schema of the test's own, which is dropped
+// when the test ends. The citext extension must already be installed. Tests
+// calling it are skipped when the variable is not set.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("LibraryMS_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("LibraryMS_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// search_path is per connection, so keep to one.
+	db.SetMaxOpenConns(1)
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	_, err = db.Exec(fmt.Sprintf("CREATE SCHEMA %s; SET search_path TO %s, public", schema, schema))
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, err := db.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+		if err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	files, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(migration))
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(file), err)
+		}
+	}
+
+	return db
+}
+
+// isbn13 returns a valid ISBN-13 numbered n.
+func isbn13(n int) string {
+	digits := fmt.Sprintf("978000%06d", n)
+	sum := 0
+	for i, d := range digits {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(d-'0') * weight
+	}
+	return fmt.Sprintf("%s%d", digits, (10-sum%10)%10)
+}
+
+func seedCatalogue(t *testing.T, books BookModel) {
+	t.Helper()
+
+	catalogue := []struct{ title, authors string }{
+		{"Dune", "Frank Herbert"},
+		{"Dune Messiah", "Frank Herbert"},
+		{"Neuromancer", "William Gibson"},
+		{"The Necromancer", "Jane Doe"},
+		{"A Wizard of Earthsea", "Ursula K. Le Guin"},
+		{"The Left Hand of Darkness", "Ursula K. Le Guin"},
+	}
+
+	for i, c := range catalogue {
+		err := books.Insert(&Book{
+			Title:        c.title,
+			Contributors: ParseContributors(c.authors),
+			PublishDate:  time.Date(1960+i, 1, 1, 0, 0, 0, 0, time.UTC),
+			ISBN:         isbn13(i + 1),
+			Genres:       []string{},
+			Language:     "English",
+			CopiesTotal:  1,
+		}, nil)
+		if err != nil {
+			t.Fatalf("insert %q: %v", c.title, err)
+		}
+	}
+
+	err := books.RefreshSearchTerms()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchFuzzyFallback(t *testing.T) {
+	books := BookModel{DB: newTestDB(t)}
+	seedCatalogue(t, books)
+
+	tests := []struct {
+		query      string
+		fuzzy      bool
+		titles     []string
+		suggestion string
+	}{
+		{"dune", false, []string{"Dune", "Dune Messiah"}, ""},
+		// Closer matches come first.
+		{"neuromanser", true, []string{"Neuromancer", "The Necromancer"}, "neuromancer"},
+		// Equally close matches are in title order.
+		{"herbet", true, []string{"Dune", "Dune Messiah"}, "herbert"},
+		// Each word is corrected on its own, and short words are kept.
+		{"wizzard of earthsee", true, []string{"A Wizard of Earthsea"}, "wizard of earthsea"},
+		{"zzyzx", true, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			result, err := books.Search(SearchFilters{Query: tt.query}, Pagination{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var titles []string
+			for _, b := range result.Books {
+				titles = append(titles, b.Title)
+			}
+			if !reflect.DeepEqual(titles, tt.titles) {
+				t.Errorf("titles = %q, want %q", titles, tt.titles)
+			}
+			if result.Fuzzy != tt.fuzzy {
+				t.Errorf("fuzzy = %v, want %v", result.Fuzzy, tt.fuzzy)
+			}
+			if result.Suggestion != tt.suggestion {
+				t.Errorf("suggestion = %q, want %q", result.Suggestion, tt.suggestion)
+			}
+		})
+	}
+}
+
+func TestSearchFuzzyPages(t *testing.T) {
+	books := BookModel{DB: newTestDB(t)}
+	seedCatalogue(t, books)
+
+	f := SearchFilters{Query: "neuromanser"}
+	first, err := books.Search(f, Pagination{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Books) != 1 || first.Books[0].Title != "Neuromancer" || first.Metadata.NextCursor == "" {
+		t.Fatalf("first page = %+v", first)
+	}
+
+	second, err := books.Search(f, Pagination{Limit: 1, Cursor: first.Metadata.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Books) != 1 || second.Books[0].Title != "The Necromancer" {
+		t.Fatalf("second page = %+v", second)
+	}
+
+	_, err = books.Search(SearchFilters{Query: "dune"}, Pagination{Limit: 1, Cursor: first.Metadata.NextCursor})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("exact search with a fuzzy cursor: err = %v, want ErrInvalidCursor", err)
+	}
+}
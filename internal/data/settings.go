@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const SettingActivationRequired = "activation_required"
+
+// Values for SettingActivationRequired. Requiring activation for login
+// implies requiring it for borrowing as well.
+const (
+	ActivationRequiredNone      = "none"
+	ActivationRequiredBorrowing = "borrowing"
+	ActivationRequiredLogin     = "login"
+)
+
+type SettingModel struct {
+	DB *sql.DB
+}
+
+func (m SettingModel) Get(key string) (string, error) {
+	query := `SELECT value FROM settings WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var value string
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&value)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// GetInt returns the setting parsed as an integer, or fallback when the
+// setting is missing or not a number.
+func (m SettingModel) GetInt(key string, fallback int) (int, error) {
+	value, err := m.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			return fallback, nil
+		}
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback, nil
+	}
+	return n, nil
+}
+
+// SetAll saves the settings together. The event, if any, records the values
+// that changed.
+func (m SettingModel) SetAll(values map[string]string, ev *AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT key, value FROM settings WHERE key = ANY($1) FOR UPDATE`, pq.Array(keys))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	previous := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		previous[key] = value
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO settings (key, value)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value`
+
+	for key, value := range values {
+		_, err = tx.ExecContext(ctx, query, key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ev != nil {
+		ev.Before = previous
+		ev.After = values
+	}
+	err = insertAudit(ctx, tx, ev, 0)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
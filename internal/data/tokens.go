@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/0xrinful/LibraryMS/internal/validator"
+)
+
+const (
+	PurposeAPI           = "api"
+	PurposeActivation    = "activation"
+	PurposePasswordReset = "password-reset"
+)
+
+const (
+	ScopeBooksRead        = "books:read"
+	ScopeBooksWrite       = "books:write"
+	ScopeCirculationRead  = "circulation:read"
+	ScopeCirculationWrite = "circulation:write"
+	ScopeAdminMembers     = "admin:members"
+)
+
+var (
+	MemberScopes = []string{ScopeBooksRead, ScopeCirculationRead, ScopeCirculationWrite}
+	AdminScopes  = []string{
+		ScopeBooksRead,
+		ScopeBooksWrite,
+		ScopeCirculationRead,
+		ScopeCirculationWrite,
+		ScopeAdminMembers,
+	}
+)
+
+// ScopesForRole returns the scopes a user with the given role may grant to
+// their own API tokens.
+func ScopesForRole(role string) []string {
+	if role == "admin" {
+		return AdminScopes
+	}
+	return MemberScopes
+}
+
+type Token struct {
+	ID         int64      `json:"id"`
+	Plaintext  string     `json:"token,omitempty"`
+	Hash       []byte     `json:"-"`
+	UserID     int64      `json:"-"`
+	Purpose    string     `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Expiry     *time.Time `json:"expiry,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (t *Token) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+func generateToken(userID int64, ttl time.Duration, purpose string) *Token {
+	token := &Token{
+		Plaintext: rand.Text(),
+		UserID:    userID,
+		Purpose:   purpose,
+		Scopes:    []string{},
+		CreatedAt: time.Now(),
+	}
+
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl)
+		token.Expiry = &expiry
+	}
+
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token
+}
+
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+func ValidateAPIToken(v *validator.Validator, token *Token, allowed []string) {
+	v.Check(validator.NotBlank(token.Name), "name", "Name is required")
+	v.Check(len(token.Name) <= 100, "name", "Name must not exceed 100 characters")
+
+	v.Check(len(token.Scopes) > 0, "scopes", "At least one scope must be selected")
+	v.Check(validator.Unique(token.Scopes), "scopes", "Scopes must not contain duplicates")
+	for _, scope := range token.Scopes {
+		v.Check(slices.Contains(allowed, scope), "scopes", "Scope "+scope+" cannot be granted")
+	}
+}
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+func (m TokenModel) New(userID int64, ttl time.Duration, purpose string) (*Token, error) {
+	token := generateToken(userID, ttl, purpose)
+
+	err := m.Insert(token)
+	return token, err
+}
+
+func (m TokenModel) NewAPIToken(
+	userID int64,
+	name string,
+	scopes []string,
+	ttl time.Duration,
+) (*Token, error) {
+	token := generateToken(userID, ttl, PurposeAPI)
+	token.Name = name
+	token.Scopes = scopes
+
+	err := m.Insert(token)
+	return token, err
+}
+
+func (m TokenModel) Insert(token *Token) error {
+	query := `
+		INSERT INTO tokens (hash, user_id, purpose, name, scopes, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		token.Hash,
+		token.UserID,
+		token.Purpose,
+		token.Name,
+		pq.Array(token.Scopes),
+		token.Expiry,
+	}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+// Get looks up an unexpired token by its plaintext value and records that it
+// has just been used.
+func (m TokenModel) Get(purpose, tokenPlaintext string) (*Token, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE tokens
+		SET last_used_at = NOW()
+		WHERE hash = $1
+		  AND purpose = $2
+		  AND (expiry IS NULL OR expiry > NOW())
+		RETURNING id, hash, user_id, purpose, name, scopes, created_at, expiry, last_used_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token Token
+	err := m.DB.QueryRowContext(ctx, query, hash[:], purpose).Scan(
+		&token.ID,
+		&token.Hash,
+		&token.UserID,
+		&token.Purpose,
+		&token.Name,
+		pq.Array(&token.Scopes),
+		&token.CreatedAt,
+		&token.Expiry,
+		&token.LastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+func (m TokenModel) GetAllForUser(userID int64, purpose string) ([]*Token, error) {
+	query := `
+		SELECT id, name, scopes, created_at, expiry, last_used_at
+		FROM tokens
+		WHERE user_id = $1 AND purpose = $2
+		ORDER BY created_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, purpose)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*Token
+	for rows.Next() {
+		t := Token{UserID: userID, Purpose: purpose}
+		if err := rows.Scan(
+			&t.ID, &t.Name, pq.Array(&t.Scopes), &t.CreatedAt, &t.Expiry, &t.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (m TokenModel) Delete(id, userID int64) error {
+	query := `DELETE FROM tokens WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m TokenModel) DeleteAllForUser(purpose string, userID int64) error {
+	query := `DELETE FROM tokens WHERE purpose = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, purpose, userID)
+	return err
+}
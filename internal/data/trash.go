@@ -0,0 +1,355 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SettingTrashRetentionDays is how long deleted books and members stay in
+// the trash before they are purged for good.
+const SettingTrashRetentionDays = "trash_retention_days"
+
+// purgeTimeout covers a purge of everything that came due at once, along
+// with its loan history.
+const purgeTimeout = 30 * time.Second
+
+// checkInCatalogue returns ErrRecordNotFound for a book in the trash. Its
+// copies can still be returned, but not borrowed or held.
+func checkInCatalogue(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	var deleted bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT deleted_at IS NOT NULL FROM books WHERE id = $1
+	`, bookID).Scan(&deleted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	if deleted {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetDeleted lists the books in the trash, most recently deleted first.
+func (m BookModel) GetDeleted() ([]*Book, error) {
+	query := `
+		SELECT id, work_id, title, author, isbn, deleted_at
+		FROM books
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []*Book
+	for rows.Next() {
+		var b Book
+		if err := rows.Scan(&b.ID, &b.WorkID, &b.Title, &b.Author, &b.ISBN, &b.DeletedAt); err != nil {
+			return nil, err
+		}
+		books = append(books, &b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// Restore takes the book out of the trash. Its copies on the shelf go back
+// to serving the work's hold queue.
+func (m BookModel) Restore(id int, ev *AuditEvent) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockBook(ctx, tx, int64(id))
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE books
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+	`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = refreshHolds(ctx, tx, int64(id))
+	if err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, ev, int64(id))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Purge permanently deletes the books that were moved to the trash before
+// the cutoff, along with their copies, holds and loan history, and returns how
+// many there were. A book with copies still out on loan waits until they are
+// returned.
+func (m BookModel) Purge(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var ids []int64
+	err = tx.QueryRowContext(ctx, `
+		WITH due AS (
+			SELECT id FROM books b
+			WHERE b.deleted_at < $1
+			  AND NOT EXISTS (
+			      SELECT 1 FROM borrow_records br
+			      WHERE br.book_id = b.id AND br.returned_at IS NULL)
+			FOR UPDATE
+		)
+		SELECT COALESCE(array_agg(id), '{}') FROM due
+	`, before).Scan(pq.Array(&ids))
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var contributors []int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(array_agg(DISTINCT contributor_id), '{}')
+		FROM book_contributors WHERE book_id = ANY($1)
+	`, pq.Array(ids)).Scan(pq.Array(&contributors))
+	if err != nil {
+		return 0, err
+	}
+
+	// Renewals go with their loans; fines stay on the member's account.
+	_, err = tx.ExecContext(ctx, `DELETE FROM borrow_records WHERE book_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM holds WHERE book_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM copies WHERE book_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	var works []int64
+	err = tx.QueryRowContext(ctx, `
+		WITH deleted AS (
+			DELETE FROM books WHERE id = ANY($1) RETURNING work_id
+		)
+		SELECT COALESCE(array_agg(DISTINCT work_id), '{}') FROM deleted
+	`, pq.Array(ids)).Scan(pq.Array(&works))
+	if err != nil {
+		return 0, err
+	}
+
+	err = pruneContributors(ctx, tx, contributors)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, workID := range works {
+		err = pruneWork(ctx, tx, workID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// GetDeleted lists the members in the trash, most recently deleted first.
+func (m UserModel) GetDeleted() ([]*User, error) {
+	query := `
+		SELECT id, created_at, name, email, activated, role, deleted_at
+		FROM users
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(
+			&u.ID, &u.CreatedAt, &u.Name, &u.Email, &u.Activated, &u.Role, &u.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Restore takes the member out of the trash. They sign in again with their
+// old password; holds cancelled when they were deleted stay cancelled. It
+// returns ErrDuplicateEmail if someone has since signed up with their email
+// address.
+func (m UserModel) Restore(id int64, ev *AuditEvent) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE users
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = insertAudit(ctx, tx, ev, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Purge permanently deletes the members who were moved to the trash before
+// the cutoff, along with their loan history, holds and settled fine ledger,
+// and returns how many there were. A member with books still out on loan or
+// a balance still owing waits until they are returned and paid or waived.
+func (m UserModel) Purge(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var ids []int64
+	err = tx.QueryRowContext(ctx, `
+		WITH due AS (
+			SELECT id FROM users u
+			WHERE u.deleted_at < $1
+			  AND NOT EXISTS (
+			      SELECT 1 FROM borrow_records br
+			      WHERE br.user_id = u.id AND br.returned_at IS NULL)
+			  AND (SELECT COALESCE(SUM(CASE kind WHEN 'charge' THEN amount_cents ELSE -amount_cents END), 0)
+			       FROM fine_transactions WHERE user_id = u.id) = 0
+			FOR UPDATE
+		)
+		SELECT COALESCE(array_agg(id), '{}') FROM due
+	`, before).Scan(pq.Array(&ids))
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM fine_transactions WHERE user_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM holds WHERE user_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM borrow_records WHERE user_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -2,6 +2,7 @@ package data
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"time"
@@ -11,18 +12,28 @@ import (
 	"github.com/0xrinful/LibraryMS/internal/validator"
 )
 
-var ErrDuplicateEmail = errors.New("models: duplicate email")
+var (
+	ErrDuplicateEmail = errors.New("models: duplicate email")
+	ErrMemberHasLoans = errors.New("models: member has books on loan")
+)
 
 type User struct {
-	ID        int64
-	CreatedAt time.Time
-	Name      string
-	Email     string
-	Password  password
-	Activated bool
-	AvatarUrl *string
-	Role      string
-	Version   int
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  password  `json:"-"`
+	Activated bool      `json:"activated"`
+	AvatarUrl *string   `json:"avatar_url,omitempty"`
+	Role      string    `json:"role"`
+	Tier      *string   `json:"tier,omitempty"`
+	Version   int       `json:"-"`
+
+	// SessionGeneration is bumped to sign the user out of every session.
+	SessionGeneration int `json:"-"`
+
+	// DeletedAt is when the member was moved to the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type password struct {
@@ -60,14 +71,20 @@ type UserModel struct {
 
 func (m UserModel) Insert(user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash) 
-		VALUES ($1, $2, $3)`
+		INSERT INTO users (name, email, password_hash, activated) 
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, role, version`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{user.Name, user.Email, user.Password.hash}
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Role,
+		&user.Version,
+	)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -81,9 +98,10 @@ func (m UserModel) Insert(user *User) error {
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, avatar_url, role 
+		SELECT id, created_at, name, email, password_hash, activated, avatar_url, role, tier, version,
+		       session_generation
 		FROM users
-		WHERE email = $1`
+		WHERE email = $1 AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -96,8 +114,52 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
+		&user.Activated,
 		&user.AvatarUrl,
 		&user.Role,
+		&user.Tier,
+		&user.Version,
+		&user.SessionGeneration,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func (m UserModel) GetForToken(purpose, tokenPlaintext string) (*User, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.role, u.tier,
+		       u.version
+		FROM users u
+		INNER JOIN tokens t ON t.user_id = u.id
+		WHERE t.hash = $1
+		  AND t.purpose = $2
+		  AND (t.expiry IS NULL OR t.expiry > NOW())
+		  AND u.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], purpose).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Role,
+		&user.Tier,
+		&user.Version,
 	)
 	if err != nil {
 		switch {
@@ -107,18 +169,84 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 			return nil, err
 		}
 	}
+
 	return &user, nil
 }
 
+// ResetPassword sets a new password for the owner of a password reset token,
+// deletes all of their reset tokens and signs them out of every session. The
+// token is claimed and the password saved in one transaction, so a token can
+// only be used once even by requests racing each other.
+func (m UserModel) ResetPassword(tokenPlaintext, plaintextPassword string) error {
+	var pw password
+	err := pw.Set(plaintextPassword)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM tokens
+		WHERE hash = $1
+		  AND purpose = $2
+		  AND (expiry IS NULL OR expiry > NOW())
+		RETURNING user_id
+	`, hash[:], PurposePasswordReset).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET password_hash = $1, version = version + 1, session_generation = session_generation + 1
+		WHERE id = $2 AND deleted_at IS NULL
+	`, pw.hash, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM tokens WHERE user_id = $1 AND purpose = $2`, userID, PurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (m UserModel) Get(id int64) (*User, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
-		SELECT id, created_at, name, email, password_hash, role
+		SELECT id, created_at, name, email, password_hash, activated, role, tier, version,
+		       session_generation
 		FROM users
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -131,7 +259,11 @@ func (m UserModel) Get(id int64) (*User, error) {
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
+		&user.Activated,
 		&user.Role,
+		&user.Tier,
+		&user.Version,
+		&user.SessionGeneration,
 	)
 	if err != nil {
 		switch {
@@ -156,8 +288,34 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 }
 
+func ValidateMember(v *validator.Validator, user *User) {
+	v.Check(validator.NotBlank(user.Name), "name", "Name is required")
+	v.Check(len(user.Name) >= 3, "name", "Name must be at least 3 characters")
+	v.Check(len(user.Name) <= 500, "name", "Name must not exceed 500 characters")
+
+	v.Check(validator.NotBlank(user.Email), "email", "Email is required")
+	v.Check(validator.Matches(user.Email, validator.EmailRX), "email", "Invalid email format")
+
+	v.Check(
+		user.Role == "user" || user.Role == "admin",
+		"role",
+		"Role must be 'user' or 'admin'",
+	)
+
+	if user.Tier != nil {
+		ValidateTier(v, *user.Tier)
+	}
+}
+
+// ValidateTier checks a member tier, such as staff or student, which
+// circulation policies can be scoped to.
+func ValidateTier(v *validator.Validator, tier string) {
+	v.Check(validator.NotBlank(tier), "tier", "Tier must not be blank")
+	v.Check(len(tier) <= 50, "tier", "Tier must not exceed 50 characters")
+}
+
 func (m UserModel) Count() (int, error) {
-	query := `SELECT COUNT(*) FROM users`
+	query := `SELECT COUNT(*) FROM users WHERE deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -172,8 +330,9 @@ func (m UserModel) Count() (int, error) {
 
 func (m UserModel) GetAll() ([]*User, error) {
 	query := `
-		SELECT id, created_at, name, email, role
+		SELECT id, created_at, name, email, activated, role, tier
 		FROM users
+		WHERE deleted_at IS NULL
 		ORDER BY created_at DESC`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -188,7 +347,7 @@ func (m UserModel) GetAll() ([]*User, error) {
 	var users []*User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.CreatedAt, &u.Name, &u.Email, &u.Role); err != nil {
+		if err := rows.Scan(&u.ID, &u.CreatedAt, &u.Name, &u.Email, &u.Activated, &u.Role, &u.Tier); err != nil {
 			return nil, err
 		}
 		users = append(users, &u)
@@ -201,18 +360,34 @@ func (m UserModel) GetAll() ([]*User, error) {
 	return users, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(user *User, ev *AuditEvent) error {
 	query := `
 		UPDATE users 
-		SET name = $1, email = $2, role = $3, version = version + 1
-		WHERE id = $4
+		SET name = $1, email = $2, role = $3, tier = $4, activated = $5, password_hash = $6,
+		    version = version + 1
+		WHERE id = $7 AND deleted_at IS NULL
 		RETURNING version`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{user.Name, user.Email, user.Role, user.ID}
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Role,
+		user.Tier,
+		user.Activated,
+		user.Password.hash,
+		user.ID,
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -223,20 +398,53 @@ func (m UserModel) Update(user *User) error {
 			return err
 		}
 	}
-	return nil
+
+	err = insertAudit(ctx, tx, ev, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m UserModel) Delete(id int64) error {
+// Delete moves the member to the trash, which signs them out and cancels
+// their active holds. Their borrowing history is kept until they are purged.
+// A member with books still on loan can't be deleted.
+func (m UserModel) Delete(id int64, ev *AuditEvent) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `DELETE FROM users WHERE id = $1`
-
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockUser(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	var loans bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM borrow_records WHERE user_id = $1 AND returned_at IS NULL)
+	`, id).Scan(&loans)
+	if err != nil {
+		return err
+	}
+	if loans {
+		return ErrMemberHasLoans
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id)
 	if err != nil {
 		return err
 	}
@@ -250,5 +458,20 @@ func (m UserModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	err = cancelActiveHolds(ctx, tx, "user_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, ev, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
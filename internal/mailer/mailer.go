@@ -0,0 +1,88 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"time"
+
+	ttemplate "text/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Sender delivers a rendered message. Swapping the Sender is how the
+// application chooses between real SMTP delivery and the file and in-memory
+// senders used during development and tests.
+type Sender interface {
+	Send(msg *Message) error
+}
+
+type Mailer struct {
+	sender Sender
+	from   string
+}
+
+func New(sender Sender, from string) *Mailer {
+	return &Mailer{
+		sender: sender,
+		from:   from,
+	}
+}
+
+func (m *Mailer) Send(recipient, templateFile string, data any) error {
+	tmpl, err := ttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return err
+	}
+
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return err
+	}
+
+	msg := &Message{
+		From:      m.from,
+		To:        recipient,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}
+
+	for i := 1; i <= 3; i++ {
+		err = m.sender.Send(msg)
+		if err == nil {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return err
+}
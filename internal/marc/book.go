@@ -0,0 +1,355 @@
+package marc
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+	"github.com/0xrinful/LibraryMS/internal/validator/isbn"
+)
+
+// relatorCodes are the MARC relator codes for the contributor roles, used in
+// subfield 4 of 100 and 700 fields.
+var relatorCodes = map[string]string{
+	data.ContributorAuthor:      "aut",
+	data.ContributorEditor:      "edt",
+	data.ContributorTranslator:  "trl",
+	data.ContributorIllustrator: "ill",
+}
+
+// languageCodes are the MARC codes of the languages the catalogue most often
+// holds. Others are exported as "und" and imported as the default language.
+var languageCodes = map[string]string{
+	"Arabic":     "ara",
+	"Chinese":    "chi",
+	"Dutch":      "dut",
+	"English":    "eng",
+	"French":     "fre",
+	"German":     "ger",
+	"Greek":      "gre",
+	"Italian":    "ita",
+	"Japanese":   "jpn",
+	"Korean":     "kor",
+	"Latin":      "lat",
+	"Polish":     "pol",
+	"Portuguese": "por",
+	"Russian":    "rus",
+	"Spanish":    "spa",
+	"Swedish":    "swe",
+}
+
+var (
+	yearRE  = regexp.MustCompile(`(?:^|\D)(1\d{3}|20\d{2})(?:\D|$)`)
+	pagesRE = regexp.MustCompile(`(\d+)\s*(?:p\b|pages?\b)`)
+)
+
+// ToBook reads the catalogue fields of a record:
+//
+//	020 $a        ISBN, the first valid one
+//	100, 700      contributors, with the role from $4 or $e
+//	245 $a $b     title, and subtitle after a colon
+//	264, 260 $b   publisher
+//	264, 260 $c   publication year, or else 008/07-10
+//	300 $a        pages
+//	520 $a        description
+//	650 $a        genres
+//	008/35-37     language
+//
+// Trailing ISBD punctuation is removed, and names entered surname first are
+// turned around. Fields the record doesn't have are left blank.
+func ToBook(rec *Record) *data.Book {
+	book := &data.Book{Genres: []string{}}
+
+	for _, f := range rec.DataFields("020") {
+		number := strings.Trim(firstWord(f.Subfield('a')), "()")
+		if number == "" {
+			continue
+		}
+		if book.ISBN == "" || (!isbn.Valid(book.ISBN) && isbn.Valid(number)) {
+			book.ISBN = number
+		}
+	}
+
+	if fields := rec.DataFields("245"); len(fields) > 0 {
+		book.Title = trimPunctuation(fields[0].Subfield('a'))
+		if subtitle := trimPunctuation(fields[0].Subfield('b')); subtitle != "" {
+			book.Title += ": " + subtitle
+		}
+	}
+
+	for _, f := range append(rec.DataFields("100"), rec.DataFields("700")...) {
+		c, ok := contributor(f)
+		if ok {
+			book.Contributors = append(book.Contributors, c)
+		}
+	}
+
+	publication := rec.DataFields("260")
+	for _, f := range rec.DataFields("264") {
+		if f.Ind2 == '1' {
+			publication = []Field{f}
+			break
+		}
+	}
+	if len(publication) > 0 {
+		book.Publisher = strings.Trim(trimPunctuation(publication[0].Subfield('b')), "[]")
+		if year := findYear(publication[0].Subfield('c')); year != "" {
+			book.PublishDate = yearDate(year)
+		}
+	}
+
+	fixed := rec.ControlField("008")
+	if book.PublishDate.IsZero() && len(fixed) >= 11 {
+		if year := findYear(fixed[7:11]); year != "" {
+			book.PublishDate = yearDate(year)
+		}
+	}
+	if len(fixed) >= 38 {
+		for name, code := range languageCodes {
+			if code == fixed[35:38] {
+				book.Language = name
+			}
+		}
+	}
+
+	if fields := rec.DataFields("300"); len(fields) > 0 {
+		if m := pagesRE.FindStringSubmatch(fields[0].Subfield('a')); m != nil {
+			book.Pages, _ = strconv.Atoi(m[1])
+		}
+	}
+
+	var summaries []string
+	for _, f := range rec.DataFields("520") {
+		if s := strings.TrimSpace(f.Subfield('a')); s != "" {
+			summaries = append(summaries, s)
+		}
+	}
+	book.Description = strings.Join(summaries, "\n\n")
+
+	for _, f := range rec.DataFields("650") {
+		genre := trimPunctuation(f.Subfield('a'))
+		if genre != "" && !slices.Contains(book.Genres, genre) {
+			book.Genres = append(book.Genres, genre)
+		}
+	}
+
+	return book
+}
+
+// contributor reads a 100 or 700 field. A main entry with no role is the
+// author; an added entry in a role the catalogue doesn't have is skipped.
+func contributor(f Field) (data.BookContributor, bool) {
+	name := trimPunctuation(f.Subfield('a'))
+	if name == "" {
+		return data.BookContributor{}, false
+	}
+	if f.Ind1 == '1' {
+		if surname, forenames, ok := strings.Cut(name, ", "); ok {
+			name = forenames + " " + surname
+		}
+	}
+
+	role := ""
+	for _, code := range f.SubfieldValues('4') {
+		for r, c := range relatorCodes {
+			if strings.TrimSpace(code) == c {
+				role = r
+			}
+		}
+	}
+	if role == "" {
+		for _, term := range f.SubfieldValues('e') {
+			term = strings.ToLower(term)
+			switch {
+			case strings.HasPrefix(term, "auth"):
+				role = data.ContributorAuthor
+			case strings.HasPrefix(term, "ed"):
+				role = data.ContributorEditor
+			case strings.HasPrefix(term, "tr"):
+				role = data.ContributorTranslator
+			case strings.HasPrefix(term, "ill"):
+				role = data.ContributorIllustrator
+			}
+			if role != "" {
+				break
+			}
+		}
+	}
+
+	hasRole := len(f.SubfieldValues('4')) > 0 || len(f.SubfieldValues('e')) > 0
+	if role == "" {
+		if f.Tag == "700" && hasRole {
+			return data.BookContributor{}, false
+		}
+		role = data.ContributorAuthor
+	}
+
+	return data.BookContributor{Name: name, Role: role}, true
+}
+
+// FromBook builds a record for a book. Names are entered in direct order, as
+// the catalogue stores them, and genres become uncontrolled subject headings.
+func FromBook(book *data.Book) *Record {
+	rec := NewRecord()
+
+	rec.AddControlField("001", strconv.Itoa(book.ID))
+
+	language, ok := languageCodes[book.Language]
+	if !ok {
+		language = "und"
+	}
+	year := ""
+	if !book.PublishDate.IsZero() {
+		year = book.PublishDate.Format("2006")
+	}
+	// Date entered, publication year, place unknown, book details not
+	// coded, language.
+	rec.AddControlField("008", fmt.Sprintf("%ss%-4s    xx %s%s d",
+		time.Now().Format("060102"), year, strings.Repeat("|", 17), language))
+
+	rec.AddDataField("020", ' ', ' ', Subfield{'a', book.ISBN})
+
+	var added []data.BookContributor
+	main := false
+	for _, c := range book.Contributors {
+		if !main && c.Role == data.ContributorAuthor {
+			rec.AddDataField("100", '0', ' ', contributorSubfields(c)...)
+			main = true
+			continue
+		}
+		added = append(added, c)
+	}
+
+	ind1 := byte('0')
+	if main {
+		ind1 = '1'
+	}
+	title, subtitle, _ := strings.Cut(book.Title, ": ")
+	if subtitle != "" {
+		title += " :"
+	}
+	rec.AddDataField("245", ind1, nonfiling(title), Subfield{'a', title}, Subfield{'b', subtitle})
+
+	for _, c := range added {
+		rec.AddDataField("700", '0', ' ', contributorSubfields(c)...)
+	}
+
+	if book.Publisher != "" || year != "" {
+		publisher := book.Publisher
+		if publisher != "" && year != "" {
+			publisher += ","
+		}
+		rec.AddDataField("264", ' ', '1', Subfield{'b', publisher}, Subfield{'c', year})
+	}
+
+	if book.Pages > 0 {
+		rec.AddDataField("300", ' ', ' ', Subfield{'a', fmt.Sprintf("%d pages", book.Pages)})
+	}
+
+	if book.Description != "" {
+		rec.AddDataField("520", ' ', ' ', Subfield{'a', book.Description})
+	}
+
+	for _, genre := range book.Genres {
+		rec.AddDataField("650", ' ', '4', Subfield{'a', genre})
+	}
+
+	return rec
+}
+
+func contributorSubfields(c data.BookContributor) []Subfield {
+	return []Subfield{
+		{'a', c.Name},
+		{'e', c.Role},
+		{'4', relatorCodes[c.Role]},
+	}
+}
+
+// nonfiling returns the second indicator of a 245 field: how many characters
+// of a leading article to skip when sorting the title.
+func nonfiling(title string) byte {
+	for _, article := range []string{"The ", "An ", "A "} {
+		if strings.HasPrefix(title, article) {
+			return byte('0' + len(article))
+		}
+	}
+	return '0'
+}
+
+// trimPunctuation removes the ISBD punctuation that ends MARC subfields,
+// such as the " :" before a subtitle or the full stop ending a field. A full
+// stop after an initial is kept.
+func trimPunctuation(s string) string {
+	s = strings.TrimRight(strings.TrimSpace(s), " /:;,=")
+	if strings.HasSuffix(s, ".") {
+		words := strings.Fields(s)
+		if last := words[len(words)-1]; len(last) != 2 {
+			s = strings.TrimSuffix(s, ".")
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+func firstWord(s string) string {
+	if fields := strings.Fields(s); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// findYear returns the first year in a date such as "[c1966]", or "".
+func findYear(s string) string {
+	if m := yearRE.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func yearDate(year string) time.Time {
+	y, _ := strconv.Atoi(year)
+	return time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ImportSource reads the books of a MARC file for an import, numbering them
+// by record. It implements data.ImportSource. Genres are passed on separated
+// by commas, so a subject heading with a comma in it becomes two genres.
+type ImportSource struct {
+	r RecordReader
+	n int
+}
+
+// NewImportSource reads ISO 2709 or MARCXML, telling them apart by content.
+func NewImportSource(r io.Reader) *ImportSource {
+	return &ImportSource{r: NewRecordReader(r)}
+}
+
+func (s *ImportSource) Next() (int, data.ImportValues, error) {
+	rec, err := s.r.Read()
+	if err != nil {
+		return 0, nil, err
+	}
+	s.n++
+
+	book := ToBook(rec)
+	values := data.ImportValues{
+		"title":        book.Title,
+		"contributors": data.FormatContributors(book.Contributors),
+		"isbn":         book.ISBN,
+		"description":  book.Description,
+		"genres":       strings.Join(book.Genres, ", "),
+		"language":     book.Language,
+		"publisher":    book.Publisher,
+	}
+	if book.Pages > 0 {
+		values["pages"] = strconv.Itoa(book.Pages)
+	}
+	if !book.PublishDate.IsZero() {
+		values["publish_date"] = book.PublishDate.Format("2006-01-02")
+	}
+	return s.n, values, nil
+}
@@ -0,0 +1,254 @@
+package marc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	subfieldDelimiter = 0x1f
+	fieldTerminator   = 0x1e
+	recordTerminator  = 0x1d
+)
+
+// ISO 2709 records are limited by the five digits of the record length and
+// the four of each field's.
+const (
+	maxRecordLength = 99999
+	maxFieldLength  = 9999
+)
+
+var (
+	ErrFormat  = errors.New("malformed record")
+	ErrMARC8   = errors.New("MARC-8 encoded text is not supported; convert the file to UTF-8")
+	ErrTooLong = errors.New("record is too long for ISO 2709")
+)
+
+// Reader reads records in the ISO 2709 exchange format. Only records encoded
+// in UTF-8 are fully supported: a MARC-8 record is read only if its text is
+// plain ASCII, which is the same in both.
+type Reader struct {
+	r *bufio.Reader
+	n int
+}
+
+func NewReader(r io.Reader) *Reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader{r: br}
+}
+
+func (r *Reader) Read() (*Record, error) {
+	// Some tools put a line break after each record.
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != '\n' && b != '\r' {
+			r.r.UnreadByte()
+			break
+		}
+	}
+	r.n++
+
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(r.r, buf)
+	if err != nil {
+		return nil, r.errorf("%w: truncated leader", ErrFormat)
+	}
+
+	length, ok := digits(buf)
+	if !ok || length < 26 {
+		return nil, r.errorf("%w: bad record length %q", ErrFormat, buf)
+	}
+
+	buf = append(buf, make([]byte, length-5)...)
+	_, err = io.ReadFull(r.r, buf[5:])
+	if err != nil {
+		return nil, r.errorf("%w: truncated record", ErrFormat)
+	}
+
+	rec, err := parseRecord(buf)
+	if err != nil {
+		return nil, r.errorf("%w", err)
+	}
+	return rec, nil
+}
+
+func (r *Reader) errorf(format string, args ...any) error {
+	return fmt.Errorf("marc: record %d: "+format, append([]any{r.n}, args...)...)
+}
+
+func parseRecord(buf []byte) (*Record, error) {
+	if buf[len(buf)-1] != recordTerminator {
+		return nil, fmt.Errorf("%w: missing record terminator", ErrFormat)
+	}
+
+	leader := string(buf[:24])
+	base, ok := digits(buf[12:17])
+	if !ok || base < 25 || base > len(buf) || buf[base-1] != fieldTerminator {
+		return nil, fmt.Errorf("%w: bad base address %q", ErrFormat, leader[12:17])
+	}
+
+	dir := buf[24 : base-1]
+	if len(dir)%12 != 0 {
+		return nil, fmt.Errorf("%w: bad directory", ErrFormat)
+	}
+
+	isUTF8 := leader[9] == 'a'
+	content := buf[base : len(buf)-1]
+	rec := &Record{Leader: leader}
+
+	for i := 0; i < len(dir); i += 12 {
+		tag := string(dir[i : i+3])
+		length, ok1 := digits(dir[i+3 : i+7])
+		start, ok2 := digits(dir[i+7 : i+12])
+		if !ok1 || !ok2 || start > len(content) || length > len(content)-start {
+			return nil, fmt.Errorf("%w: bad directory entry for %s", ErrFormat, tag)
+		}
+
+		data := bytes.TrimSuffix(content[start:start+length], []byte{fieldTerminator})
+		if !isUTF8 && !ascii(data) {
+			return nil, ErrMARC8
+		}
+
+		f := Field{Tag: tag}
+		if f.IsControl() {
+			f.Value = text(data)
+			rec.Fields = append(rec.Fields, f)
+			continue
+		}
+
+		if len(data) < 2 {
+			return nil, fmt.Errorf("%w: field %s has no indicators", ErrFormat, tag)
+		}
+		f.Ind1, f.Ind2 = data[0], data[1]
+
+		for _, part := range bytes.Split(data[2:], []byte{subfieldDelimiter})[1:] {
+			if len(part) > 0 {
+				f.Subfields = append(f.Subfields, Subfield{Code: part[0], Value: text(part[1:])})
+			}
+		}
+		rec.Fields = append(rec.Fields, f)
+	}
+
+	return rec, nil
+}
+
+// digits parses a fixed-width number made up only of ASCII digits, as the
+// lengths and addresses of a record are. Unlike strconv.Atoi it rejects signs,
+// so a malformed record can't produce a negative offset.
+func digits(b []byte) (int, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+func ascii(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func text(b []byte) string {
+	return strings.ToValidUTF8(string(b), "\ufffd")
+}
+
+// Writer writes records in the ISO 2709 exchange format, encoded in UTF-8.
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes one record, filling in the lengths and addresses of its
+// leader and directory.
+func (w *Writer) Write(rec *Record) error {
+	var dir, content bytes.Buffer
+
+	for _, f := range rec.Fields {
+		if len(f.Tag) != 3 {
+			return fmt.Errorf("marc: invalid tag %q", f.Tag)
+		}
+
+		start := content.Len()
+		if f.IsControl() {
+			content.WriteString(f.Value)
+		} else {
+			content.WriteByte(indicator(f.Ind1))
+			content.WriteByte(indicator(f.Ind2))
+			for _, sf := range f.Subfields {
+				content.WriteByte(subfieldDelimiter)
+				content.WriteByte(sf.Code)
+				content.WriteString(sf.Value)
+			}
+		}
+		content.WriteByte(fieldTerminator)
+
+		length := content.Len() - start
+		if length > maxFieldLength {
+			return fmt.Errorf("marc: field %s: %w", f.Tag, ErrTooLong)
+		}
+		fmt.Fprintf(&dir, "%s%04d%05d", f.Tag, length, start)
+	}
+	dir.WriteByte(fieldTerminator)
+	content.WriteByte(recordTerminator)
+
+	base := 24 + dir.Len()
+	length := base + content.Len()
+	if length > maxRecordLength {
+		return fmt.Errorf("marc: %w", ErrTooLong)
+	}
+
+	leader := leaderFor(rec)
+	copy(leader[0:5], fmt.Sprintf("%05d", length))
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+
+	buf := make([]byte, 0, length)
+	buf = append(buf, leader...)
+	buf = append(buf, dir.Bytes()...)
+	buf = append(buf, content.Bytes()...)
+
+	_, err := w.w.Write(buf)
+	return err
+}
+
+// leaderFor returns the record's leader with the parts that describe the
+// encoding set for a UTF-8 record written by this package.
+func leaderFor(rec *Record) []byte {
+	leader := []byte(rec.Leader)
+	if len(leader) != 24 {
+		leader = []byte(DefaultLeader)
+	}
+	leader[9] = 'a'
+	copy(leader[10:12], "22")
+	copy(leader[20:24], "4500")
+	return leader
+}
+
+func indicator(b byte) byte {
+	if b == 0 {
+		return ' '
+	}
+	return b
+}
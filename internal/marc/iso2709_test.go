@@ -0,0 +1,110 @@
+package marc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// rawRecord assembles an ISO 2709 record from a directory and content as
+// they are, filling in only the record length and base address, so tests can
+// feed the reader directory entries the writer would never produce.
+func rawRecord(dir, content string) []byte {
+	base := 24 + len(dir) + 1
+	length := base + len(content) + 1
+	leader := fmt.Sprintf("%05dnam a22%05d i 4500", length, base)
+	return []byte(leader + dir + "\x1e" + content + "\x1d")
+}
+
+func TestReaderRoundTrip(t *testing.T) {
+	rec := NewRecord()
+	rec.AddControlField("001", "42")
+	rec.AddDataField("245", '1', '0', Subfield{'a', "Dune"}, Subfield{'c', "Frank Herbert"})
+	rec.AddDataField("650", ' ', '0', Subfield{'a', "Science fiction"})
+
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("\r\n")
+
+	got, err := NewReader(&buf).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.ControlField("001"); v != "42" {
+		t.Errorf("001 = %q, want %q", v, "42")
+	}
+	title := got.DataFields("245")
+	if len(title) != 1 || title[0].Subfield('a') != "Dune" || title[0].Ind1 != '1' {
+		t.Errorf("245 = %+v", title)
+	}
+	if v := got.DataFields("650"); len(v) != 1 || v[0].Subfield('a') != "Science fiction" {
+		t.Errorf("650 = %+v", v)
+	}
+}
+
+func TestReaderMalformed(t *testing.T) {
+	valid := rawRecord("245001200000", "10\x1faDune\x1fcX\x1e")
+	if _, err := NewReader(bytes.NewReader(valid)).Read(); err != nil {
+		t.Fatalf("valid record: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"truncated leader", []byte("001")},
+		{"truncated record", valid[:len(valid)-5]},
+		{"non-numeric length", append([]byte("abcde"), valid[5:]...)},
+		{"signed length", append([]byte("-0100"), valid[5:]...)},
+		{"length shorter than leader", append([]byte("00010"), valid[5:]...)},
+		{"missing record terminator", append(valid[:len(valid)-1:len(valid)-1], 'x')},
+		{"base address past end", rawRecordWithBase("99999")},
+		{"signed base address", rawRecordWithBase("-0025")},
+		{"partial directory entry", rawRecord("24500150000", "10\x1faDune\x1e")},
+		{"negative field length", rawRecord("245-00100000", "10\x1faDune\x1e")},
+		{"negative field start", rawRecord("2450010-0001", "10\x1faDune\x1e")},
+		{"signed field start", rawRecord("2450010+0001", "10\x1faDune\x1e")},
+		{"field past end", rawRecord("245999900000", "10\x1faDune\x1e")},
+		{"start past end", rawRecord("245000199999", "10\x1faDune\x1e")},
+		{"data field without indicators", rawRecord("245000100000", "\x1e")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(bytes.NewReader(tt.input)).Read()
+			if !errors.Is(err, ErrFormat) {
+				t.Errorf("err = %v, want ErrFormat", err)
+			}
+		})
+	}
+}
+
+func rawRecordWithBase(base string) []byte {
+	rec := rawRecord("245000900000", "10\x1faDune\x1e")
+	copy(rec[12:17], base)
+	return rec
+}
+
+func TestReaderMARC8(t *testing.T) {
+	rec := rawRecord("245000700000", "10\x1fa\xe2e\x1e")
+	rec[9] = ' '
+
+	_, err := NewReader(bytes.NewReader(rec)).Read()
+	if !errors.Is(err, ErrMARC8) {
+		t.Errorf("err = %v, want ErrMARC8", err)
+	}
+}
+
+func TestReaderEOF(t *testing.T) {
+	r := NewReader(bytes.NewReader(rawRecord("245000900000", "10\x1faDune\x1e")))
+	if _, err := r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+}
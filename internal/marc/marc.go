@@ -0,0 +1,136 @@
+// Package marc reads and writes MARC 21 bibliographic records, both in the
+// ISO 2709 exchange format (.mrc files) and as MARCXML, and maps them to
+// catalogue books.
+package marc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultLeader is the leader of a new record: a complete, Unicode-encoded
+// record for a printed book. Lengths and addresses are filled in when the
+// record is written.
+const DefaultLeader = "00000nam a2200000 i 4500"
+
+// Record is one bibliographic record.
+type Record struct {
+	Leader string
+	Fields []Field
+}
+
+// Field is a control field (tags 001 to 009), which holds only Value, or a
+// data field, which has two indicators and a list of subfields.
+type Field struct {
+	Tag       string
+	Value     string
+	Ind1      byte
+	Ind2      byte
+	Subfields []Subfield
+}
+
+type Subfield struct {
+	Code  byte
+	Value string
+}
+
+func NewRecord() *Record {
+	return &Record{Leader: DefaultLeader}
+}
+
+// IsControl reports whether the field is a control field.
+func (f Field) IsControl() bool {
+	return f.Tag < "010"
+}
+
+// Subfield returns the value of the first subfield with the code, or "".
+func (f Field) Subfield(code byte) string {
+	for _, sf := range f.Subfields {
+		if sf.Code == code {
+			return sf.Value
+		}
+	}
+	return ""
+}
+
+// SubfieldValues returns the values of every subfield with the code.
+func (f Field) SubfieldValues(code byte) []string {
+	var values []string
+	for _, sf := range f.Subfields {
+		if sf.Code == code {
+			values = append(values, sf.Value)
+		}
+	}
+	return values
+}
+
+// ControlField returns the value of the first control field with the tag, or
+// "".
+func (r *Record) ControlField(tag string) string {
+	for _, f := range r.Fields {
+		if f.Tag == tag {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// DataFields returns the fields with the tag, in record order.
+func (r *Record) DataFields(tag string) []Field {
+	var fields []Field
+	for _, f := range r.Fields {
+		if f.Tag == tag {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func (r *Record) AddControlField(tag, value string) {
+	r.Fields = append(r.Fields, Field{Tag: tag, Value: value})
+}
+
+// AddDataField adds a data field, leaving out subfields with blank values.
+func (r *Record) AddDataField(tag string, ind1, ind2 byte, subfields ...Subfield) {
+	f := Field{Tag: tag, Ind1: ind1, Ind2: ind2}
+	for _, sf := range subfields {
+		if sf.Value != "" {
+			f.Subfields = append(f.Subfields, sf)
+		}
+	}
+	r.Fields = append(r.Fields, f)
+}
+
+// HasMARCExtension reports whether a file name has one of the extensions of
+// MARC files: .mrc or .marc for ISO 2709, or .xml for MARCXML.
+func HasMARCExtension(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mrc", ".marc", ".xml":
+		return true
+	}
+	return false
+}
+
+// A RecordReader reads records one at a time, returning io.EOF after the
+// last.
+type RecordReader interface {
+	Read() (*Record, error)
+}
+
+// NewRecordReader returns a Reader or an XMLReader for r, depending on
+// whether its content looks like XML.
+func NewRecordReader(r io.Reader) RecordReader {
+	br := bufio.NewReader(r)
+
+	// Peek returns what it has along with an error for short input, which
+	// the reader itself will run into.
+	head, _ := br.Peek(512)
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\ufeff")), " \t\r\n")
+	if len(head) > 0 && head[0] == '<' {
+		return NewXMLReader(br)
+	}
+	return NewReader(br)
+}
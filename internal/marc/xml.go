@@ -0,0 +1,173 @@
+package marc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+const xmlNamespace = "http://www.loc.gov/MARC21/slim"
+
+type xmlRecord struct {
+	XMLName       xml.Name          `xml:"record"`
+	Leader        string            `xml:"leader"`
+	ControlFields []xmlControlField `xml:"controlfield"`
+	DataFields    []xmlDataField    `xml:"datafield"`
+}
+
+type xmlControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlDataField struct {
+	Tag       string        `xml:"tag,attr"`
+	Ind1      string        `xml:"ind1,attr"`
+	Ind2      string        `xml:"ind2,attr"`
+	Subfields []xmlSubfield `xml:"subfield"`
+}
+
+type xmlSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// XMLReader reads the records of a MARCXML document, which may be a
+// collection or a single record.
+type XMLReader struct {
+	d *xml.Decoder
+	n int
+}
+
+func NewXMLReader(r io.Reader) *XMLReader {
+	return &XMLReader{d: xml.NewDecoder(r)}
+}
+
+func (r *XMLReader) Read() (*Record, error) {
+	for {
+		tok, err := r.d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("marc: record %d: %w", r.n+1, err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+		r.n++
+
+		var x xmlRecord
+		err = r.d.DecodeElement(&x, &start)
+		if err != nil {
+			return nil, fmt.Errorf("marc: record %d: %w", r.n, err)
+		}
+
+		rec, err := x.record()
+		if err != nil {
+			return nil, fmt.Errorf("marc: record %d: %w", r.n, err)
+		}
+		return rec, nil
+	}
+}
+
+func (x *xmlRecord) record() (*Record, error) {
+	rec := &Record{Leader: x.Leader}
+	if rec.Leader == "" {
+		rec.Leader = DefaultLeader
+	}
+	if len(rec.Leader) != 24 {
+		return nil, fmt.Errorf("%w: leader must be 24 characters", ErrFormat)
+	}
+
+	for _, cf := range x.ControlFields {
+		rec.Fields = append(rec.Fields, Field{Tag: cf.Tag, Value: cf.Value})
+	}
+
+	for _, df := range x.DataFields {
+		if len(df.Ind1) > 1 || len(df.Ind2) > 1 {
+			return nil, fmt.Errorf("%w: field %s has a bad indicator", ErrFormat, df.Tag)
+		}
+
+		f := Field{Tag: df.Tag, Ind1: ' ', Ind2: ' '}
+		if df.Ind1 != "" {
+			f.Ind1 = df.Ind1[0]
+		}
+		if df.Ind2 != "" {
+			f.Ind2 = df.Ind2[0]
+		}
+		for _, sf := range df.Subfields {
+			if len(sf.Code) != 1 {
+				return nil, fmt.Errorf("%w: field %s has a bad subfield code", ErrFormat, df.Tag)
+			}
+			f.Subfields = append(f.Subfields, Subfield{Code: sf.Code[0], Value: sf.Value})
+		}
+		rec.Fields = append(rec.Fields, f)
+	}
+
+	return rec, nil
+}
+
+// XMLWriter writes records as a MARCXML collection. Close must be called to
+// end the document.
+type XMLWriter struct {
+	w       io.Writer
+	enc     *xml.Encoder
+	started bool
+}
+
+func NewXMLWriter(w io.Writer) *XMLWriter {
+	enc := xml.NewEncoder(w)
+	enc.Indent("  ", "  ")
+	return &XMLWriter{w: w, enc: enc}
+}
+
+func (w *XMLWriter) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	_, err := fmt.Fprintf(w.w, "%s<collection xmlns=%q>\n", xml.Header, xmlNamespace)
+	return err
+}
+
+func (w *XMLWriter) Write(rec *Record) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	x := xmlRecord{Leader: string(leaderFor(rec))}
+	for _, f := range rec.Fields {
+		if f.IsControl() {
+			x.ControlFields = append(x.ControlFields, xmlControlField{Tag: f.Tag, Value: f.Value})
+			continue
+		}
+
+		df := xmlDataField{
+			Tag:  f.Tag,
+			Ind1: string(indicator(f.Ind1)),
+			Ind2: string(indicator(f.Ind2)),
+		}
+		for _, sf := range f.Subfields {
+			df.Subfields = append(df.Subfields, xmlSubfield{Code: string(sf.Code), Value: sf.Value})
+		}
+		x.DataFields = append(x.DataFields, df)
+	}
+
+	if err := w.enc.Encode(x); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.w, "\n")
+	return err
+}
+
+func (w *XMLWriter) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.w, "</collection>\n")
+	return err
+}
@@ -0,0 +1,65 @@
+// Package metadata looks up a book's bibliographic details by ISBN, so the
+// book form can be filled in without retyping them.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+)
+
+var ErrNotFound = errors.New("metadata: no record for this ISBN")
+
+// Record is what a provider knows about an edition. Any field may be blank;
+// it pre-fills the book form for an admin to review, never the catalogue
+// directly. PublishDate is in YYYY-MM-DD form.
+type Record struct {
+	ISBN         string                 `json:"isbn"`
+	Title        string                 `json:"title"`
+	Contributors []data.BookContributor `json:"contributors"`
+	Publisher    string                 `json:"publisher"`
+	PublishDate  string                 `json:"publish_date"`
+	Pages        int                    `json:"pages"`
+	Language     string                 `json:"language"`
+	Genres       []string               `json:"genres"`
+	Description  string                 `json:"description"`
+	CoverImage   string                 `json:"cover_image"`
+}
+
+// Provider is a source of bibliographic metadata. Lookup is given an ISBN in
+// canonical ISBN-13 form and returns ErrNotFound if it has no record of it.
+type Provider interface {
+	Lookup(ctx context.Context, isbn string) (*Record, error)
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"January 2006",
+	"Jan 2006",
+	"2006",
+}
+
+var yearRE = regexp.MustCompile(`(?:^|\D)(1\d{3}|20\d{2})(?:\D|$)`)
+
+// parseDate reads the free-form publication dates found in catalogue
+// records, such as "March 1, 1969" or "c1969", falling back to January 1st
+// of the first year mentioned. It returns "" if there is no year at all.
+func parseDate(s string) string {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	if m := yearRE.FindStringSubmatch(s); m != nil {
+		return m[1] + "-01-01"
+	}
+	return ""
+}
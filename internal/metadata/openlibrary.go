@@ -0,0 +1,123 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/0xrinful/LibraryMS/internal/data"
+)
+
+// maxGenres caps how many subjects are offered as genres. Open Library lists
+// dozens for popular books, most too narrow to be useful.
+const maxGenres = 5
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// OpenLibraryProvider looks books up through the Books API of Open Library,
+// or of any server that answers in the same format at BaseURL.
+type OpenLibraryProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+type openLibraryBook struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle"`
+	Authors  []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Publishers []struct {
+		Name string `json:"name"`
+	} `json:"publishers"`
+	PublishDate   string `json:"publish_date"`
+	NumberOfPages int    `json:"number_of_pages"`
+	Subjects      []struct {
+		Name string `json:"name"`
+	} `json:"subjects"`
+	Cover struct {
+		Medium string `json:"medium"`
+		Large  string `json:"large"`
+	} `json:"cover"`
+}
+
+func (p OpenLibraryProvider) Lookup(ctx context.Context, isbn string) (*Record, error) {
+	key := "ISBN:" + isbn
+	u := strings.TrimRight(p.BaseURL, "/") + "/api/books?" + url.Values{
+		"bibkeys": {key},
+		"jscmd":   {"data"},
+		"format":  {"json"},
+	}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = defaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("metadata: %s answered %s", p.BaseURL, res.Status)
+	}
+
+	var books map[string]openLibraryBook
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&books)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+
+	book, ok := books[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	rec := &Record{
+		ISBN:        isbn,
+		Title:       book.Title,
+		PublishDate: parseDate(book.PublishDate),
+		Pages:       book.NumberOfPages,
+		Genres:      []string{},
+		CoverImage:  book.Cover.Large,
+	}
+	if book.Subtitle != "" {
+		rec.Title += ": " + book.Subtitle
+	}
+	if rec.CoverImage == "" {
+		rec.CoverImage = book.Cover.Medium
+	}
+	for _, a := range book.Authors {
+		rec.Contributors = append(rec.Contributors, data.BookContributor{
+			Name: a.Name,
+			Role: data.ContributorAuthor,
+		})
+	}
+	if len(book.Publishers) > 0 {
+		rec.Publisher = book.Publishers[0].Name
+	}
+	for _, s := range book.Subjects {
+		if len(rec.Genres) == maxGenres {
+			break
+		}
+		rec.Genres = append(rec.Genres, s.Name)
+	}
+
+	return rec, nil
+}
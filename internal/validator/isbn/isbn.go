@@ -0,0 +1,132 @@
+// Package isbn checks and converts International Standard Book Numbers.
+// Functions accept ISBNs with or without hyphens and spaces, and the catalogue
+// stores them in the canonical form returned by To13.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalid  = errors.New("invalid ISBN")
+	ErrNoISBN10 = errors.New("ISBN-13 has no ISBN-10 form")
+)
+
+var separators = strings.NewReplacer("-", "", " ", "")
+
+// Clean strips hyphens and spaces and upper-cases a trailing x check digit,
+// e.g. "0-306-40615-x" becomes "030640615X". It does not check the result.
+func Clean(s string) string {
+	return strings.ToUpper(separators.Replace(strings.TrimSpace(s)))
+}
+
+// Valid reports whether s is an ISBN-10 or ISBN-13 with a correct check
+// digit.
+func Valid(s string) bool {
+	s = Clean(s)
+	return valid10(s) || valid13(s)
+}
+
+// Valid10 and Valid13 report whether s is an ISBN of that length with a
+// correct check digit.
+func Valid10(s string) bool {
+	return valid10(Clean(s))
+}
+
+func Valid13(s string) bool {
+	return valid13(Clean(s))
+}
+
+// To13 returns s as an unhyphenated ISBN-13, converting an ISBN-10 by adding
+// the 978 prefix. This is the canonical form.
+func To13(s string) (string, error) {
+	s = Clean(s)
+	switch {
+	case valid13(s):
+		return s, nil
+	case valid10(s):
+		body := "978" + s[:9]
+		return body + string(check13(body)), nil
+	default:
+		return "", ErrInvalid
+	}
+}
+
+// To10 returns s as an unhyphenated ISBN-10. Only ISBN-13s with the 978
+// prefix have one; for the rest it returns ErrNoISBN10.
+func To10(s string) (string, error) {
+	s = Clean(s)
+	switch {
+	case valid10(s):
+		return s, nil
+	case valid13(s):
+		if !strings.HasPrefix(s, "978") {
+			return "", ErrNoISBN10
+		}
+		body := s[3:12]
+		return body + string(check10(body)), nil
+	default:
+		return "", ErrInvalid
+	}
+}
+
+func valid10(s string) bool {
+	if len(s) != 10 || !digits(s[:9]) {
+		return false
+	}
+	last := s[9]
+	if (last < '0' || last > '9') && last != 'X' {
+		return false
+	}
+	return check10(s[:9]) == last
+}
+
+func valid13(s string) bool {
+	if len(s) != 13 || !digits(s) {
+		return false
+	}
+	if !strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979") {
+		return false
+	}
+	return check13(s[:12]) == s[12]
+}
+
+// check10 computes the ISBN-10 check digit for the first nine digits: the
+// digits weighted 10 down to 2 plus the check digit must be a multiple of 11,
+// with X standing for 10.
+func check10(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(body[i]-'0')
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// check13 computes the ISBN-13 check digit for the first twelve digits: the
+// digits weighted alternately 1 and 3 plus the check digit must be a multiple
+// of 10.
+func check13(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(body[i]-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package isbn
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		input   string
+		valid   bool
+		valid10 bool
+		valid13 bool
+	}{
+		{"0306406152", true, true, false},
+		{"0-306-40615-2", true, true, false},
+		{"0 306 40615 2", true, true, false},
+		{" 0-306-40615-2 ", true, true, false},
+		{"080442957X", true, true, false},
+		{"080442957x", true, true, false},
+		{"0-8044-2957-x", true, true, false},
+		{"9780306406157", true, false, true},
+		{"978-0-306-40615-7", true, false, true},
+		{"978 0 306 40615 7", true, false, true},
+		{"9791090636071", true, false, true},
+
+		{"", false, false, false},
+		{"0306406153", false, false, false},
+		{"0804429570", false, false, false},
+		{"X306406152", false, false, false},
+		{"03064061X2", false, false, false},
+		{"9780306406158", false, false, false},
+		{"978030640615X", false, false, false},
+		{"9770306406150", false, false, false},
+		{"030640615", false, false, false},
+		{"97803064061570", false, false, false},
+		{"0-306-40615-2a", false, false, false},
+		{"0_306_40615_2", false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := Valid(tt.input); got != tt.valid {
+				t.Errorf("Valid(%q) = %v, want %v", tt.input, got, tt.valid)
+			}
+			if got := Valid10(tt.input); got != tt.valid10 {
+				t.Errorf("Valid10(%q) = %v, want %v", tt.input, got, tt.valid10)
+			}
+			if got := Valid13(tt.input); got != tt.valid13 {
+				t.Errorf("Valid13(%q) = %v, want %v", tt.input, got, tt.valid13)
+			}
+		})
+	}
+}
+
+func TestClean(t *testing.T) {
+	if got, want := Clean(" 0-8044-2957-x "), "080442957X"; got != want {
+		t.Errorf("Clean = %q, want %q", got, want)
+	}
+}
+
+func TestTo13(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   error
+	}{
+		{"0-306-40615-2", "9780306406157", nil},
+		{"080442957x", "9780804429573", nil},
+		{"978-0-306-40615-7", "9780306406157", nil},
+		{"979-10-90636-07-1", "9791090636071", nil},
+		{"0306406153", "", ErrInvalid},
+		{"9780306406158", "", ErrInvalid},
+		{"", "", ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := To13(tt.input)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("To13(%q) = %q, %v; want %q, %v", tt.input, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestTo10(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   error
+	}{
+		{"978-0-306-40615-7", "0306406152", nil},
+		{"9780804429573", "080442957X", nil},
+		{"0-8044-2957-x", "080442957X", nil},
+		{"0306406152", "0306406152", nil},
+		{"9791090636071", "", ErrNoISBN10},
+		{"9780306406158", "", ErrInvalid},
+		{"", "", ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := To10(tt.input)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("To10(%q) = %q, %v; want %q, %v", tt.input, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	checks := map[byte]bool{}
+	for n := 0; n < 100; n++ {
+		body := fmt.Sprintf("0306406%02d", n)
+		isbn10 := body + string(check10(body))
+		checks[isbn10[9]] = true
+
+		isbn13, err := To13(isbn10)
+		if err != nil {
+			t.Fatalf("To13(%q): %v", isbn10, err)
+		}
+		if !Valid13(isbn13) {
+			t.Errorf("To13(%q) = %q is not a valid ISBN-13", isbn10, isbn13)
+		}
+
+		back, err := To10(isbn13)
+		if err != nil {
+			t.Fatalf("To10(%q): %v", isbn13, err)
+		}
+		if back != isbn10 {
+			t.Errorf("To10(To13(%q)) = %q", isbn10, back)
+		}
+	}
+
+	// Every check digit, X included, should have made the round trip.
+	if len(checks) != 11 {
+		t.Errorf("round trip covered %d check digits, want 11", len(checks))
+	}
+}
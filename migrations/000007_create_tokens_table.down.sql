@@ -0,0 +1,2 @@
+-- Before this migration there was no tokens table.
+DROP TABLE IF EXISTS tokens;
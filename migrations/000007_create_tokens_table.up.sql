@@ -0,0 +1,25 @@
+-- tokens holds every kind of user token, told apart by purpose. No earlier
+-- migration creates a tokens table, so this one never replaces existing
+-- tokens. A table made by hand under this name would have a different
+-- shape, so stop here rather than carry on without the columns below.
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_tables WHERE schemaname = current_schema() AND tablename = 'tokens') THEN
+    RAISE EXCEPTION 'a tokens table already exists; rename or drop it before migrating';
+  END IF;
+END
+$$;
+
+CREATE TABLE tokens (
+  id bigserial PRIMARY KEY,
+  hash bytea UNIQUE NOT NULL,
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  purpose text NOT NULL,
+  name text NOT NULL DEFAULT '',
+  scopes text[] NOT NULL DEFAULT '{}',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  expiry timestamp(0) with time zone NULL,
+  last_used_at timestamp(0) with time zone NULL
+);
+
+CREATE INDEX tokens_user_id_purpose_idx ON tokens (user_id, purpose);
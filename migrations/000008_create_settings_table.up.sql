@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS settings (
+  key text PRIMARY KEY,
+  value text NOT NULL
+);
+
+INSERT INTO settings (key, value)
+VALUES ('activation_required', 'none')
+ON CONFLICT (key) DO NOTHING;
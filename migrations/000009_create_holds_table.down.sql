@@ -0,0 +1,3 @@
+DELETE FROM settings WHERE key = 'hold_pickup_days';
+
+DROP TABLE IF EXISTS holds;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS holds (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  book_id bigint NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+  status text NOT NULL DEFAULT 'waiting' CHECK (
+    status IN ('waiting', 'ready', 'fulfilled', 'cancelled', 'expired')
+  ),
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  ready_at timestamp(0) with time zone NULL,
+  expires_at timestamp(0) with time zone NULL
+);
+
+CREATE UNIQUE INDEX holds_one_active ON holds (user_id, book_id)
+WHERE
+  status IN ('waiting', 'ready');
+
+CREATE INDEX holds_queue_idx ON holds (book_id, created_at, id)
+WHERE
+  status = 'waiting';
+
+INSERT INTO settings (key, value)
+VALUES ('hold_pickup_days', '3')
+ON CONFLICT (key) DO NOTHING;
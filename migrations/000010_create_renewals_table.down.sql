@@ -0,0 +1,6 @@
+DELETE FROM settings WHERE key IN ('max_renewals', 'renewal_days');
+
+DROP TABLE IF EXISTS renewals;
+
+ALTER TABLE borrow_records
+DROP COLUMN IF EXISTS renewal_count;
@@ -0,0 +1,16 @@
+ALTER TABLE borrow_records
+ADD COLUMN renewal_count integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS renewals (
+  id bigserial PRIMARY KEY,
+  borrow_id bigint NOT NULL REFERENCES borrow_records (id) ON DELETE CASCADE,
+  renewed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  previous_due_at timestamp(0) with time zone NOT NULL,
+  new_due_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX renewals_borrow_id_idx ON renewals (borrow_id);
+
+INSERT INTO settings (key, value)
+VALUES ('max_renewals', '2'), ('renewal_days', '14')
+ON CONFLICT (key) DO NOTHING;
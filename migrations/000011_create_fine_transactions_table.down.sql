@@ -0,0 +1,4 @@
+DELETE FROM settings
+WHERE key IN ('fine_daily_cents', 'fine_grace_days', 'fine_max_cents', 'fine_block_cents');
+
+DROP TABLE IF EXISTS fine_transactions;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS fine_transactions (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  borrow_id bigint NULL REFERENCES borrow_records (id) ON DELETE SET NULL,
+  kind text NOT NULL CHECK (kind IN ('charge', 'payment', 'waiver')),
+  amount_cents integer NOT NULL CHECK (amount_cents > 0),
+  note text NOT NULL DEFAULT '',
+  created_by bigint NULL REFERENCES users (id) ON DELETE SET NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX fine_transactions_user_id_idx ON fine_transactions (user_id);
+
+CREATE INDEX fine_transactions_borrow_id_idx ON fine_transactions (borrow_id)
+WHERE
+  kind = 'charge';
+
+INSERT INTO settings (key, value)
+VALUES
+  ('fine_daily_cents', '25'),
+  ('fine_grace_days', '2'),
+  ('fine_max_cents', '1000'),
+  ('fine_block_cents', '500')
+ON CONFLICT (key) DO NOTHING;
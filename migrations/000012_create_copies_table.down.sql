@@ -0,0 +1,23 @@
+DROP VIEW IF EXISTS book_copy_counts;
+
+ALTER TABLE books
+ADD COLUMN copies_total integer NOT NULL DEFAULT 1,
+ADD COLUMN copies_available integer NOT NULL DEFAULT 1;
+
+UPDATE books b
+SET copies_total = (
+      SELECT COUNT(*) FROM copies c
+      WHERE c.book_id = b.id AND c.status NOT IN ('lost', 'withdrawn')
+    ),
+    copies_available = (
+      SELECT COUNT(*) FROM copies c
+      WHERE c.book_id = b.id AND c.status = 'available'
+    );
+
+ALTER TABLE holds
+DROP COLUMN IF EXISTS copy_id;
+
+ALTER TABLE borrow_records
+DROP COLUMN IF EXISTS copy_id;
+
+DROP TABLE IF EXISTS copies;
@@ -0,0 +1,84 @@
+CREATE TABLE IF NOT EXISTS copies (
+  id bigserial PRIMARY KEY,
+  book_id bigint NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+  barcode text NOT NULL UNIQUE,
+  acquired_at date NOT NULL DEFAULT CURRENT_DATE,
+  condition text NOT NULL DEFAULT 'good' CHECK (condition IN ('new', 'good', 'fair', 'poor')),
+  status text NOT NULL DEFAULT 'available' CHECK (
+    status IN ('available', 'on_hold', 'on_loan', 'lost', 'damaged', 'withdrawn')
+  ),
+  notes text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX copies_book_id_status_idx ON copies (book_id, status);
+
+ALTER TABLE borrow_records
+ADD COLUMN copy_id bigint NULL REFERENCES copies (id) ON DELETE SET NULL;
+
+ALTER TABLE holds
+ADD COLUMN copy_id bigint NULL REFERENCES copies (id) ON DELETE SET NULL;
+
+-- Create one copy per counted copy, making sure there are enough to cover
+-- the loans and ready holds that are currently out.
+INSERT INTO copies (book_id, barcode)
+SELECT b.id, 'LMS-' || lpad(b.id::text, 6, '0') || '-' || lpad(n::text, 3, '0')
+FROM books b
+CROSS JOIN LATERAL generate_series(1, GREATEST(
+  b.copies_total,
+  (SELECT COUNT(*) FROM borrow_records br WHERE br.book_id = b.id AND br.returned_at IS NULL)
+  + (SELECT COUNT(*) FROM holds h WHERE h.book_id = b.id AND h.status = 'ready')
+)) AS n;
+
+-- Hand the first copies of each book to its open loans...
+WITH loans AS (
+  SELECT id, book_id, row_number() OVER (PARTITION BY book_id ORDER BY id) AS n
+  FROM borrow_records
+  WHERE returned_at IS NULL
+),
+numbered AS (
+  SELECT id, book_id, row_number() OVER (PARTITION BY book_id ORDER BY id) AS n
+  FROM copies
+)
+UPDATE borrow_records br
+SET copy_id = c.id
+FROM loans l
+INNER JOIN numbered c ON c.book_id = l.book_id AND c.n = l.n
+WHERE br.id = l.id;
+
+-- ...and the next ones to holds waiting on the pickup shelf.
+WITH ready AS (
+  SELECT h.id, h.book_id,
+         row_number() OVER (PARTITION BY h.book_id ORDER BY h.id)
+         + (SELECT COUNT(*) FROM borrow_records br WHERE br.book_id = h.book_id AND br.returned_at IS NULL) AS n
+  FROM holds h
+  WHERE h.status = 'ready'
+),
+numbered AS (
+  SELECT id, book_id, row_number() OVER (PARTITION BY book_id ORDER BY id) AS n
+  FROM copies
+)
+UPDATE holds h
+SET copy_id = c.id
+FROM ready r
+INNER JOIN numbered c ON c.book_id = r.book_id AND c.n = r.n
+WHERE h.id = r.id;
+
+UPDATE copies SET status = 'on_loan'
+WHERE id IN (SELECT copy_id FROM borrow_records WHERE returned_at IS NULL);
+
+UPDATE copies SET status = 'on_hold'
+WHERE id IN (SELECT copy_id FROM holds WHERE status = 'ready');
+
+ALTER TABLE books
+DROP COLUMN copies_total,
+DROP COLUMN copies_available;
+
+CREATE VIEW book_copy_counts AS
+SELECT b.id AS book_id,
+       COUNT(c.id) FILTER (WHERE c.status NOT IN ('lost', 'withdrawn')) AS copies_total,
+       COUNT(c.id) FILTER (WHERE c.status = 'available') AS copies_available
+FROM books b
+LEFT JOIN copies c ON c.book_id = b.id
+GROUP BY b.id;
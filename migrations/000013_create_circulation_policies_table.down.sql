@@ -0,0 +1,15 @@
+DROP FUNCTION IF EXISTS circulation_policy_for (bigint, bigint);
+
+INSERT INTO settings (key, value)
+SELECT 'max_renewals', max_renewals::text
+FROM circulation_policies
+WHERE role IS NULL AND genre IS NULL
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO settings (key, value)
+SELECT 'renewal_days', renewal_days::text
+FROM circulation_policies
+WHERE role IS NULL AND genre IS NULL
+ON CONFLICT (key) DO NOTHING;
+
+DROP TABLE IF EXISTS circulation_policies;
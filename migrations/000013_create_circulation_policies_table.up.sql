@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS circulation_policies (
+  id bigserial PRIMARY KEY,
+  name text NOT NULL,
+  role text NULL CHECK (role IN ('user', 'admin')),
+  genre text NULL,
+  loan_days integer NOT NULL CHECK (loan_days BETWEEN 1 AND 365),
+  max_loans integer NOT NULL CHECK (max_loans >= 0),
+  max_renewals integer NOT NULL CHECK (max_renewals >= 0),
+  renewal_days integer NOT NULL CHECK (renewal_days BETWEEN 1 AND 365),
+  max_holds integer NOT NULL CHECK (max_holds >= 0),
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  version integer NOT NULL DEFAULT 1
+);
+
+-- At most one rule per role/genre combination, including the catch-all
+-- default where both are NULL.
+CREATE UNIQUE INDEX circulation_policies_scope_idx
+ON circulation_policies (COALESCE(role, ''), COALESCE(genre, ''));
+
+INSERT INTO circulation_policies
+  (name, loan_days, max_loans, max_renewals, renewal_days, max_holds)
+VALUES (
+  'Default',
+  30,
+  5,
+  COALESCE((SELECT value::int FROM settings WHERE key = 'max_renewals'), 2),
+  COALESCE((SELECT value::int FROM settings WHERE key = 'renewal_days'), 14),
+  5
+);
+
+DELETE FROM settings WHERE key IN ('max_renewals', 'renewal_days');
+
+-- circulation_policy_for picks the rule that applies when the user borrows
+-- the book: a rule for both their role and one of the book's genres beats a
+-- genre rule, which beats a role rule, which beats the default. Ties go to
+-- the shorter loan period.
+CREATE OR REPLACE FUNCTION circulation_policy_for(p_user_id bigint, p_book_id bigint)
+RETURNS SETOF circulation_policies
+LANGUAGE sql STABLE AS $$
+  SELECT p.*
+  FROM circulation_policies p
+  CROSS JOIN users u
+  CROSS JOIN books b
+  WHERE u.id = p_user_id
+    AND b.id = p_book_id
+    AND (p.role IS NULL OR p.role = u.role)
+    AND (p.genre IS NULL OR p.genre = ANY (b.genres))
+  ORDER BY
+    (p.role IS NOT NULL AND p.genre IS NOT NULL) DESC,
+    (p.genre IS NOT NULL) DESC,
+    (p.role IS NOT NULL) DESC,
+    p.loan_days,
+    p.id
+  LIMIT 1
+$$;
@@ -0,0 +1,26 @@
+ALTER TABLE books
+ADD COLUMN search_vector tsvector;
+
+-- Title matches rank highest, then author, then genres, with the description
+-- and publisher last.
+CREATE OR REPLACE FUNCTION books_search_vector_update() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  NEW.search_vector :=
+    setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(NEW.author, '')), 'B') ||
+    setweight(to_tsvector('english', coalesce(array_to_string(NEW.genres, ' '), '')), 'C') ||
+    setweight(to_tsvector('english', coalesce(NEW.description, '')), 'D') ||
+    setweight(to_tsvector('english', coalesce(NEW.publisher, '')), 'D');
+  RETURN NEW;
+END
+$$;
+
+CREATE TRIGGER books_search_vector_trigger
+BEFORE INSERT OR UPDATE OF title, author, genres, description, publisher ON books
+FOR EACH ROW EXECUTE FUNCTION books_search_vector_update();
+
+-- Fire the trigger once for the existing rows.
+UPDATE books SET title = title;
+
+CREATE INDEX IF NOT EXISTS books_search_vector_idx ON books USING gin (search_vector);
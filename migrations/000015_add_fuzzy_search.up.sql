@@ -0,0 +1,16 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS books_title_trgm_idx ON books USING gin (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS books_author_trgm_idx ON books USING gin (author gin_trgm_ops);
+
+-- search_terms is the vocabulary "did you mean" suggestions are drawn from:
+-- every word of three or more letters in a title or author name. It is
+-- refreshed by a background job.
+CREATE MATERIALIZED VIEW IF NOT EXISTS search_terms AS
+SELECT DISTINCT term
+FROM books
+CROSS JOIN regexp_split_to_table(lower(title || ' ' || author), '[^[:alnum:]]+') AS term
+WHERE length(term) >= 3;
+
+CREATE UNIQUE INDEX IF NOT EXISTS search_terms_term_idx ON search_terms (term);
+CREATE INDEX IF NOT EXISTS search_terms_trgm_idx ON search_terms USING gin (term gin_trgm_ops);
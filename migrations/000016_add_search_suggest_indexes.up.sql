@@ -0,0 +1,5 @@
+-- Prefix lookups for search-as-you-type. Substring and typo matches use the
+-- trigram indexes on title and author.
+CREATE INDEX IF NOT EXISTS books_title_prefix_idx ON books (lower(title) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS books_author_prefix_idx ON books (lower(author) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS books_isbn_prefix_idx ON books (replace(isbn, '-', '') text_pattern_ops);
@@ -0,0 +1,26 @@
+CREATE OR REPLACE FUNCTION books_search_vector_update() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  NEW.search_vector :=
+    setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(NEW.author, '')), 'B') ||
+    setweight(to_tsvector('english', coalesce(array_to_string(NEW.genres, ' '), '')), 'C') ||
+    setweight(to_tsvector('english', coalesce(NEW.description, '')), 'D') ||
+    setweight(to_tsvector('english', coalesce(NEW.publisher, '')), 'D');
+  RETURN NEW;
+END
+$$;
+
+DROP TRIGGER IF EXISTS contributors_renamed_trigger ON contributors;
+DROP TRIGGER IF EXISTS book_contributors_changed_trigger ON book_contributors;
+
+DROP FUNCTION IF EXISTS contributors_renamed();
+DROP FUNCTION IF EXISTS book_contributors_changed();
+DROP FUNCTION IF EXISTS book_authors(bigint);
+
+DROP TABLE IF EXISTS book_contributors;
+DROP TABLE IF EXISTS contributors;
+
+DROP FUNCTION IF EXISTS contributor_name_key(text);
+
+UPDATE books SET title = title;
@@ -0,0 +1,107 @@
+-- contributor_name_key folds spellings of a name that differ only in case,
+-- spacing and punctuation, so "J.R.R. Tolkien" and "J. R. R. Tolkien" are the
+-- same person.
+CREATE OR REPLACE FUNCTION contributor_name_key(name text) RETURNS text
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT regexp_replace(lower(name), '[^[:alnum:]]+', '', 'g')
+$$;
+
+CREATE TABLE IF NOT EXISTS contributors (
+  id bigserial PRIMARY KEY,
+  name text NOT NULL,
+  name_key text GENERATED ALWAYS AS (contributor_name_key(name)) STORED,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  version integer NOT NULL DEFAULT 1,
+  CHECK (name_key <> '')
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS contributors_name_key_idx ON contributors (name_key);
+CREATE INDEX IF NOT EXISTS contributors_name_prefix_idx ON contributors (lower(name) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS contributors_name_trgm_idx ON contributors USING gin (name gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS book_contributors (
+  book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+  contributor_id bigint NOT NULL REFERENCES contributors ON DELETE RESTRICT,
+  role text NOT NULL CHECK (role IN ('author', 'editor', 'translator', 'illustrator')),
+  position integer NOT NULL DEFAULT 0,
+  PRIMARY KEY (book_id, contributor_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS book_contributors_contributor_id_idx ON book_contributors (contributor_id);
+
+-- books.author is kept as the book's authors joined in credit order, for
+-- listings, sorting and fuzzy matching.
+CREATE OR REPLACE FUNCTION book_authors(p_book_id bigint) RETURNS text
+LANGUAGE sql STABLE AS $$
+  SELECT COALESCE(string_agg(c.name, ', ' ORDER BY bc.position, c.name), '')
+  FROM book_contributors bc
+  INNER JOIN contributors c ON c.id = bc.contributor_id
+  WHERE bc.book_id = p_book_id AND bc.role = 'author'
+$$;
+
+CREATE OR REPLACE FUNCTION book_contributors_changed() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  IF TG_OP <> 'INSERT' THEN
+    UPDATE books SET author = book_authors(OLD.book_id) WHERE id = OLD.book_id;
+  END IF;
+  IF TG_OP <> 'DELETE' THEN
+    UPDATE books SET author = book_authors(NEW.book_id) WHERE id = NEW.book_id;
+  END IF;
+  RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER book_contributors_changed_trigger
+AFTER INSERT OR UPDATE OR DELETE ON book_contributors
+FOR EACH ROW EXECUTE FUNCTION book_contributors_changed();
+
+CREATE OR REPLACE FUNCTION contributors_renamed() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  UPDATE books SET author = book_authors(id)
+  WHERE id IN (SELECT book_id FROM book_contributors WHERE contributor_id = NEW.id);
+  RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER contributors_renamed_trigger
+AFTER UPDATE OF name ON contributors
+FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name) EXECUTE FUNCTION contributors_renamed();
+
+-- Editors, translators and illustrators are searchable alongside the
+-- authors.
+CREATE OR REPLACE FUNCTION books_search_vector_update() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  NEW.search_vector :=
+    setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(NEW.author, '') || ' ' || coalesce((
+      SELECT string_agg(c.name, ' ')
+      FROM book_contributors bc
+      INNER JOIN contributors c ON c.id = bc.contributor_id
+      WHERE bc.book_id = NEW.id AND bc.role <> 'author'
+    ), '')), 'B') ||
+    setweight(to_tsvector('english', coalesce(array_to_string(NEW.genres, ' '), '')), 'C') ||
+    setweight(to_tsvector('english', coalesce(NEW.description, '')), 'D') ||
+    setweight(to_tsvector('english', coalesce(NEW.publisher, '')), 'D');
+  RETURN NEW;
+END
+$$;
+
+-- Existing authors are split on "&", "and" and ";". Commas are left alone
+-- since they also appear in "Surname, Forename".
+INSERT INTO contributors (name)
+SELECT DISTINCT ON (contributor_name_key(a.name)) a.name
+FROM books
+CROSS JOIN regexp_split_to_table(books.author, '\s*(;|&|\sand\s)\s*') AS a(name)
+WHERE contributor_name_key(a.name) <> ''
+ORDER BY contributor_name_key(a.name), a.name
+ON CONFLICT (name_key) DO NOTHING;
+
+INSERT INTO book_contributors (book_id, contributor_id, role, position)
+SELECT DISTINCT ON (books.id, c.id) books.id, c.id, 'author', a.n
+FROM books
+CROSS JOIN regexp_split_to_table(books.author, '\s*(;|&|\sand\s)\s*') WITH ORDINALITY AS a(name, n)
+INNER JOIN contributors c ON c.name_key = contributor_name_key(a.name)
+ORDER BY books.id, c.id, a.n;
@@ -0,0 +1,47 @@
+-- A work is what editions have in common: the same text under different
+-- ISBNs, publishers, languages or dates. Titles and credits stay on each
+-- edition, since translations and reissues can differ.
+CREATE TABLE IF NOT EXISTS works (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE books
+ADD COLUMN work_id bigint REFERENCES works (id);
+
+-- Existing books with the same title and authors are taken to be editions of
+-- one work, numbered after the oldest of them.
+INSERT INTO works (id)
+SELECT MIN(id) FROM books
+GROUP BY lower(btrim(title)), contributor_name_key(author);
+
+UPDATE books
+SET work_id = w.work_id
+FROM (
+  SELECT id, MIN(id) OVER (PARTITION BY lower(btrim(title)), contributor_name_key(author)) AS work_id
+  FROM books
+) w
+WHERE books.id = w.id;
+
+SELECT setval('works_id_seq', COALESCE((SELECT MAX(id) FROM works), 0) + 1, false);
+
+ALTER TABLE books
+ALTER COLUMN work_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS books_work_id_idx ON books (work_id);
+
+-- Holds now queue for a work, and a member may only have one per work. Where
+-- grouping editions has given someone two, the later waiting one goes.
+UPDATE holds h
+SET status = 'cancelled'
+FROM books b
+WHERE b.id = h.book_id
+  AND h.status = 'waiting'
+  AND EXISTS (
+    SELECT 1 FROM holds o
+    INNER JOIN books ob ON ob.id = o.book_id
+    WHERE o.user_id = h.user_id
+      AND ob.work_id = b.work_id
+      AND o.id <> h.id
+      AND (o.status = 'ready' OR (o.status = 'waiting' AND (o.created_at, o.id) < (h.created_at, h.id)))
+  );
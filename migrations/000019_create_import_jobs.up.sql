@@ -0,0 +1,18 @@
+-- Each catalogue import, from the dashboard or the admin command, with the
+-- outcome of every row kept for the downloadable report.
+CREATE TABLE IF NOT EXISTS import_jobs (
+  id bigserial PRIMARY KEY,
+  user_id bigint NULL REFERENCES users ON DELETE SET NULL,
+  filename text NOT NULL,
+  dry_run boolean NOT NULL DEFAULT false,
+  status text NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'done', 'failed')),
+  rows_created integer NOT NULL DEFAULT 0,
+  rows_updated integer NOT NULL DEFAULT 0,
+  rows_invalid integer NOT NULL DEFAULT 0,
+  error text NOT NULL DEFAULT '',
+  report jsonb NOT NULL DEFAULT '[]',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  finished_at timestamp(0) with time zone NULL
+);
+
+CREATE INDEX IF NOT EXISTS import_jobs_created_at_idx ON import_jobs (created_at DESC);
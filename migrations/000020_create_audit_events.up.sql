@@ -0,0 +1,32 @@
+-- Every change an admin makes to the catalogue or to a member. The actor's
+-- email is copied so an event still says who acted after they are deleted,
+-- and changes holds the fields that changed as {"field": {"before": ..,
+-- "after": ..}}.
+CREATE TABLE IF NOT EXISTS audit_events (
+  id bigserial PRIMARY KEY,
+  actor_id bigint NOT NULL,
+  actor_email citext NOT NULL,
+  action text NOT NULL,
+  target_type text NOT NULL,
+  target_id bigint NOT NULL,
+  changes jsonb NOT NULL DEFAULT '{}',
+  ip text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target_type, target_id);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor_id);
+
+-- The log is append-only: events can't be edited or removed once written.
+CREATE OR REPLACE FUNCTION audit_events_append_only()
+RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END
+$$;
+
+CREATE TRIGGER audit_events_append_only
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
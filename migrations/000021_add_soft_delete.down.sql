@@ -0,0 +1,26 @@
+DROP MATERIALIZED VIEW IF EXISTS search_terms;
+
+CREATE MATERIALIZED VIEW search_terms AS
+SELECT DISTINCT term
+FROM books
+CROSS JOIN regexp_split_to_table(lower(title || ' ' || author), '[^[:alnum:]]+') AS term
+WHERE length(term) >= 3;
+
+CREATE UNIQUE INDEX IF NOT EXISTS search_terms_term_idx ON search_terms (term);
+CREATE INDEX IF NOT EXISTS search_terms_trgm_idx ON search_terms USING gin (term gin_trgm_ops);
+
+DELETE FROM settings WHERE key = 'trash_retention_days';
+
+ALTER TABLE borrow_records
+DROP CONSTRAINT IF EXISTS borrow_records_book_id_fkey,
+ADD CONSTRAINT borrow_records_book_id_fkey FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE;
+
+ALTER TABLE borrow_records
+DROP CONSTRAINT IF EXISTS borrow_records_user_id_fkey,
+ADD CONSTRAINT borrow_records_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS books_deleted_at_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE books DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,33 @@
+-- Deleted books and members are kept in the trash, with their loan history,
+-- until they are restored or purged once the retention period has passed.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone NULL;
+
+CREATE INDEX IF NOT EXISTS books_deleted_at_idx ON books (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Loan history is only removed on purpose, by the purge, never as a side
+-- effect of deleting a book or member.
+ALTER TABLE borrow_records
+DROP CONSTRAINT IF EXISTS borrow_records_user_id_fkey,
+ADD CONSTRAINT borrow_records_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+
+ALTER TABLE borrow_records
+DROP CONSTRAINT IF EXISTS borrow_records_book_id_fkey,
+ADD CONSTRAINT borrow_records_book_id_fkey FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE RESTRICT;
+
+INSERT INTO settings (key, value)
+VALUES ('trash_retention_days', '30')
+ON CONFLICT (key) DO NOTHING;
+
+-- Suggestions should only come from books still in the catalogue.
+DROP MATERIALIZED VIEW IF EXISTS search_terms;
+
+CREATE MATERIALIZED VIEW search_terms AS
+SELECT DISTINCT term
+FROM books
+CROSS JOIN regexp_split_to_table(lower(title || ' ' || author), '[^[:alnum:]]+') AS term
+WHERE length(term) >= 3 AND deleted_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS search_terms_term_idx ON search_terms (term);
+CREATE INDEX IF NOT EXISTS search_terms_trgm_idx ON search_terms USING gin (term gin_trgm_ops);
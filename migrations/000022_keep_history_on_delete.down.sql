@@ -0,0 +1,19 @@
+ALTER TABLE fine_transactions
+DROP CONSTRAINT IF EXISTS fine_transactions_user_id_fkey,
+ADD CONSTRAINT fine_transactions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+ALTER TABLE copies
+DROP CONSTRAINT IF EXISTS copies_book_id_fkey,
+ADD CONSTRAINT copies_book_id_fkey FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE;
+
+ALTER TABLE holds
+DROP CONSTRAINT IF EXISTS holds_book_id_fkey,
+ADD CONSTRAINT holds_book_id_fkey FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE;
+
+ALTER TABLE holds
+DROP CONSTRAINT IF EXISTS holds_user_id_fkey,
+ADD CONSTRAINT holds_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+-- Fails if a member in the trash shares an email with an active one.
+DROP INDEX IF EXISTS users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
@@ -0,0 +1,23 @@
+-- A member in the trash no longer holds on to their email address, so it can
+-- be used to sign up again. The index keeps the old constraint's name so a
+-- duplicate reads the same to the application.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE deleted_at IS NULL;
+
+-- Holds, copies and fines are only removed on purpose, by the purge, never as
+-- a side effect of deleting a book or member.
+ALTER TABLE holds
+DROP CONSTRAINT IF EXISTS holds_user_id_fkey,
+ADD CONSTRAINT holds_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+
+ALTER TABLE holds
+DROP CONSTRAINT IF EXISTS holds_book_id_fkey,
+ADD CONSTRAINT holds_book_id_fkey FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE RESTRICT;
+
+ALTER TABLE copies
+DROP CONSTRAINT IF EXISTS copies_book_id_fkey,
+ADD CONSTRAINT copies_book_id_fkey FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE RESTRICT;
+
+ALTER TABLE fine_transactions
+DROP CONSTRAINT IF EXISTS fine_transactions_user_id_fkey,
+ADD CONSTRAINT fine_transactions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
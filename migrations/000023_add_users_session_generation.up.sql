@@ -0,0 +1,3 @@
+-- Sessions record the generation they were logged in under; bumping it signs
+-- the member out everywhere at once.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS session_generation integer NOT NULL DEFAULT 0;
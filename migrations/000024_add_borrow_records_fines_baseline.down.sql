@@ -0,0 +1 @@
+ALTER TABLE borrow_records DROP COLUMN IF EXISTS fines_baseline_cents;
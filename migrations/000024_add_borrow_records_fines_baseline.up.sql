@@ -0,0 +1,4 @@
+-- What a loan was charged in overdue periods before it was last renewed.
+-- Accrual adds the current period's fine on top, so a renewal starts a fresh
+-- period instead of counting the days already charged against the new one.
+ALTER TABLE borrow_records ADD COLUMN IF NOT EXISTS fines_baseline_cents integer NOT NULL DEFAULT 0;
@@ -0,0 +1,36 @@
+DROP FUNCTION IF EXISTS circulation_policy_for(bigint, bigint);
+
+-- Rules scoped to a tier or item type have no equivalent without them.
+DELETE FROM circulation_policies WHERE tier IS NOT NULL OR item_type IS NOT NULL;
+
+DROP INDEX IF EXISTS circulation_policies_scope_idx;
+
+ALTER TABLE circulation_policies
+DROP COLUMN IF EXISTS tier,
+DROP COLUMN IF EXISTS item_type;
+
+CREATE UNIQUE INDEX circulation_policies_scope_idx
+ON circulation_policies (COALESCE(role, ''), COALESCE(genre, ''));
+
+CREATE FUNCTION circulation_policy_for(p_user_id bigint, p_book_id bigint)
+RETURNS SETOF circulation_policies
+LANGUAGE sql STABLE AS $$
+  SELECT p.*
+  FROM circulation_policies p
+  CROSS JOIN users u
+  CROSS JOIN books b
+  WHERE u.id = p_user_id
+    AND b.id = p_book_id
+    AND (p.role IS NULL OR p.role = u.role)
+    AND (p.genre IS NULL OR p.genre = ANY (b.genres))
+  ORDER BY
+    (p.role IS NOT NULL AND p.genre IS NOT NULL) DESC,
+    (p.genre IS NOT NULL) DESC,
+    (p.role IS NOT NULL) DESC,
+    p.loan_days,
+    p.id
+  LIMIT 1
+$$;
+
+ALTER TABLE books DROP COLUMN IF EXISTS item_type;
+ALTER TABLE users DROP COLUMN IF EXISTS tier;
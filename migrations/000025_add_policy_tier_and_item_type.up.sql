@@ -0,0 +1,47 @@
+-- Members can be put in a tier, such as staff or student, and books have an
+-- item type, such as reference or dvd. Circulation policies can be scoped to
+-- either, as well as to a role and a genre.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tier text NULL;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS item_type text NOT NULL DEFAULT 'book';
+
+ALTER TABLE circulation_policies
+ADD COLUMN IF NOT EXISTS tier text NULL,
+ADD COLUMN IF NOT EXISTS item_type text NULL;
+
+DROP INDEX IF EXISTS circulation_policies_scope_idx;
+CREATE UNIQUE INDEX circulation_policies_scope_idx
+ON circulation_policies (
+  COALESCE(role, ''), COALESCE(tier, ''), COALESCE(genre, ''), COALESCE(item_type, '')
+);
+
+-- circulation_policy_for picks the rule that applies when the user borrows
+-- the book. The rule scoped to the most of role, tier, genre and item type
+-- wins; between rules scoped to as many, a book's item type beats its genre,
+-- which beats the member's tier, which beats their role. Ties go to the
+-- shorter loan period.
+DROP FUNCTION IF EXISTS circulation_policy_for(bigint, bigint);
+
+CREATE FUNCTION circulation_policy_for(p_user_id bigint, p_book_id bigint)
+RETURNS SETOF circulation_policies
+LANGUAGE sql STABLE AS $$
+  SELECT p.*
+  FROM circulation_policies p
+  CROSS JOIN users u
+  CROSS JOIN books b
+  WHERE u.id = p_user_id
+    AND b.id = p_book_id
+    AND (p.role IS NULL OR p.role = u.role)
+    AND (p.tier IS NULL OR p.tier = u.tier)
+    AND (p.genre IS NULL OR p.genre = ANY (b.genres))
+    AND (p.item_type IS NULL OR p.item_type = b.item_type)
+  ORDER BY
+    (p.role IS NOT NULL)::int + (p.tier IS NOT NULL)::int
+      + (p.genre IS NOT NULL)::int + (p.item_type IS NOT NULL)::int DESC,
+    (p.item_type IS NOT NULL) DESC,
+    (p.genre IS NOT NULL) DESC,
+    (p.tier IS NOT NULL) DESC,
+    (p.role IS NOT NULL) DESC,
+    p.loan_days,
+    p.id
+  LIMIT 1
+$$;
@@ -1,15 +1,18 @@
-let page = 1;
+const grid = document.getElementById("books-grid");
+let cursor = grid.dataset.nextCursor;
 let loading = false;
 
 window.addEventListener("scroll", async () => {
-  if (loading) return;
+  if (loading || !cursor) return;
 
   if (window.innerHeight + window.scrollY >= document.body.offsetHeight - 100) {
     loading = true;
-    page++;
-    const res = await fetch(`/books?page=${page}`);
-    const html = await res.text();
-    document.querySelector(".books-grid").insertAdjacentHTML("beforeend", html);
+    const res = await fetch(`/books?cursor=${encodeURIComponent(cursor)}`);
+    if (res.ok) {
+      cursor = res.headers.get("X-Next-Cursor");
+      const html = await res.text();
+      grid.insertAdjacentHTML("beforeend", html);
+    }
     loading = false;
   }
 });
@@ -0,0 +1,110 @@
+const searchForm = document.querySelector(".nav-search form");
+const searchInput = searchForm.querySelector('input[name="q"]');
+const suggestionList = document.createElement("ul");
+suggestionList.className = "search-suggestions";
+suggestionList.hidden = true;
+searchForm.appendChild(suggestionList);
+
+let suggestTimer;
+let suggestController;
+let activeIndex = -1;
+
+function suggestionURL(s) {
+  if (s.kind === "author") {
+    return `/authors/${s.author_id}`;
+  }
+  return `/books/${s.book_id}`;
+}
+
+function hideSuggestions() {
+  suggestionList.hidden = true;
+  suggestionList.replaceChildren();
+  activeIndex = -1;
+}
+
+function showSuggestions(suggestions) {
+  suggestionList.replaceChildren();
+  activeIndex = -1;
+
+  for (const s of suggestions) {
+    const link = document.createElement("a");
+    link.href = suggestionURL(s);
+
+    const value = document.createElement("span");
+    value.textContent = s.value;
+    const kind = document.createElement("span");
+    kind.className = "suggestion-kind";
+    kind.textContent = s.kind;
+    link.append(value, kind);
+
+    const item = document.createElement("li");
+    item.appendChild(link);
+    suggestionList.appendChild(item);
+  }
+
+  suggestionList.hidden = suggestions.length === 0;
+}
+
+function setActive(index) {
+  const links = suggestionList.querySelectorAll("a");
+  if (links.length === 0) return;
+
+  activeIndex = (index + links.length) % links.length;
+  links.forEach((link, i) => link.classList.toggle("active", i === activeIndex));
+}
+
+async function fetchSuggestions(q) {
+  if (suggestController) suggestController.abort();
+  suggestController = new AbortController();
+
+  try {
+    const res = await fetch(`/search/suggest?q=${encodeURIComponent(q)}`, {
+      signal: suggestController.signal,
+    });
+    if (!res.ok) return;
+    const body = await res.json();
+    showSuggestions(body.suggestions);
+  } catch (err) {
+    if (err.name !== "AbortError") hideSuggestions();
+  }
+}
+
+searchInput.addEventListener("input", () => {
+  clearTimeout(suggestTimer);
+
+  const q = searchInput.value.trim();
+  if (q.length < 2) {
+    hideSuggestions();
+    return;
+  }
+
+  suggestTimer = setTimeout(() => fetchSuggestions(q), 200);
+});
+
+searchInput.addEventListener("keydown", (e) => {
+  if (suggestionList.hidden) return;
+
+  switch (e.key) {
+    case "ArrowDown":
+      e.preventDefault();
+      setActive(activeIndex + 1);
+      break;
+    case "ArrowUp":
+      e.preventDefault();
+      setActive(activeIndex - 1);
+      break;
+    case "Enter":
+      if (activeIndex >= 0) {
+        e.preventDefault();
+        suggestionList.querySelectorAll("a")[activeIndex].click();
+      }
+      break;
+    case "Escape":
+      hideSuggestions();
+      break;
+  }
+});
+
+document.addEventListener("click", (e) => {
+  if (!searchForm.contains(e.target)) hideSuggestions();
+});